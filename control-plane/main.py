@@ -25,7 +25,7 @@ async def update_config(config: GatewayConfig):
     """Update gateway configuration"""
     try:
         with open(CONFIG_FILE, 'w') as f:
-            json.dump(config.dict(), f, indent=2)
+            json.dump(config.dict(exclude_none=True), f, indent=2)
         return {"message": "Configuration updated", "config": config.dict()}
     except Exception as e:
         raise HTTPException(status_code=500, detail=str(e))
@@ -42,12 +42,12 @@ async def add_route(route: RouteConfig):
         found = False
         for i, r in enumerate(routes):
             if r['path'] == route.path:
-                routes[i] = route.dict()
+                routes[i] = route.dict(exclude_none=True)
                 found = True
                 break
         
         if not found:
-            routes.append(route.dict())
+            routes.append(route.dict(exclude_none=True))
         
         config_data['routes'] = routes
         
@@ -1,20 +1,114 @@
 from pydantic import BaseModel
-from typing import List
+from typing import Dict, List, Optional
+
+class PredicateConfig(BaseModel):
+    type: str
+    name: Optional[str] = None
+    value: Optional[str] = None
+    values: Optional[List[str]] = None
+
+class RewriteConfig(BaseModel):
+    strip_prefix: Optional[str] = None
+    add_prefix: Optional[str] = None
+    regex: Optional[str] = None
+    replacement: Optional[str] = None
+    template: Optional[str] = None
+
+class CircuitOverride(BaseModel):
+    failure_threshold: Optional[int] = None
+    success_threshold: Optional[int] = None
+    timeout_seconds: Optional[int] = None
+
+class RetryConfig(BaseModel):
+    max_attempts: int = 3
+    retry_on: List[str] = ["connect_error", "reset"]
+    status_codes: Optional[List[int]] = None
+    base_backoff_ms: int = 25
+    max_backoff_ms: int = 250
+    retry_non_idempotent: bool = False
+    max_body_bytes: int = 1048576
+
+class HedgeConfig(BaseModel):
+    delay_ms: Optional[int] = None
+    percentile: float = 95
+    min_delay_ms: int = 5
+    max_hedges: int = 1
+
+class UpgradeConfig(BaseModel):
+    idle_timeout_seconds: int = 300
+    max_lifetime_seconds: int = 3600
+    max_connections: int = 1000
+
+class RouteJWTConfig(BaseModel):
+    scopes: Optional[List[str]] = None
+    claims: Optional[Dict[str, List[str]]] = None
+
+class RateLimitKeyConfig(BaseModel):
+    type: str
+    name: Optional[str] = None
 
 class RouteConfig(BaseModel):
     path: str
-    backend: str
+    backend: Optional[str] = None
+    upstream: Optional[str] = None
     methods: List[str]
     rate_limit_per_minute: int = 100
     timeout_seconds: int = 30
     enable_cache: bool = False
+    cache_authenticated: bool = False
     health_check: bool = False
+    flush_interval_ms: Optional[int] = None
+    predicates: Optional[List[PredicateConfig]] = None
+    rewrite: Optional[RewriteConfig] = None
+    circuit_breaker: Optional[CircuitOverride] = None
+    retry: Optional[RetryConfig] = None
+    hedge: Optional[HedgeConfig] = None
+    upgrade: Optional[UpgradeConfig] = None
+    jwt: Optional[RouteJWTConfig] = None
+    api_key: bool = False
+    rate_limit_key: Optional[List[RateLimitKeyConfig]] = None
+    rate_limit_key_missing: Optional[str] = None
+
+class TargetConfig(BaseModel):
+    url: str
+    weight: int = 1
+
+class HashKeyConfig(BaseModel):
+    source: str
+    name: Optional[str] = None
+
+class HealthCheckOverride(BaseModel):
+    path: Optional[str] = None
+    interval_seconds: Optional[int] = None
+    timeout_seconds: Optional[int] = None
+    expected_status_min: Optional[int] = None
+    expected_status_max: Optional[int] = None
+    expected_body: Optional[str] = None
+    healthy_threshold: Optional[int] = None
+    unhealthy_threshold: Optional[int] = None
+
+class UpstreamTLSConfig(BaseModel):
+    ca_file: Optional[str] = None
+    cert_file: Optional[str] = None
+    key_file: Optional[str] = None
+    server_name: Optional[str] = None
+    insecure_skip_verify: Optional[bool] = None
+
+class UpstreamConfig(BaseModel):
+    name: str
+    targets: List[TargetConfig]
+    strategy: str = "round_robin"
+    hash_key: Optional[HashKeyConfig] = None
+    health_check: Optional[HealthCheckOverride] = None
+    protocol: Optional[str] = None
+    tls: Optional[UpstreamTLSConfig] = None
 
 class RateLimitConfig(BaseModel):
     enabled: bool = True
     burst_size: int = 10
     default_rate_per_minute: int = 1000
     num_shards: int = 16
+    trusted_proxies: Optional[List[str]] = None
 
 class CircuitConfig(BaseModel):
     enabled: bool = True
@@ -22,11 +116,30 @@ class CircuitConfig(BaseModel):
     success_threshold: int = 3
     timeout_seconds: int = 60
     health_decay: float = 0.95
+    scope: str = "target"
+    max_breakers: int = 1000
+    failure_status_codes: List[int] = [502, 503, 504]
+    failure_grpc_codes: List[int] = [4, 13, 14]
+    slow_call_threshold_ms: int = 0
+    failure_rate_threshold: float = 0
+    slow_call_rate_threshold: float = 0
+    window_seconds: int = 60
+    minimum_calls: int = 20
+    half_open_max_calls: int = 1
+    backoff_multiplier: float = 2
+    max_timeout_seconds: int = 600
 
 class CacheConfig(BaseModel):
     enabled: bool = True
     max_size_mb: int = 100
+    max_entries: int = 1000
     ttl_seconds: int = 300
+    max_object_bytes: int = 1048576
+    grace_seconds: int = 600
+    stale_while_revalidate_seconds: int = 0
+    stale_if_error_seconds: int = 0
+    purge_enabled: Optional[bool] = None
+    purge_token: Optional[str] = None
 
 class PoolConfig(BaseModel):
     max_connections: int = 1000
@@ -44,13 +157,88 @@ class LoadShedConfig(BaseModel):
     max_queue_depth: int = 1000
     cpu_percent_limit: int = 90
 
+class HealthCheckConfig(BaseModel):
+    path: str = "/health"
+    interval_seconds: int = 10
+    timeout_seconds: int = 2
+    expected_status_min: int = 200
+    expected_status_max: int = 399
+    expected_body: Optional[str] = None
+    healthy_threshold: int = 2
+    unhealthy_threshold: int = 3
+
+class RetryBudgetConfig(BaseModel):
+    percent: float = 20
+    min_retries_per_second: int = 10
+    window_seconds: int = 10
+
+class HedgeBudgetConfig(BaseModel):
+    percent: float = 10
+    window_seconds: int = 10
+
+class HTTP2Config(BaseModel):
+    h2c: bool = True
+    max_concurrent_streams: int = 250
+
+class CertificateConfig(BaseModel):
+    cert_file: str
+    key_file: str
+    hosts: Optional[List[str]] = None
+
+class ClientAuthConfig(BaseModel):
+    mode: str = "require"
+    ca_file: str
+    identity: str = "subject"
+    identity_header: str = "X-Client-Cert-Identity"
+    rate_limit_by_identity: bool = False
+
+class TLSConfig(BaseModel):
+    enabled: bool = False
+    listen_addr: str = ":8443"
+    certificates: List[CertificateConfig] = []
+    min_version: str = "1.2"
+    cipher_suites: Optional[List[str]] = None
+    redirect_hosts: Optional[List[str]] = None
+    client_auth: Optional[ClientAuthConfig] = None
+
+class JWTKeyConfig(BaseModel):
+    kid: Optional[str] = None
+    alg: str
+    secret: Optional[str] = None
+    public_key_file: Optional[str] = None
+
+class JWTConfig(BaseModel):
+    issuer: Optional[str] = None
+    audiences: Optional[List[str]] = None
+    leeway_seconds: int = 60
+    keys: Optional[List[JWTKeyConfig]] = None
+    jwks_url: Optional[str] = None
+    jwks_refresh_seconds: int = 300
+    forward_claims: Optional[Dict[str, str]] = None
+    rate_limit_claim: Optional[str] = None
+
+class APIKeysConfig(BaseModel):
+    file: Optional[str] = None
+    header: str = "X-API-Key"
+    query_param: Optional[str] = None
+
 class GatewayConfig(BaseModel):
     listen_addr: str = ":8080"
     metrics_addr: str = ":9090"
     routes: List[RouteConfig]
+    upstreams: Optional[List[UpstreamConfig]] = None
     rate_limit: RateLimitConfig = RateLimitConfig()
     circuit_breaker: CircuitConfig = CircuitConfig()
     cache: CacheConfig = CacheConfig()
     connection_pool: PoolConfig = PoolConfig()
     timeouts: TimeoutConfig = TimeoutConfig()
     load_shedding: LoadShedConfig = LoadShedConfig()
+    health_checks: HealthCheckConfig = HealthCheckConfig()
+    retry_budget: RetryBudgetConfig = RetryBudgetConfig()
+    hedge_budget: HedgeBudgetConfig = HedgeBudgetConfig()
+    http2: HTTP2Config = HTTP2Config()
+    tls: TLSConfig = TLSConfig()
+    jwt: JWTConfig = JWTConfig()
+    api_keys: APIKeysConfig = APIKeysConfig()
+    access_log: bool = False
+    debug_routes: Optional[bool] = None
@@ -0,0 +1,169 @@
+package apikey
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// hashPrefix marks the only supported hash scheme. API keys are long and
+// random, so a fast unsalted digest is enough to keep leaked store files
+// from revealing usable keys.
+const hashPrefix = "sha256:"
+
+// Reasons a key is rejected
+var (
+	ErrUnknown = errors.New("unknown API key")
+	ErrExpired = errors.New("API key expired")
+)
+
+// Key is one issued API key. Only its hash is stored.
+type Key struct {
+	ID        string     `json:"id"`
+	Hash      string     `json:"hash"` // "sha256:" and the hex digest of the key
+	Owner     string     `json:"owner,omitempty"`
+	Plan      string     `json:"plan,omitempty"`
+	Routes    []string   `json:"routes,omitempty"`                // route paths the key may call; empty allows all
+	RateLimit int        `json:"rate_limit_per_minute,omitempty"` // overrides the route's limit when set
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// Allows reports whether k may call the route with path
+func (k *Key) Allows(path string) bool {
+	if len(k.Routes) == 0 {
+		return true
+	}
+	for _, p := range k.Routes {
+		if p == path {
+			return true
+		}
+	}
+	return false
+}
+
+// Expired reports whether k has expired at now
+func (k *Key) Expired(now time.Time) bool {
+	return k.ExpiresAt != nil && !now.Before(*k.ExpiresAt)
+}
+
+// Store is an immutable set of keys indexed by hash. Reloads build a new
+// one.
+type Store struct {
+	keys   []*Key
+	byHash map[string]*Key
+}
+
+// Load reads a key file: a JSON object with a "keys" list
+func Load(path string) (*Store, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+// Parse decodes a key file and checks that IDs and hashes are unique and
+// well formed
+func Parse(data []byte) (*Store, error) {
+	var file struct {
+		Keys []*Key `json:"keys"`
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&file); err != nil {
+		return nil, err
+	}
+
+	s := &Store{keys: file.Keys, byHash: make(map[string]*Key, len(file.Keys))}
+	ids := make(map[string]bool, len(file.Keys))
+	for i, k := range file.Keys {
+		if k == nil || k.ID == "" {
+			return nil, fmt.Errorf("keys[%d]: id is required", i)
+		}
+		if ids[k.ID] {
+			return nil, fmt.Errorf("keys[%d]: duplicate id %q", i, k.ID)
+		}
+		ids[k.ID] = true
+
+		k.Hash = strings.ToLower(k.Hash)
+		if !validHash(k.Hash) {
+			return nil, fmt.Errorf("keys[%d]: hash must be %q followed by 64 hex digits", i, hashPrefix)
+		}
+		if other := s.byHash[k.Hash]; other != nil {
+			return nil, fmt.Errorf("keys[%d]: same hash as %q", i, other.ID)
+		}
+		if k.RateLimit < 0 {
+			return nil, fmt.Errorf("keys[%d]: rate_limit_per_minute must not be negative, got %d", i, k.RateLimit)
+		}
+		s.byHash[k.Hash] = k
+	}
+	return s, nil
+}
+
+func validHash(h string) bool {
+	digest, ok := strings.CutPrefix(h, hashPrefix)
+	if !ok || len(digest) != 2*sha256.Size {
+		return false
+	}
+	_, err := hex.DecodeString(digest)
+	return err == nil
+}
+
+// Hash returns the stored form of a raw key
+func Hash(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hashPrefix + hex.EncodeToString(sum[:])
+}
+
+// Lookup returns the key matching raw, or an error wrapping ErrUnknown or
+// ErrExpired
+func (s *Store) Lookup(raw string, now time.Time) (*Key, error) {
+	k := s.byHash[Hash(raw)]
+	if k == nil {
+		return nil, ErrUnknown
+	}
+	if k.Expired(now) {
+		return nil, fmt.Errorf("%w at %s", ErrExpired, k.ExpiresAt.Format(time.RFC3339))
+	}
+	return k, nil
+}
+
+// Keys returns every key in the store
+func (s *Store) Keys() []*Key {
+	return s.keys
+}
+
+// Stats returns the number of keys by state
+func (s *Store) Stats() map[string]interface{} {
+	now := time.Now()
+	expired := 0
+	for _, k := range s.keys {
+		if k.Expired(now) {
+			expired++
+		}
+	}
+	return map[string]interface{}{
+		"keys":    len(s.keys),
+		"expired": expired,
+	}
+}
+
+type contextKey struct{}
+
+// WithKey returns a copy of ctx carrying k
+func WithKey(ctx context.Context, k *Key) context.Context {
+	return context.WithValue(ctx, contextKey{}, k)
+}
+
+// FromContext returns the key stored in ctx, or nil
+func FromContext(ctx context.Context) *Key {
+	k, _ := ctx.Value(contextKey{}).(*Key)
+	return k
+}
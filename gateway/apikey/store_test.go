@@ -0,0 +1,88 @@
+package apikey
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	hash := Hash("secret")
+	other := Hash("other")
+	tests := []struct {
+		name string
+		keys string // the keys list
+		err  string // substring of the error, empty for success
+	}{
+		{"valid", fmt.Sprintf(`{"id": "a", "hash": %q}, {"id": "b", "hash": %q, "rate_limit_per_minute": 10}`, hash, other), ""},
+		{"missing scheme", fmt.Sprintf(`{"id": "a", "hash": %q}`, hash[7:]), "hash must be"},
+		{"upper case", fmt.Sprintf(`{"id": "a", "hash": %q}`, strings.ToUpper(hash)), ""},
+		{"missing id", fmt.Sprintf(`{"hash": %q}`, hash), "keys[0]: id is required"},
+		{"null key", `null`, "keys[0]: id is required"},
+		{"duplicate id", fmt.Sprintf(`{"id": "a", "hash": %q}, {"id": "a", "hash": %q}`, hash, other), `keys[1]: duplicate id "a"`},
+		{"duplicate hash", fmt.Sprintf(`{"id": "a", "hash": %q}, {"id": "b", "hash": %q}`, hash, strings.ToUpper(hash)), `keys[1]: same hash as "a"`},
+		{"other scheme", `{"id": "a", "hash": "md5:0123"}`, "hash must be"},
+		{"short digest", fmt.Sprintf(`{"id": "a", "hash": %q}`, hash[:len(hash)-2]), "hash must be"},
+		{"not hex", fmt.Sprintf(`{"id": "a", "hash": %q}`, hash[:len(hash)-1]+"z"), "hash must be"},
+		{"negative rate limit", fmt.Sprintf(`{"id": "a", "hash": %q, "rate_limit_per_minute": -1}`, hash), "must not be negative, got -1"},
+		{"unknown field", fmt.Sprintf(`{"id": "a", "hash": %q, "secret": "x"}`, hash), "unknown field"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := Parse([]byte(`{"keys": [` + tt.keys + `]}`))
+			switch {
+			case tt.err == "" && err != nil:
+				t.Fatalf("Parse() error = %v", err)
+			case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Fatalf("Parse() error = %v, want %q", err, tt.err)
+			case tt.err == "" && len(s.Keys()) == 0:
+				t.Error("no keys parsed")
+			}
+		})
+	}
+}
+
+func TestLookup(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	s, err := Parse([]byte(fmt.Sprintf(`{"keys": [
+		{"id": "open", "hash": %q},
+		{"id": "expiring", "hash": %q, "expires_at": %q, "routes": ["/a"]}
+	]}`, Hash("open-key"), Hash("expiring-key"), now.Format(time.RFC3339))))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		raw  string
+		at   time.Time
+		id   string
+		err  error
+	}{
+		{"known", "open-key", now, "open", nil},
+		{"unknown", "guess", now, "", ErrUnknown},
+		{"hash instead of key", Hash("open-key"), now, "", ErrUnknown},
+		{"before expiry", "expiring-key", now.Add(-time.Second), "expiring", nil},
+		{"at expiry", "expiring-key", now, "", ErrExpired},
+		{"after expiry", "expiring-key", now.Add(time.Hour), "", ErrExpired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k, err := s.Lookup(tt.raw, tt.at)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Lookup() error = %v, want %v", err, tt.err)
+			}
+			if err == nil && k.ID != tt.id {
+				t.Errorf("Lookup() = %s, want %s", k.ID, tt.id)
+			}
+		})
+	}
+
+	open, _ := s.Lookup("open-key", now)
+	expiring, _ := s.Lookup("expiring-key", now.Add(-time.Second))
+	if !open.Allows("/b") || !expiring.Allows("/a") || expiring.Allows("/b") {
+		t.Error("Allows() ignores the key's routes")
+	}
+}
@@ -0,0 +1,163 @@
+package balancer
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+)
+
+// Strategy names accepted by New
+const (
+	RoundRobin         = "round_robin"
+	WeightedRoundRobin = "weighted_round_robin"
+	LeastOutstanding   = "least_outstanding"
+	RandomTwoChoices   = "random_two_choices"
+	ConsistentHash     = "consistent_hash"
+)
+
+// ErrNoTarget is returned when a pool has no target to offer
+var ErrNoTarget = errors.New("no upstream target available")
+
+// Balancer picks the upstream target for a request
+type Balancer interface {
+	Pick(r *http.Request) (*Target, error)
+}
+
+// Target is one upstream server in a pool
+type Target struct {
+	URL    *url.URL
+	Weight int
+
+	outstanding atomic.Int64
+	requests    atomic.Int64
+	unhealthy   atomic.Bool
+}
+
+// MaxWeight bounds target weights, which also bounds the consistent hash
+// ring to MaxWeight*virtualNodes points per target
+const MaxWeight = 100
+
+// NewTarget parses rawURL into a target with the given weight, clamped to
+// 1..MaxWeight
+func NewTarget(rawURL string, weight int) (*Target, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	weight = min(max(weight, 1), MaxWeight)
+	return &Target{URL: u, Weight: weight}, nil
+}
+
+// Acquire marks a request to the target as in flight. Callers must pair it
+// with Release.
+func (t *Target) Acquire() {
+	t.outstanding.Add(1)
+	t.requests.Add(1)
+}
+
+// Release marks an in-flight request as finished
+func (t *Target) Release() {
+	t.outstanding.Add(-1)
+}
+
+// Outstanding returns the number of in-flight requests
+func (t *Target) Outstanding() int64 {
+	return t.outstanding.Load()
+}
+
+// Healthy reports whether the target may receive traffic. Targets are
+// healthy until an active health check marks them otherwise.
+func (t *Target) Healthy() bool {
+	return !t.unhealthy.Load()
+}
+
+// SetHealthy marks the target as eligible or ineligible for traffic
+func (t *Target) SetHealthy(healthy bool) {
+	t.unhealthy.Store(!healthy)
+}
+
+// String returns the target URL
+func (t *Target) String() string {
+	return t.URL.String()
+}
+
+// New creates a balancer for targets using the named strategy. key is only
+// used by consistent hashing and may be nil otherwise. Every strategy skips
+// unhealthy targets and returns ErrNoTarget when none is healthy.
+func New(strategy string, targets []*Target, key KeyFunc) (Balancer, error) {
+	if len(targets) == 0 {
+		return nil, ErrNoTarget
+	}
+
+	switch strategy {
+	case "", RoundRobin:
+		return &roundRobin{targets: targets}, nil
+	case WeightedRoundRobin:
+		return newWeightedRoundRobin(targets), nil
+	case LeastOutstanding:
+		return &leastOutstanding{targets: targets}, nil
+	case RandomTwoChoices:
+		return &randomTwoChoices{targets: targets}, nil
+	case ConsistentHash:
+		if key == nil {
+			return nil, fmt.Errorf("consistent_hash requires a hash key")
+		}
+		return newConsistentHash(targets, key), nil
+	default:
+		return nil, fmt.Errorf("unknown strategy %q", strategy)
+	}
+}
+
+// Pool is a named set of targets with the balancer that chooses among them
+type Pool struct {
+	Name     string
+	Protocol string      // spoken to the targets, e.g. "http1" or "h2c"
+	TLS      *tls.Config // for https targets; nil means the defaults
+	Targets  []*Target
+	Balancer
+}
+
+// PickOther picks a target not in tried when the balancer offers one within
+// a few picks, falling back to whatever it picks. Strategies with affinity,
+// such as consistent hashing, keep returning the same target.
+func (p *Pool) PickOther(r *http.Request, tried []*Target) (*Target, error) {
+	var t *Target
+	for i := 0; i < len(p.Targets); i++ {
+		var err error
+		if t, err = p.Pick(r); err != nil {
+			return nil, err
+		}
+		if !contains(tried, t) {
+			return t, nil
+		}
+	}
+	return t, nil
+}
+
+func contains(targets []*Target, t *Target) bool {
+	for _, c := range targets {
+		if c == t {
+			return true
+		}
+	}
+	return false
+}
+
+// Stats returns per-target request and in-flight counts
+func (p *Pool) Stats() map[string]interface{} {
+	targets := make(map[string]interface{}, len(p.Targets))
+	for _, t := range p.Targets {
+		targets[t.String()] = map[string]interface{}{
+			"weight":      t.Weight,
+			"outstanding": t.Outstanding(),
+			"requests":    t.requests.Load(),
+			"healthy":     t.Healthy(),
+		}
+	}
+	return map[string]interface{}{
+		"targets": targets,
+	}
+}
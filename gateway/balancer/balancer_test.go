@@ -0,0 +1,283 @@
+package balancer
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// targets builds targets named a, b, c... with the given weights
+func targets(t *testing.T, weights ...int) []*Target {
+	t.Helper()
+	out := make([]*Target, len(weights))
+	for i, w := range weights {
+		target, err := NewTarget(fmt.Sprintf("http://%c", 'a'+i), w)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out[i] = target
+	}
+	return out
+}
+
+// picks returns the hosts of n successive picks, joined
+func picks(t *testing.T, b Balancer, r *http.Request, n int) string {
+	t.Helper()
+	var hosts []string
+	for i := 0; i < n; i++ {
+		target, err := b.Pick(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hosts = append(hosts, target.URL.Host)
+	}
+	return strings.Join(hosts, "")
+}
+
+func TestNewTarget(t *testing.T) {
+	tests := []struct {
+		weight, want int
+	}{
+		{0, 1}, {-3, 1}, {7, 7}, {MaxWeight + 1, MaxWeight},
+	}
+	for _, tt := range tests {
+		target, err := NewTarget("http://a", tt.weight)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if target.Weight != tt.want {
+			t.Errorf("NewTarget(weight %d).Weight = %d, want %d", tt.weight, target.Weight, tt.want)
+		}
+	}
+}
+
+func TestRotatingStrategies(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	tests := []struct {
+		name      string
+		strategy  string
+		weights   []int
+		unhealthy string // target hosts taken out of rotation
+		want      string
+	}{
+		{"round robin", RoundRobin, []int{1, 1, 1}, "", "abcabc"},
+		{"round robin ignores weights", RoundRobin, []int{5, 1}, "", "abab"},
+		{"round robin skips unhealthy", RoundRobin, []int{1, 1, 1}, "a", "bbcbbc"},
+		{"smooth weighted", WeightedRoundRobin, []int{5, 1, 1}, "", "aabacaa" + "aabacaa"},
+		{"smooth weighted even", WeightedRoundRobin, []int{2, 2}, "", "abab"},
+		{"smooth weighted skips unhealthy", WeightedRoundRobin, []int{5, 1, 1}, "a", "bcbc"},
+		{"least outstanding spreads ties", LeastOutstanding, []int{1, 1, 1}, "", "bcabca"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := targets(t, tt.weights...)
+			for _, target := range ts {
+				target.SetHealthy(!strings.Contains(tt.unhealthy, target.URL.Host))
+			}
+			b, err := New(tt.strategy, ts, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := picks(t, b, r, len(tt.want)); got != tt.want {
+				t.Errorf("picked %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadAwareStrategies(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	tests := []struct {
+		name        string
+		weights     []int
+		outstanding []int
+		unhealthy   string
+		want        string // the only acceptable pick
+	}{
+		{"fewest in flight", []int{1, 1}, []int{3, 1}, "", "b"},
+		{"relative to weight", []int{4, 1}, []int{3, 1}, "", "a"},
+		{"unhealthy skipped", []int{1, 1}, []int{3, 1}, "b", "a"},
+	}
+	for _, strategy := range []string{LeastOutstanding, RandomTwoChoices} {
+		for _, tt := range tests {
+			t.Run(strategy+" "+tt.name, func(t *testing.T) {
+				ts := targets(t, tt.weights...)
+				for i, target := range ts {
+					for j := 0; j < tt.outstanding[i]; j++ {
+						target.Acquire()
+					}
+					target.SetHealthy(!strings.Contains(tt.unhealthy, target.URL.Host))
+				}
+				b, _ := New(strategy, ts, nil)
+				if got := picks(t, b, r, 20); got != strings.Repeat(tt.want, 20) {
+					t.Errorf("picked %s, want only %s", got, tt.want)
+				}
+			})
+		}
+	}
+}
+
+func TestRandomTwoChoicesAvoidsTheMostLoaded(t *testing.T) {
+	ts := targets(t, 1, 1, 1, 1)
+	for i, target := range ts {
+		for j := 0; j < i; j++ {
+			target.Acquire()
+		}
+	}
+	b, _ := New(RandomTwoChoices, ts, nil)
+	got := picks(t, b, httptest.NewRequest(http.MethodGet, "/", nil), 200)
+	if strings.Contains(got, "d") {
+		t.Error("picked the most loaded target")
+	}
+	if !strings.Contains(got, "a") || !strings.Contains(got, "b") {
+		t.Errorf("picks %s don't spread over the less loaded targets", got)
+	}
+}
+
+func TestNoHealthyTarget(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	key := func(r *http.Request) string { return "k" }
+	for _, strategy := range []string{RoundRobin, WeightedRoundRobin, LeastOutstanding, RandomTwoChoices, ConsistentHash} {
+		ts := targets(t, 1, 1)
+		for _, target := range ts {
+			target.SetHealthy(false)
+		}
+		b, err := New(strategy, ts, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := b.Pick(r); !errors.Is(err, ErrNoTarget) {
+			t.Errorf("%s: Pick() error = %v, want ErrNoTarget", strategy, err)
+		}
+	}
+}
+
+// owners maps each of n keys to the host consistent hashing picks for it
+func owners(t *testing.T, ts []*Target, n int) map[string]string {
+	t.Helper()
+	b, err := New(ConsistentHash, ts, func(r *http.Request) string { return r.Header.Get("Key") })
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := make(map[string]string, n)
+	for i := 0; i < n; i++ {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		key := fmt.Sprintf("user-%d", i)
+		r.Header.Set("Key", key)
+		out[key] = picks(t, b, r, 1)
+	}
+	return out
+}
+
+func TestConsistentHashStability(t *testing.T) {
+	const keys = 2000
+	four := targets(t, 1, 1, 1, 1, 1)[:4]
+	five := targets(t, 1, 1, 1, 1, 1)
+	before := owners(t, four, keys)
+
+	tests := []struct {
+		name    string
+		targets []*Target
+		// moved reports whether a key may change owner from old to new
+		moved func(old, new string) bool
+		share float64 // expected fraction of keys moving
+	}{
+		{"target added", five, func(old, new string) bool { return new == "e" }, 0.2},
+		{"target removed", four[1:], func(old, new string) bool { return old == "a" }, 0.25},
+		{"weight raised", targets(t, 3, 1, 1, 1), func(old, new string) bool { return new == "a" }, 0.25},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			moved := 0
+			for key, owner := range owners(t, tt.targets, keys) {
+				if owner == before[key] {
+					continue
+				}
+				moved++
+				if !tt.moved(before[key], owner) {
+					t.Fatalf("key %s moved from %s to %s", key, before[key], owner)
+				}
+			}
+			if share := float64(moved) / keys; share < tt.share*0.6 || share > tt.share*1.4 {
+				t.Errorf("%.0f%% of keys moved, want about %.0f%%", share*100, tt.share*100)
+			}
+		})
+	}
+}
+
+func TestConsistentHashFailover(t *testing.T) {
+	ts := targets(t, 1, 1, 1)
+	b, _ := New(ConsistentHash, ts, func(r *http.Request) string { return r.Header.Get("Key") })
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Key", "user-1")
+
+	owner, _ := b.Pick(r)
+	owner.SetHealthy(false)
+	other, err := b.Pick(r)
+	if err != nil || other == owner {
+		t.Fatalf("Pick() = %v, %v with the owner down", other, err)
+	}
+	if again, _ := b.Pick(r); again != other {
+		t.Error("failover target isn't stable")
+	}
+	owner.SetHealthy(true)
+	if back, _ := b.Pick(r); back != owner {
+		t.Error("key didn't return to its owner once healthy")
+	}
+
+	// Requests without a key rotate
+	if got := picks(t, b, httptest.NewRequest(http.MethodGet, "/", nil), 3); got != "abc" {
+		t.Errorf("keyless picks = %s, want abc", got)
+	}
+}
+
+func TestPickOther(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Key", "user-1")
+	key := func(r *http.Request) string { return r.Header.Get("Key") }
+
+	tests := []struct {
+		name     string
+		strategy string
+		tried    string
+		want     string // acceptable picks
+	}{
+		{"untried target", RoundRobin, "a", "bc"},
+		{"last untried target", RoundRobin, "ab", "c"},
+		{"all tried", RoundRobin, "abc", "abc"},
+		{"weighted", WeightedRoundRobin, "a", "bc"},
+		{"affinity kept", ConsistentHash, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := targets(t, 1, 1, 1)
+			b, _ := New(tt.strategy, ts, key)
+			pool := &Pool{Targets: ts, Balancer: b}
+
+			want := tt.want
+			var tried []*Target
+			if tt.strategy == ConsistentHash {
+				owner, _ := pool.Pick(r)
+				tried = []*Target{owner}
+				want = owner.URL.Host
+			}
+			for _, target := range ts {
+				if strings.Contains(tt.tried, target.URL.Host) {
+					tried = append(tried, target)
+				}
+			}
+			for i := 0; i < 5; i++ {
+				got, err := pool.PickOther(r, tried)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !strings.Contains(want, got.URL.Host) {
+					t.Fatalf("PickOther() = %s, want one of %s", got.URL.Host, want)
+				}
+			}
+		})
+	}
+}
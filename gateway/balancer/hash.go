@@ -0,0 +1,138 @@
+package balancer
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync/atomic"
+
+	"gateway/router"
+)
+
+// KeyFunc extracts the consistent-hashing key from a request. An empty key
+// means the request has no affinity.
+type KeyFunc func(r *http.Request) string
+
+// Hash key sources accepted by NewKeyFunc
+const (
+	KeyHeader    = "header"
+	KeyCookie    = "cookie"
+	KeyClientIP  = "client_ip"
+	KeyPathParam = "path_param"
+)
+
+// NewKeyFunc builds a KeyFunc reading the named header, cookie or path
+// parameter, or the client IP
+func NewKeyFunc(source, name string) (KeyFunc, error) {
+	switch source {
+	case KeyHeader:
+		if name == "" {
+			return nil, fmt.Errorf("header hash key needs a name")
+		}
+		return func(r *http.Request) string { return r.Header.Get(name) }, nil
+	case KeyCookie:
+		if name == "" {
+			return nil, fmt.Errorf("cookie hash key needs a name")
+		}
+		return func(r *http.Request) string {
+			if c, err := r.Cookie(name); err == nil {
+				return c.Value
+			}
+			return ""
+		}, nil
+	case KeyClientIP:
+		return func(r *http.Request) string {
+			if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+				return host
+			}
+			return r.RemoteAddr
+		}, nil
+	case KeyPathParam:
+		if name == "" {
+			return nil, fmt.Errorf("path_param hash key needs a name")
+		}
+		return func(r *http.Request) string {
+			if m := router.FromContext(r.Context()); m != nil {
+				return m.Params.Get(name)
+			}
+			return ""
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown hash key source %q", source)
+	}
+}
+
+// virtualNodes is the number of ring points per unit of weight
+const virtualNodes = 100
+
+// consistentHash maps keys onto a hash ring with weighted virtual nodes,
+// so adding or removing a target only remaps that target's share of keys.
+// Keys owned by an unhealthy target move to the next healthy owner on the
+// ring. Requests without a key fall back to round-robin.
+type consistentHash struct {
+	ring    []uint32
+	owners  []*Target
+	key     KeyFunc
+	targets []*Target
+	next    atomic.Uint64
+}
+
+func newConsistentHash(targets []*Target, key KeyFunc) *consistentHash {
+	type point struct {
+		hash   uint32
+		target *Target
+	}
+	var points []point
+	for _, t := range targets {
+		for i := 0; i < t.Weight*virtualNodes; i++ {
+			points = append(points, point{hash: hash32(t.String() + "#" + strconv.Itoa(i)), target: t})
+		}
+	}
+	sort.Slice(points, func(i, j int) bool { return points[i].hash < points[j].hash })
+
+	b := &consistentHash{
+		ring:    make([]uint32, len(points)),
+		owners:  make([]*Target, len(points)),
+		key:     key,
+		targets: targets,
+	}
+	for i, p := range points {
+		b.ring[i] = p.hash
+		b.owners[i] = p.target
+	}
+	return b
+}
+
+func (b *consistentHash) Pick(r *http.Request) (*Target, error) {
+	k := b.key(r)
+	if k == "" {
+		return nextHealthy(b.targets, &b.next)
+	}
+
+	h := hash32(k)
+	i := sort.Search(len(b.ring), func(i int) bool { return b.ring[i] >= h })
+	for n := 0; n < len(b.ring); n++ {
+		if t := b.owners[(i+n)%len(b.ring)]; t.Healthy() {
+			return t, nil
+		}
+	}
+	return nil, ErrNoTarget
+}
+
+// hash32 is FNV-1a followed by murmur3's finalizer. FNV-1a alone leaves
+// strings differing only in their last bytes, such as virtual node names,
+// clustered on the ring, giving some targets far more than their share.
+func hash32(s string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	x := h.Sum32()
+	x ^= x >> 16
+	x *= 0x85ebca6b
+	x ^= x >> 13
+	x *= 0xc2b2ae35
+	x ^= x >> 16
+	return x
+}
@@ -0,0 +1,148 @@
+package balancer
+
+import (
+	"math/rand"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// roundRobin cycles through targets ignoring weights
+type roundRobin struct {
+	targets []*Target
+	next    atomic.Uint64
+}
+
+func (b *roundRobin) Pick(r *http.Request) (*Target, error) {
+	return nextHealthy(b.targets, &b.next)
+}
+
+// nextHealthy returns the first healthy target at or after the counter's
+// position, advancing the counter
+func nextHealthy(targets []*Target, next *atomic.Uint64) (*Target, error) {
+	n := next.Add(1) - 1
+	for i := 0; i < len(targets); i++ {
+		t := targets[(n+uint64(i))%uint64(len(targets))]
+		if t.Healthy() {
+			return t, nil
+		}
+	}
+	return nil, ErrNoTarget
+}
+
+// weightedRoundRobin is nginx's smooth weighted round-robin: each pick
+// raises every healthy target's current weight by its weight and selects
+// the highest, which then pays back the healthy total. Heavier targets are
+// chosen proportionally more often without bursts.
+type weightedRoundRobin struct {
+	mu      sync.Mutex
+	targets []*Target
+	current []int
+}
+
+func newWeightedRoundRobin(targets []*Target) *weightedRoundRobin {
+	return &weightedRoundRobin{
+		targets: targets,
+		current: make([]int, len(targets)),
+	}
+}
+
+func (b *weightedRoundRobin) Pick(r *http.Request) (*Target, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	best, total := -1, 0
+	for i, t := range b.targets {
+		if !t.Healthy() {
+			continue
+		}
+		b.current[i] += t.Weight
+		total += t.Weight
+		if best < 0 || b.current[i] > b.current[best] {
+			best = i
+		}
+	}
+	if best < 0 {
+		return nil, ErrNoTarget
+	}
+	b.current[best] -= total
+	return b.targets[best], nil
+}
+
+// leastOutstanding picks the target with the fewest in-flight requests
+// relative to its weight. The scan starts at a rotating offset so ties are
+// spread instead of always landing on the first target.
+type leastOutstanding struct {
+	targets []*Target
+	offset  atomic.Uint64
+}
+
+func (b *leastOutstanding) Pick(r *http.Request) (*Target, error) {
+	n := len(b.targets)
+	start := int(b.offset.Add(1) % uint64(n))
+
+	var best *Target
+	for i := 0; i < n; i++ {
+		t := b.targets[(start+i)%n]
+		if !t.Healthy() {
+			continue
+		}
+		if best == nil || load(t) < load(best) {
+			best = t
+		}
+	}
+	if best == nil {
+		return nil, ErrNoTarget
+	}
+	return best, nil
+}
+
+// randomTwoChoices samples two distinct targets at random and keeps the
+// less loaded one, which avoids herding on a single "least loaded" target
+type randomTwoChoices struct {
+	targets []*Target
+}
+
+func (b *randomTwoChoices) Pick(r *http.Request) (*Target, error) {
+	targets := b.targets
+	for _, t := range b.targets {
+		if !t.Healthy() {
+			targets = healthy(b.targets)
+			break
+		}
+	}
+
+	n := len(targets)
+	switch n {
+	case 0:
+		return nil, ErrNoTarget
+	case 1:
+		return targets[0], nil
+	}
+
+	i := rand.Intn(n)
+	j := rand.Intn(n - 1)
+	if j >= i {
+		j++
+	}
+	a, c := targets[i], targets[j]
+	if load(c) < load(a) {
+		return c, nil
+	}
+	return a, nil
+}
+
+// healthy returns the healthy subset of targets
+func healthy(targets []*Target) []*Target {
+	var out []*Target
+	for _, t := range targets {
+		if t.Healthy() {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+func load(t *Target) float64 {
+	return float64(t.Outstanding()) / float64(t.Weight)
+}
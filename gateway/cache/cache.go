@@ -3,8 +3,8 @@ package cache
 import (
 	"crypto/sha256"
 	"encoding/hex"
+	"hash/maphash"
 	"sync"
-	"sync/atomic"
 	"time"
 	"unsafe"
 )
@@ -14,56 +14,107 @@ type Response struct {
 	StatusCode int
 	Headers    map[string][]string
 	Body       []byte
-	TTL        int64 // Unix timestamp
+	TTL        int64    // Unix timestamp
+	Stored     int64    // Unix timestamp the response was generated at
+	Vary       []string // set on the index of responses varying by these request headers
+	ETag       string   // the upstream's entity tag, or else a strong one computed from Body
+
+	// Host and URI (the path and query) identify the request the response
+	// answers, and Tags are its surrogate keys, for purging
+	Host string
+	URI  string
+	Tags []string
+
+	// Expired responses are kept for Grace seconds so they can be
+	// revalidated, and may be served while that happens or when it fails
+	// within the stale windows
+	Grace                int64
+	StaleWhileRevalidate int64
+	StaleIfError         int64
 }
 
-// Cache is a thread-safe in-memory cache with LRU eviction
+// Age returns how many seconds old the response is at now
+func (r *Response) Age(now int64) int64 {
+	return max(0, now-r.Stored)
+}
+
+// UsableOnError reports whether the response may stand in for a failed
+// revalidation at now
+func (r *Response) UsableOnError(now int64) bool {
+	return now <= r.TTL+r.StaleIfError
+}
+
+// Cache is a thread-safe in-memory cache bounded by entry count and
+// bytes. Keys are spread over independently locked shards, each evicting
+// with W-TinyLFU: a small LRU window admits new entries, and they are kept
+// past it only if requested more often than the segmented LRU entries they
+// would displace.
 type Cache struct {
-	data     map[string]*entry
+	shards []*cacheShard
+	seed   maphash.Seed
+
+	mu       sync.Mutex // serializes Resize
 	capacity int
 	maxSize  int64 // bytes
-	totalSize int64
-	mu       sync.RWMutex
 }
 
-type entry struct {
-	response *Response
-	lastUsed int64 // Unix nanosecond timestamp
-	size     int64
+type cacheShard struct {
+	mu sync.Mutex
+	*shard
 }
 
-// NewCache creates a new cache with the specified capacity and max size in bytes
+const (
+	// maxShards bounds the number of shards
+	maxShards = 16
+	// minShardBytes keeps small caches in few shards, since a response
+	// must fit in the byte budget of its shard
+	minShardBytes = 4 << 20
+)
+
+// NewCache creates a new cache with the specified capacity and max size in
+// bytes. The number of shards is fixed by the initial budgets.
 func NewCache(capacity int, maxSizeMB int) *Cache {
-	return &Cache{
-		data:     make(map[string]*entry, capacity),
-		capacity: capacity,
-		maxSize:  int64(maxSizeMB) * 1024 * 1024,
+	maxSize := int64(maxSizeMB) * 1024 * 1024
+	n := 1
+	for n*2 <= maxShards && n*2 <= capacity && maxSize/int64(n*2) >= minShardBytes {
+		n *= 2
 	}
-}
-
-// Get retrieves a cached response
-func (c *Cache) Get(key string) (*Response, bool) {
-	c.mu.RLock()
-	entry, exists := c.data[key]
-	c.mu.RUnlock()
 
-	if !exists {
-		return nil, false
+	c := &Cache{shards: make([]*cacheShard, n), seed: maphash.MakeSeed()}
+	c.capacity = capacity
+	c.maxSize = maxSize
+	for i := range c.shards {
+		c.shards[i] = &cacheShard{shard: newShard(c.shardBudget(i))}
 	}
+	return c
+}
 
-	// Check TTL
-	if entry.response.TTL < time.Now().Unix() {
-		c.mu.Lock()
-		delete(c.data, key)
-		c.totalSize -= entry.size
-		c.mu.Unlock()
-		return nil, false
+// shardBudget splits the cache's budgets evenly, so together the shards
+// never exceed them
+func (c *Cache) shardBudget(i int) budget {
+	n := len(c.shards)
+	b := budget{entries: c.capacity / n, bytes: c.maxSize / int64(n)}
+	if i < c.capacity%n {
+		b.entries++
+	}
+	if int64(i) < c.maxSize%int64(n) {
+		b.bytes++
 	}
+	return b
+}
 
-	// Update last used time
-	atomic.StoreInt64(&entry.lastUsed, time.Now().UnixNano())
+func (c *Cache) locate(key string) (*cacheShard, uint64) {
+	h := maphash.String(c.seed, key)
+	return c.shards[h&uint64(len(c.shards)-1)], h
+}
 
-	return entry.response, true
+// Get retrieves a cached response. Expired responses are returned until
+// their grace period ends.
+func (c *Cache) Get(key string) (*Response, bool) {
+	sh, h := c.locate(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	return sh.get(key, h, time.Now())
 }
 
 // Set stores a response in the cache
@@ -71,83 +122,77 @@ func (c *Cache) Set(key string, response *Response, ttlSeconds int) {
 	if ttlSeconds <= 0 {
 		return
 	}
+	response.TTL = time.Now().Unix() + int64(ttlSeconds)
 
-	now := time.Now()
-	response.TTL = now.Unix() + int64(ttlSeconds)
-	entry := &entry{
-		response: response,
-		lastUsed: now.UnixNano(),
-	}
-	
 	// Calculate size (approximate)
-	entry.size = int64(len(key) + len(response.Body))
+	size := int64(len(key) + len(response.Body))
 	for k, v := range response.Headers {
-		entry.size += int64(len(k))
+		size += int64(len(k))
 		for _, val := range v {
-			entry.size += int64(len(val))
+			size += int64(len(val))
 		}
 	}
 
-	c.mu.Lock()
-	defer c.mu.Unlock()
-
-	// Check if key exists and update
-	if oldEntry, exists := c.data[key]; exists {
-		c.totalSize -= oldEntry.size
-	}
-
-	// Evict if necessary
-	if c.totalSize + entry.size > c.maxSize {
-		c.evict()
-	}
-
-	// Evict if capacity exceeded
-	if len(c.data) >= c.capacity && entry.size+c.totalSize > c.maxSize {
-		c.evict()
-	}
-
-	c.data[key] = entry
-	c.totalSize += entry.size
+	sh, h := c.locate(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	sh.set(key, h, response, size)
 }
 
-func (c *Cache) evict() {
-	// Simple eviction: remove oldest entry
-	var oldestKey string
-	var oldestTime int64 = time.Now().UnixNano()
-
-	for k, v := range c.data {
-		if v.lastUsed < oldestTime {
-			oldestTime = v.lastUsed
-			oldestKey = k
-		}
-	}
+// Resize changes the entry and byte budgets, evicting entries until the
+// cache fits
+func (c *Cache) Resize(capacity, maxSizeMB int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-	if oldestKey != "" {
-		if oldEntry, exists := c.data[oldestKey]; exists {
-			c.totalSize -= oldEntry.size
-			delete(c.data, oldestKey)
-		}
+	c.capacity = capacity
+	c.maxSize = int64(maxSizeMB) * 1024 * 1024
+	for i, sh := range c.shards {
+		sh.mu.Lock()
+		sh.resize(c.shardBudget(i))
+		sh.mu.Unlock()
 	}
 }
 
 // Clear removes all entries
 func (c *Cache) Clear() {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	c.data = make(map[string]*entry)
-	c.totalSize = 0
+	for _, sh := range c.shards {
+		sh.mu.Lock()
+		sh.clear()
+		sh.mu.Unlock()
+	}
 }
 
 // Stats returns cache statistics
 func (c *Cache) Stats() map[string]interface{} {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
+	var entries int
+	var size, hits, misses, evictions, rejections, expirations int64
+	for _, sh := range c.shards {
+		sh.mu.Lock()
+		n, bytes := sh.usage()
+		entries += n
+		size += bytes
+		hits += sh.hits
+		misses += sh.misses
+		evictions += sh.evictions
+		rejections += sh.rejections
+		expirations += sh.expirations
+		sh.mu.Unlock()
+	}
 
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	return map[string]interface{}{
-		"entries":   len(c.data),
-		"size_mb":   float64(c.totalSize) / 1024 / 1024,
-		"capacity":  c.capacity,
-		"max_size":  c.maxSize,
+		"entries":     entries,
+		"size_mb":     float64(size) / 1024 / 1024,
+		"capacity":    c.capacity,
+		"max_size":    c.maxSize,
+		"shards":      len(c.shards),
+		"hits":        hits,
+		"misses":      misses,
+		"evictions":   evictions,
+		"rejections":  rejections, // not admitted, or too large
+		"expirations": expirations,
 	}
 }
 
@@ -0,0 +1,106 @@
+package cache
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAdmissionKeepsFrequentKeys(t *testing.T) {
+	s := newShard(budget{entries: 100, bytes: 1 << 20})
+	now := time.Now()
+	request := func(i int) {
+		key, hash := fmt.Sprint(i), uint64(i+1)*0x9e3779b97f4a7c15
+		if _, ok := s.get(key, hash, now); !ok {
+			s.set(key, hash, &Response{TTL: now.Unix() + 60}, 100)
+		}
+	}
+
+	// 50 popular keys, then a scan of one-off keys that would flush an LRU
+	for round := 0; round < 5; round++ {
+		for i := 0; i < 50; i++ {
+			request(i)
+		}
+	}
+	for i := 1000; i < 1150; i++ {
+		request(i)
+	}
+
+	for i := 0; i < 50; i++ {
+		if _, ok := s.data[fmt.Sprint(i)]; !ok {
+			t.Errorf("popular key %d evicted by the scan", i)
+		}
+	}
+	if n, _ := s.usage(); n > 100 {
+		t.Errorf("%d entries, want at most 100", n)
+	}
+	if s.rejections == 0 {
+		t.Error("no one-off keys rejected")
+	}
+}
+
+func TestShardBudgets(t *testing.T) {
+	tests := []struct {
+		capacity, maxSizeMB int
+		shards              int
+	}{
+		{1000, 100, 16},
+		{10, 100, 8},
+		{1000, 8, 2},
+		{1000, 1, 1},
+		{0, 100, 1},
+		{7, 13, 2},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%d entries %dMB", tt.capacity, tt.maxSizeMB), func(t *testing.T) {
+			c := NewCache(tt.capacity, tt.maxSizeMB)
+			if len(c.shards) != tt.shards {
+				t.Fatalf("%d shards, want %d", len(c.shards), tt.shards)
+			}
+			check := func(capacity, maxSizeMB int) {
+				t.Helper()
+				var entries int
+				var bytes int64
+				for i := range c.shards {
+					b := c.shardBudget(i)
+					entries += b.entries
+					bytes += b.bytes
+				}
+				if entries != capacity || bytes != int64(maxSizeMB)<<20 {
+					t.Errorf("shard budgets sum to %d entries and %d bytes, want %d and %d",
+						entries, bytes, capacity, int64(maxSizeMB)<<20)
+				}
+			}
+			check(tt.capacity, tt.maxSizeMB)
+			c.Resize(tt.capacity/3, tt.maxSizeMB/3)
+			check(tt.capacity/3, tt.maxSizeMB/3)
+		})
+	}
+}
+
+func TestCacheStaysWithinBudgets(t *testing.T) {
+	c := NewCache(1000, 1)
+	body := []byte(strings.Repeat("x", 64<<10))
+	for i := 0; i < 100; i++ {
+		c.Set(fmt.Sprint(i), &Response{Body: body}, 60)
+	}
+	stats := c.Stats()
+	if size := stats["size_mb"].(float64); size > 1 {
+		t.Errorf("size %.2fMB, want at most 1MB", size)
+	}
+
+	for i := 0; i < 100; i++ {
+		c.Set(fmt.Sprint("small", i), &Response{}, 60)
+	}
+	c.Resize(10, 1)
+	if n := c.Stats()["entries"].(int); n > 10 {
+		t.Errorf("%d entries after resizing, want at most 10", n)
+	}
+
+	// A response larger than its shard is refused rather than flushing it
+	c.Set("huge", &Response{Body: make([]byte, 2<<20)}, 60)
+	if _, ok := c.Get("huge"); ok {
+		t.Error("response larger than the cache was stored")
+	}
+}
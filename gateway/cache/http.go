@@ -0,0 +1,346 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Directives is a parsed Cache-Control header. Directives without an
+// argument map to "".
+type Directives map[string]string
+
+// ParseCacheControl parses the Cache-Control header in h. Pragma:
+// no-cache counts as no-cache when there is no Cache-Control.
+func ParseCacheControl(h http.Header) Directives {
+	d := make(Directives)
+	values := h.Values("Cache-Control")
+	for _, v := range values {
+		for _, part := range strings.Split(v, ",") {
+			name, arg, _ := strings.Cut(strings.TrimSpace(part), "=")
+			if name == "" {
+				continue
+			}
+			d[strings.ToLower(name)] = strings.Trim(arg, `"`)
+		}
+	}
+	if len(values) == 0 && strings.Contains(strings.ToLower(h.Get("Pragma")), "no-cache") {
+		d["no-cache"] = ""
+	}
+	return d
+}
+
+// Has reports whether directive name is present
+func (d Directives) Has(name string) bool {
+	_, ok := d[name]
+	return ok
+}
+
+// Seconds returns a delta-seconds argument such as max-age
+func (d Directives) Seconds(name string) (int64, bool) {
+	v, ok := d[name]
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return n, true
+}
+
+// heuristicStatus lists the status codes that may be cached without an
+// explicit lifetime (RFC 9110 section 15.1), less 206 since ranges are not
+// stored
+var heuristicStatus = map[int]bool{
+	200: true, 203: true, 204: true, 300: true, 301: true, 308: true,
+	404: true, 405: true, 410: true, 414: true, 501: true,
+}
+
+// Policy holds the defaults for responses that don't state their own
+// freshness or stale windows
+type Policy struct {
+	TTL                  time.Duration // lifetime without explicit freshness
+	Grace                time.Duration // how long expired responses are kept
+	StaleWhileRevalidate time.Duration
+	StaleIfError         time.Duration
+}
+
+// Freshness is how a stored response may be used
+type Freshness int
+
+const (
+	Miss    Freshness = iota
+	Fresh             // may be served as is
+	Stale             // may be served while it is revalidated
+	Expired           // must be revalidated before it is served
+)
+
+// Lookup returns the stored response for r, its age in seconds and how it
+// may be used, taking r's own Cache-Control directives into account.
+// Requests with no-cache miss. Responses older than the request's max-age
+// or fresh for less than its min-fresh are returned as Expired, to be
+// revalidated before they are served; with max-age=0 that is any response
+// at least a second old.
+func (c *Cache) Lookup(r *http.Request) (*Response, int64, Freshness) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return nil, 0, Miss
+	}
+	req := ParseCacheControl(r.Header)
+	if req.Has("no-cache") {
+		return nil, 0, Miss
+	}
+
+	key := requestKey(r)
+	resp, ok := c.Get(key)
+	if ok && resp.Vary != nil {
+		resp, ok = c.Get(variantKey(key, resp.Vary, r.Header))
+	}
+	if !ok {
+		return nil, 0, Miss
+	}
+
+	now := time.Now().Unix()
+	age := resp.Age(now)
+	if maxAge, ok := req.Seconds("max-age"); ok && age > maxAge {
+		return resp, age, Expired
+	}
+	if minFresh, ok := req.Seconds("min-fresh"); ok && resp.TTL-now < minFresh {
+		return resp, age, Expired
+	}
+	switch {
+	case now <= resp.TTL:
+		return resp, age, Fresh
+	case now <= resp.TTL+resp.StaleWhileRevalidate:
+		return resp, age, Stale
+	}
+	return resp, age, Expired
+}
+
+// OnlyIfCached reports whether r asks not to be forwarded on a miss
+func OnlyIfCached(r *http.Request) bool {
+	return ParseCacheControl(r.Header).Has("only-if-cached")
+}
+
+// Store saves resp as the response to the GET request r if a shared cache
+// may store it (RFC 9111 section 3), with p filling in what its headers
+// leave out. authenticated requests are only stored when the response
+// marks itself as shareable. It reports whether resp was stored.
+func (c *Cache) Store(r *http.Request, resp *Response, p Policy, authenticated bool) bool {
+	now := time.Now()
+	lifetime, ok := storable(r, resp, p.TTL, authenticated, now)
+	if !ok {
+		return false
+	}
+
+	h := http.Header(resp.Headers)
+	resp.Stored = now.Unix() - initialAge(h, now)
+	ttl := resp.Stored + int64(lifetime/time.Second) - now.Unix()
+	if ttl <= 0 {
+		return false
+	}
+	staleWindows(resp, ParseCacheControl(h), p)
+	resp.ETag = h.Get("ETag")
+	if resp.ETag == "" {
+		resp.ETag = ETag(resp.Body)
+	}
+	resp.Host, resp.URI = r.Host, requestURI(r)
+	resp.Tags = surrogateKeys(h)
+
+	key := requestKey(r)
+	vary := varyHeaders(h)
+	if vary == nil {
+		c.Set(key, resp, int(ttl))
+		return true
+	}
+
+	// The primary key holds the header names the variants differ by; each
+	// variant is stored under a key derived from its request's values,
+	// and the index outlives the longest kept variant
+	if prev, ok := c.Get(key); !ok || !equal(prev.Vary, vary) || prev.TTL+prev.Grace < now.Unix()+ttl+resp.Grace {
+		c.Set(key, &Response{Vary: vary, Grace: resp.Grace, Host: resp.Host, URI: resp.URI}, int(ttl))
+	}
+	c.Set(variantKey(key, vary, r.Header), resp, int(ttl))
+	return true
+}
+
+// Refresh updates stored, the response to r, with the headers of a 304
+// Not Modified answer to its revalidation and stores it again with its
+// new freshness (RFC 9111 section 4.3.4). It returns the updated response,
+// which can be served even if the new headers forbid storing it.
+func (c *Cache) Refresh(r *http.Request, stored *Response, h http.Header, p Policy, authenticated bool) *Response {
+	headers := http.Header(stored.Headers).Clone()
+	for k, v := range h {
+		if k != "Content-Length" {
+			headers[k] = v
+		}
+	}
+	resp := &Response{StatusCode: stored.StatusCode, Headers: headers, Body: stored.Body}
+	if !c.Store(r, resp, p, authenticated) {
+		resp.Stored = time.Now().Unix()
+	}
+	return resp
+}
+
+// ETag returns a strong entity tag for body
+func ETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// SetConditions makes h, the headers of a request revalidating resp,
+// conditional on the validators the upstream sent with resp. The client's
+// own conditions are dropped, since the answer updates the stored response
+// rather than theirs.
+func (resp *Response) SetConditions(h http.Header) {
+	for _, k := range []string{"If-None-Match", "If-Modified-Since", "If-Match", "If-Unmodified-Since", "If-Range", "Range"} {
+		h.Del(k)
+	}
+	stored := http.Header(resp.Headers)
+	if etag := stored.Get("ETag"); etag != "" {
+		h.Set("If-None-Match", etag)
+	}
+	if modified := stored.Get("Last-Modified"); modified != "" {
+		h.Set("If-Modified-Since", modified)
+	}
+}
+
+// staleWindows sets how long resp is kept and may be served once expired,
+// from its stale-while-revalidate and stale-if-error directives (RFC 5861)
+// or else p. Responses that must be revalidated are never served stale.
+func staleWindows(resp *Response, cc Directives, p Policy) {
+	resp.StaleWhileRevalidate = int64(p.StaleWhileRevalidate / time.Second)
+	resp.StaleIfError = int64(p.StaleIfError / time.Second)
+	if s, ok := cc.Seconds("stale-while-revalidate"); ok {
+		resp.StaleWhileRevalidate = s
+	}
+	if s, ok := cc.Seconds("stale-if-error"); ok {
+		resp.StaleIfError = s
+	}
+	if cc.Has("must-revalidate") || cc.Has("proxy-revalidate") || cc.Has("s-maxage") {
+		resp.StaleWhileRevalidate, resp.StaleIfError = 0, 0
+	}
+	resp.Grace = max(int64(p.Grace/time.Second), resp.StaleWhileRevalidate, resp.StaleIfError)
+}
+
+// storable returns how long resp may be served to requests like r
+func storable(r *http.Request, resp *Response, defaultTTL time.Duration, authenticated bool, now time.Time) (time.Duration, bool) {
+	if r.Method != http.MethodGet || ParseCacheControl(r.Header).Has("no-store") {
+		return 0, false
+	}
+	if resp.StatusCode < 200 || resp.StatusCode == http.StatusPartialContent || resp.StatusCode == http.StatusNotModified {
+		return 0, false
+	}
+
+	h := http.Header(resp.Headers)
+	cc := ParseCacheControl(h)
+	// Responses that must be revalidated before every use are not kept
+	if cc.Has("no-store") || cc.Has("private") || cc.Has("no-cache") {
+		return 0, false
+	}
+	// Personalized responses stay out of the shared cache
+	if len(h.Values("Set-Cookie")) > 0 || h.Get("Vary") == "*" {
+		return 0, false
+	}
+	if authenticated && !cc.Has("public") && !cc.Has("s-maxage") && !cc.Has("must-revalidate") {
+		return 0, false
+	}
+
+	if s, ok := cc.Seconds("s-maxage"); ok {
+		return time.Duration(s) * time.Second, true
+	}
+	if s, ok := cc.Seconds("max-age"); ok {
+		return time.Duration(s) * time.Second, true
+	}
+	if expires := h.Get("Expires"); expires != "" {
+		// An invalid date, such as "0", means already expired
+		exp, err := http.ParseTime(expires)
+		if err != nil {
+			return 0, false
+		}
+		date := now
+		if d, err := http.ParseTime(h.Get("Date")); err == nil {
+			date = d
+		}
+		return exp.Sub(date), true
+	}
+	if heuristicStatus[resp.StatusCode] || cc.Has("public") {
+		return defaultTTL, true
+	}
+	return 0, false
+}
+
+// initialAge is how old a response already was when it arrived, from its
+// Age and Date headers (RFC 9111 section 4.2.3)
+func initialAge(h http.Header, now time.Time) int64 {
+	var age int64
+	if v, err := strconv.ParseInt(h.Get("Age"), 10, 64); err == nil && v > 0 {
+		age = v
+	}
+	if date, err := http.ParseTime(h.Get("Date")); err == nil {
+		age = max(age, int64(now.Sub(date)/time.Second))
+	}
+	return age
+}
+
+// varyHeaders returns the canonical, sorted request header names listed in
+// Vary, or nil when there are none
+func varyHeaders(h http.Header) []string {
+	var names []string
+	for _, v := range h.Values("Vary") {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	sort.Strings(names)
+	return names
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// requestKey is the primary cache key of r. HEAD requests are answered
+// from stored GET responses.
+func requestKey(r *http.Request) string {
+	return Hash(http.MethodGet, r.Host+r.URL.Path, r.URL.RawQuery, nil)
+}
+
+// requestURI is the path and query string r is cached under
+func requestURI(r *http.Request) string {
+	if r.URL.RawQuery == "" {
+		return r.URL.Path
+	}
+	return r.URL.Path + "?" + r.URL.RawQuery
+}
+
+// variantKey is the secondary key of the variant selected by the request
+// headers h
+func variantKey(key string, vary []string, h http.Header) string {
+	var b strings.Builder
+	b.WriteString(key)
+	for _, name := range vary {
+		b.WriteByte('\n')
+		b.WriteString(name)
+		b.WriteByte(':')
+		b.WriteString(strings.Join(h.Values(name), ","))
+	}
+	return Hash("", b.String(), "", nil)
+}
@@ -0,0 +1,159 @@
+package cache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestStorable(t *testing.T) {
+	now := time.Now()
+	date := now.UTC().Format(http.TimeFormat)
+	get := func(h http.Header) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/x", nil)
+		for k, v := range h {
+			r.Header[k] = v
+		}
+		return r
+	}
+
+	tests := []struct {
+		name          string
+		r             *http.Request
+		status        int
+		headers       http.Header
+		authenticated bool
+		want          time.Duration
+		ok            bool
+	}{
+		{"max-age", get(nil), 200, http.Header{"Cache-Control": {"max-age=60"}}, false, time.Minute, true},
+		{"max-age=0", get(nil), 200, http.Header{"Cache-Control": {"max-age=0"}}, false, 0, true},
+		{"s-maxage wins", get(nil), 200, http.Header{"Cache-Control": {"max-age=60, s-maxage=10"}}, false, 10 * time.Second, true},
+		{"expires", get(nil), 200, http.Header{"Date": {date}, "Expires": {now.Add(2 * time.Minute).UTC().Format(http.TimeFormat)}}, false, 2 * time.Minute, true},
+		{"max-age beats expires", get(nil), 200, http.Header{"Cache-Control": {"max-age=5"}, "Expires": {"0"}}, false, 5 * time.Second, true},
+		{"invalid expires", get(nil), 200, http.Header{"Expires": {"0"}}, false, 0, false},
+		{"heuristic", get(nil), 404, nil, false, time.Hour, true},
+		{"no heuristic for 302", get(nil), 302, nil, false, 0, false},
+		{"public 302", get(nil), 302, http.Header{"Cache-Control": {"public"}}, false, time.Hour, true},
+		{"request no-store", get(http.Header{"Cache-Control": {"no-store"}}), 200, nil, false, 0, false},
+		{"no-store", get(nil), 200, http.Header{"Cache-Control": {"max-age=60, no-store"}}, false, 0, false},
+		{"private", get(nil), 200, http.Header{"Cache-Control": {"private, max-age=60"}}, false, 0, false},
+		{"no-cache", get(nil), 200, http.Header{"Cache-Control": {"no-cache"}}, false, 0, false},
+		{"set-cookie", get(nil), 200, http.Header{"Set-Cookie": {"a=b"}, "Cache-Control": {"max-age=60"}}, false, 0, false},
+		{"vary star", get(nil), 200, http.Header{"Vary": {"*"}, "Cache-Control": {"max-age=60"}}, false, 0, false},
+		{"partial content", get(nil), 206, http.Header{"Cache-Control": {"max-age=60"}}, false, 0, false},
+		{"not modified", get(nil), 304, http.Header{"Cache-Control": {"max-age=60"}}, false, 0, false},
+		{"post", httptest.NewRequest(http.MethodPost, "/x", nil), 200, http.Header{"Cache-Control": {"max-age=60"}}, false, 0, false},
+		{"authenticated", get(nil), 200, http.Header{"Cache-Control": {"max-age=60"}}, true, 0, false},
+		{"authenticated public", get(nil), 200, http.Header{"Cache-Control": {"public, max-age=60"}}, true, time.Minute, true},
+		{"authenticated s-maxage", get(nil), 200, http.Header{"Cache-Control": {"s-maxage=60"}}, true, time.Minute, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &Response{StatusCode: tt.status, Headers: tt.headers}
+			got, ok := storable(tt.r, resp, time.Hour, tt.authenticated, now)
+			if ok != tt.ok || got != tt.want {
+				t.Errorf("storable() = %v, %v, want %v, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestLookupVary(t *testing.T) {
+	c := NewCache(100, 1)
+	request := func(encoding string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/x", nil)
+		if encoding != "" {
+			r.Header.Set("Accept-Encoding", encoding)
+		}
+		return r
+	}
+	for _, encoding := range []string{"gzip", "br"} {
+		stored := c.Store(request(encoding), &Response{
+			StatusCode: 200,
+			Headers:    http.Header{"Cache-Control": {"max-age=60"}, "Vary": {"accept-encoding"}},
+			Body:       []byte(encoding),
+		}, Policy{}, false)
+		if !stored {
+			t.Fatalf("%s variant not stored", encoding)
+		}
+	}
+
+	tests := []struct {
+		encoding string
+		want     string // body, or "" for a miss
+	}{
+		{"gzip", "gzip"},
+		{"br", "br"},
+		{"deflate", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		resp, _, freshness := c.Lookup(request(tt.encoding))
+		switch {
+		case tt.want == "" && freshness != Miss:
+			t.Errorf("Accept-Encoding %q: got %s, want a miss", tt.encoding, resp.Body)
+		case tt.want != "" && (freshness != Fresh || string(resp.Body) != tt.want):
+			t.Errorf("Accept-Encoding %q: got freshness %d, want the %s variant", tt.encoding, freshness, tt.want)
+		}
+	}
+
+	// Responses varying on everything are never shared
+	r := httptest.NewRequest(http.MethodGet, "/star", nil)
+	if c.Store(r, &Response{StatusCode: 200, Headers: http.Header{"Cache-Control": {"max-age=60"}, "Vary": {"*"}}}, Policy{}, false) {
+		t.Error("Vary: * response stored")
+	}
+	if _, _, freshness := c.Lookup(r); freshness != Miss {
+		t.Error("Vary: * response served from the cache")
+	}
+}
+
+func TestLookupFreshness(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name    string
+		method  string
+		request http.Header
+		ttl     int64 // seconds until the stored response expires
+		want    Freshness
+	}{
+		{"fresh", http.MethodGet, nil, 50, Fresh},
+		{"head", http.MethodHead, nil, 50, Fresh},
+		{"post", http.MethodPost, nil, 50, Miss},
+		{"no-cache", http.MethodGet, http.Header{"Cache-Control": {"no-cache"}}, 50, Miss},
+		{"pragma no-cache", http.MethodGet, http.Header{"Pragma": {"no-cache"}}, 50, Miss},
+		{"max-age=0", http.MethodGet, http.Header{"Cache-Control": {"max-age=0"}}, 50, Expired},
+		{"older than max-age", http.MethodGet, http.Header{"Cache-Control": {"max-age=5"}}, 50, Expired},
+		{"within max-age", http.MethodGet, http.Header{"Cache-Control": {"max-age=30"}}, 50, Fresh},
+		{"min-fresh", http.MethodGet, http.Header{"Cache-Control": {"min-fresh=55"}}, 50, Expired},
+		{"min-fresh met", http.MethodGet, http.Header{"Cache-Control": {"min-fresh=30"}}, 50, Fresh},
+		{"stale while revalidating", http.MethodGet, nil, -5, Stale},
+		{"past stale-while-revalidate", http.MethodGet, nil, -15, Expired},
+		{"past grace", http.MethodGet, nil, -25, Miss},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewCache(100, 1)
+			stored := httptest.NewRequest(http.MethodGet, "/x", nil)
+			// Generated 10 seconds ago, kept 20 seconds past expiry and
+			// served stale for the first 10 of them
+			resp := &Response{StatusCode: 200, Headers: http.Header{
+				"Cache-Control": {"max-age=60, stale-while-revalidate=10"},
+				"Date":          {now.Add(-10 * time.Second).UTC().Format(http.TimeFormat)},
+			}}
+			if !c.Store(stored, resp, Policy{Grace: 20 * time.Second}, false) {
+				t.Fatal("response not stored")
+			}
+			resp.TTL = now.Unix() + tt.ttl
+
+			r := httptest.NewRequest(tt.method, "/x", nil)
+			for k, v := range tt.request {
+				r.Header[k] = v
+			}
+			if _, _, got := c.Lookup(r); got != tt.want {
+				t.Errorf("Lookup() freshness = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package cache
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// PurgeURL drops the responses stored for uri, a path with its query
+// string, on host, or on every host when host is empty. Hosts match
+// regardless of case and port. Soft purges mark them stale instead, so
+// they are revalidated before being served fresh again and can still be
+// served stale meanwhile. It returns how many responses matched, not
+// counting the indexes of responses that vary by request headers.
+func (c *Cache) PurgeURL(host, uri string, soft bool) int {
+	host = hostname(host)
+	return c.purge(func(r *Response) bool {
+		return r.URI == uri && (host == "" || hostname(r.Host) == host)
+	}, soft)
+}
+
+// PurgePrefix drops, or soft purges, the responses whose path starts with
+// prefix, on host or on every host when host is empty
+func (c *Cache) PurgePrefix(host, prefix string, soft bool) int {
+	host = hostname(host)
+	return c.purge(func(r *Response) bool {
+		path, _, _ := strings.Cut(r.URI, "?")
+		return strings.HasPrefix(path, prefix) && (host == "" || hostname(r.Host) == host)
+	}, soft)
+}
+
+// hostname returns host in lower case and without its port
+func hostname(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(host)
+}
+
+// PurgeTag drops, or soft purges, the responses carrying the surrogate key
+// tag
+func (c *Cache) PurgeTag(tag string, soft bool) int {
+	return c.purge(func(r *Response) bool {
+		for _, t := range r.Tags {
+			if t == tag {
+				return true
+			}
+		}
+		return false
+	}, soft)
+}
+
+// purge applies a purge to the matching responses of every shard. It
+// scans the whole cache, which is bounded by its entry budget.
+func (c *Cache) purge(match func(*Response) bool, soft bool) int {
+	now := time.Now()
+	n := 0
+	for _, sh := range c.shards {
+		sh.mu.Lock()
+		n += sh.purge(match, soft, now)
+		sh.mu.Unlock()
+	}
+	return n
+}
+
+// surrogateKeys returns the tags a response can be purged by, from its
+// space separated Surrogate-Key and comma separated Cache-Tag headers
+func surrogateKeys(h http.Header) []string {
+	var tags []string
+	for _, v := range h.Values("Surrogate-Key") {
+		tags = append(tags, strings.Fields(v)...)
+	}
+	for _, v := range h.Values("Cache-Tag") {
+		for _, tag := range strings.Split(v, ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				tags = append(tags, tag)
+			}
+		}
+	}
+	return tags
+}
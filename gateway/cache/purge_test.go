@@ -0,0 +1,137 @@
+package cache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPurge(t *testing.T) {
+	stored := []struct {
+		host, uri, tags string
+	}{
+		{"a.example", "/products/1", "p1 products"},
+		{"a.example", "/products/2?x=1", "products"},
+		{"b.example", "/products/1", ""},
+		{"a.example", "/other", ""},
+		{"A.example:8080", "/products/3", ""},
+	}
+	request := func(host, uri string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, uri, nil)
+		r.Host = host
+		return r
+	}
+
+	tests := []struct {
+		name  string
+		purge func(c *Cache) int
+		n     int
+		want  []Freshness // per stored response, in order
+	}{
+		{"url on a host", func(c *Cache) int { return c.PurgeURL("a.example", "/products/1", false) }, 1,
+			[]Freshness{Miss, Fresh, Fresh, Fresh, Fresh}},
+		{"url on every host", func(c *Cache) int { return c.PurgeURL("", "/products/1", false) }, 2,
+			[]Freshness{Miss, Fresh, Miss, Fresh, Fresh}},
+		{"url with query", func(c *Cache) int { return c.PurgeURL("a.example", "/products/2?x=1", false) }, 1,
+			[]Freshness{Fresh, Miss, Fresh, Fresh, Fresh}},
+		{"url without its query", func(c *Cache) int { return c.PurgeURL("a.example", "/products/2", false) }, 0,
+			[]Freshness{Fresh, Fresh, Fresh, Fresh, Fresh}},
+		{"url ignores host case and port", func(c *Cache) int { return c.PurgeURL("a.example", "/products/3", false) }, 1,
+			[]Freshness{Fresh, Fresh, Fresh, Fresh, Miss}},
+		{"url on a host with a port", func(c *Cache) int { return c.PurgeURL("A.EXAMPLE:443", "/products/1", false) }, 1,
+			[]Freshness{Miss, Fresh, Fresh, Fresh, Fresh}},
+		{"prefix", func(c *Cache) int { return c.PurgePrefix("a.example", "/products/", false) }, 3,
+			[]Freshness{Miss, Miss, Fresh, Fresh, Miss}},
+		{"prefix on every host", func(c *Cache) int { return c.PurgePrefix("", "/", false) }, 5,
+			[]Freshness{Miss, Miss, Miss, Miss, Miss}},
+		{"tag", func(c *Cache) int { return c.PurgeTag("products", false) }, 2,
+			[]Freshness{Miss, Miss, Fresh, Fresh, Fresh}},
+		{"unknown tag", func(c *Cache) int { return c.PurgeTag("nope", false) }, 0,
+			[]Freshness{Fresh, Fresh, Fresh, Fresh, Fresh}},
+		{"soft url", func(c *Cache) int { return c.PurgeURL("", "/products/1", true) }, 2,
+			[]Freshness{Expired, Fresh, Expired, Fresh, Fresh}},
+		{"soft tag", func(c *Cache) int { return c.PurgeTag("p1", true) }, 1,
+			[]Freshness{Expired, Fresh, Fresh, Fresh, Fresh}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewCache(100, 1)
+			for _, s := range stored {
+				resp := &Response{StatusCode: 200, Headers: http.Header{
+					"Cache-Control": {"max-age=60"},
+					"Surrogate-Key": {s.tags},
+				}}
+				if !c.Store(request(s.host, s.uri), resp, Policy{Grace: time.Minute}, false) {
+					t.Fatalf("%s%s not stored", s.host, s.uri)
+				}
+			}
+
+			if n := tt.purge(c); n != tt.n {
+				t.Errorf("purged %d responses, want %d", n, tt.n)
+			}
+			for i, s := range stored {
+				if _, _, got := c.Lookup(request(s.host, s.uri)); got != tt.want[i] {
+					t.Errorf("%s%s: freshness = %d, want %d", s.host, s.uri, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestPurgeCountsVariants(t *testing.T) {
+	tests := []struct {
+		name  string
+		purge func(c *Cache) int
+	}{
+		{"url", func(c *Cache) int { return c.PurgeURL("", "/x", false) }},
+		{"prefix", func(c *Cache) int { return c.PurgePrefix("", "/", false) }},
+		{"tag", func(c *Cache) int { return c.PurgeTag("x", false) }},
+		{"soft url", func(c *Cache) int { return c.PurgeURL("", "/x", true) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewCache(100, 1)
+			var requests []*http.Request
+			for _, lang := range []string{"en", "de"} {
+				r := httptest.NewRequest(http.MethodGet, "/x", nil)
+				r.Header.Set("Accept-Language", lang)
+				resp := &Response{StatusCode: 200, Headers: http.Header{
+					"Cache-Control": {"max-age=60"},
+					"Vary":          {"Accept-Language"},
+					"Surrogate-Key": {"x"},
+				}}
+				if !c.Store(r, resp, Policy{}, false) {
+					t.Fatalf("%s variant not stored", lang)
+				}
+				requests = append(requests, r)
+			}
+
+			if n := tt.purge(c); n != 2 {
+				t.Errorf("purged %d responses, want the 2 variants", n)
+			}
+			for _, r := range requests {
+				if _, _, got := c.Lookup(r); got == Fresh {
+					t.Errorf("%s variant still fresh", r.Header.Get("Accept-Language"))
+				}
+			}
+		})
+	}
+}
+
+func TestSoftPurgeServesStale(t *testing.T) {
+	c := NewCache(100, 1)
+	r := httptest.NewRequest(http.MethodGet, "/x", nil)
+	resp := &Response{StatusCode: 200, Headers: http.Header{"Cache-Control": {"max-age=60, stale-while-revalidate=30"}}}
+	c.Store(r, resp, Policy{}, false)
+
+	c.PurgeURL("", "/x", true)
+	got, _, freshness := c.Lookup(r)
+	if freshness != Stale {
+		t.Fatalf("freshness = %d, want Stale", freshness)
+	}
+	// Readers holding the response still see it fresh
+	if resp.TTL < time.Now().Unix() || got.TTL >= time.Now().Unix() {
+		t.Error("soft purge modified the stored response in place")
+	}
+}
@@ -0,0 +1,280 @@
+package cache
+
+import "time"
+
+// Segments of a shard. New entries enter the window; entries leaving it
+// are admitted to probation only if TinyLFU rates them above the entry
+// they would displace, and probation entries that are hit again move to
+// protected.
+const (
+	segWindow uint8 = iota
+	segProbation
+	segProtected
+)
+
+const (
+	windowPercent    = 1  // share of the budgets for the admission window
+	protectedPercent = 80 // share of the main budgets for protected entries
+)
+
+type entry struct {
+	key      string
+	hash     uint64
+	response *Response
+	size     int64
+	seg      uint8
+
+	prev, next *entry
+}
+
+// list is a doubly linked list of entries, most recently used first
+type list struct {
+	root  entry // sentinel
+	len   int
+	bytes int64
+}
+
+func (l *list) init() {
+	l.root.next = &l.root
+	l.root.prev = &l.root
+}
+
+func (l *list) pushFront(e *entry) {
+	e.prev = &l.root
+	e.next = l.root.next
+	l.root.next.prev = e
+	l.root.next = e
+	l.len++
+	l.bytes += e.size
+}
+
+func (l *list) remove(e *entry) {
+	e.prev.next = e.next
+	e.next.prev = e.prev
+	e.prev, e.next = nil, nil
+	l.len--
+	l.bytes -= e.size
+}
+
+// back returns the least recently used entry, or nil
+func (l *list) back() *entry {
+	if l.len == 0 {
+		return nil
+	}
+	return l.root.prev
+}
+
+// budget bounds an entry count and a byte size
+type budget struct {
+	entries int
+	bytes   int64
+}
+
+func (b budget) exceeded(l *list) bool {
+	return l.len > b.entries || l.bytes > b.bytes
+}
+
+// shard is one independently locked part of the cache. Every operation
+// is O(1) apart from evicting several entries for one large response.
+type shard struct {
+	data      map[string]*entry
+	segs      [3]list
+	limit     budget // hard limit on all segments together
+	window    budget
+	protected budget
+	freq      *sketch
+
+	hits, misses, evictions, rejections, expirations int64
+}
+
+func newShard(limit budget) *shard {
+	s := &shard{data: make(map[string]*entry), freq: newSketch(limit.entries)}
+	for i := range s.segs {
+		s.segs[i].init()
+	}
+	s.resize(limit)
+	return s
+}
+
+// resize sets the shard's budgets and evicts until they are met
+func (s *shard) resize(limit budget) {
+	s.limit = limit
+	s.window = budget{
+		entries: max(1, limit.entries*windowPercent/100),
+		bytes:   limit.bytes * windowPercent / 100,
+	}
+	main := budget{entries: limit.entries - s.window.entries, bytes: limit.bytes - s.window.bytes}
+	s.protected = budget{
+		entries: main.entries * protectedPercent / 100,
+		bytes:   main.bytes * protectedPercent / 100,
+	}
+	s.trim()
+}
+
+func (s *shard) get(key string, hash uint64, now time.Time) (*Response, bool) {
+	s.freq.increment(hash)
+
+	e, ok := s.data[key]
+	if !ok {
+		s.misses++
+		return nil, false
+	}
+	if e.response.TTL+e.response.Grace < now.Unix() {
+		s.expirations++
+		s.misses++
+		s.delete(e)
+		return nil, false
+	}
+
+	s.hits++
+	s.touch(e)
+	return e.response, true
+}
+
+// touch records a hit on e
+func (s *shard) touch(e *entry) {
+	switch e.seg {
+	case segWindow, segProtected:
+		l := &s.segs[e.seg]
+		l.remove(e)
+		l.pushFront(e)
+	case segProbation:
+		s.segs[segProbation].remove(e)
+		e.seg = segProtected
+		s.segs[segProtected].pushFront(e)
+		// Protected entries beyond its share get a second chance on
+		// probation
+		for s.protected.exceeded(&s.segs[segProtected]) {
+			demoted := s.segs[segProtected].back()
+			s.segs[segProtected].remove(demoted)
+			demoted.seg = segProbation
+			s.segs[segProbation].pushFront(demoted)
+		}
+	}
+}
+
+func (s *shard) set(key string, hash uint64, response *Response, size int64) {
+	if old, ok := s.data[key]; ok {
+		s.delete(old)
+	}
+	// A response bigger than the whole shard would only flush it
+	if size > s.limit.bytes || s.limit.entries == 0 {
+		s.rejections++
+		return
+	}
+
+	e := &entry{key: key, hash: hash, response: response, size: size, seg: segWindow}
+	s.data[key] = e
+	s.segs[segWindow].pushFront(e)
+
+	// Entries pushed out of the window, including a new one too large
+	// for it, compete for a place in the main segments
+	for s.window.exceeded(&s.segs[segWindow]) {
+		candidate := s.segs[segWindow].back()
+		s.segs[segWindow].remove(candidate)
+		s.admit(candidate)
+	}
+	s.trim()
+}
+
+// admit moves a candidate leaving the window into probation, evicting
+// main entries that are less popular than it until the budgets are met.
+// If the entry it would next displace is at least as popular, the
+// candidate is dropped instead.
+func (s *shard) admit(candidate *entry) {
+	candidate.seg = segProbation
+	s.segs[segProbation].pushFront(candidate)
+
+	for s.over() {
+		victim := s.mainVictim(candidate)
+		if victim == nil {
+			return
+		}
+		if s.freq.estimate(candidate.hash) <= s.freq.estimate(victim.hash) {
+			s.rejections++
+			s.delete(candidate)
+			return
+		}
+		s.evictions++
+		s.delete(victim)
+	}
+}
+
+// mainVictim returns the least recently used main entry other than
+// candidate, from probation first
+func (s *shard) mainVictim(candidate *entry) *entry {
+	if e := s.segs[segProbation].back(); e != nil && e != candidate {
+		return e
+	}
+	return s.segs[segProtected].back()
+}
+
+// trim evicts until the hard budgets are met
+func (s *shard) trim() {
+	for s.over() {
+		var victim *entry
+		for _, seg := range []uint8{segProbation, segProtected, segWindow} {
+			if victim = s.segs[seg].back(); victim != nil {
+				break
+			}
+		}
+		if victim == nil {
+			return
+		}
+		s.evictions++
+		s.delete(victim)
+	}
+}
+
+func (s *shard) over() bool {
+	entries, bytes := s.usage()
+	return entries > s.limit.entries || bytes > s.limit.bytes
+}
+
+func (s *shard) usage() (int, int64) {
+	var entries int
+	var bytes int64
+	for i := range s.segs {
+		entries += s.segs[i].len
+		bytes += s.segs[i].bytes
+	}
+	return entries, bytes
+}
+
+// purge deletes the entries whose responses match, or with soft expires
+// them, leaving them to their grace period. Expired copies replace the
+// responses, which readers may still hold. Vary indexes are purged along
+// with their variants but not counted.
+func (s *shard) purge(match func(*Response) bool, soft bool, now time.Time) int {
+	n := 0
+	for _, e := range s.data {
+		if !match(e.response) {
+			continue
+		}
+		if e.response.Vary == nil {
+			n++
+		}
+		switch {
+		case !soft:
+			s.delete(e)
+		case e.response.TTL >= now.Unix():
+			expired := *e.response
+			expired.TTL = now.Unix() - 1
+			e.response = &expired
+		}
+	}
+	return n
+}
+
+func (s *shard) delete(e *entry) {
+	s.segs[e.seg].remove(e)
+	delete(s.data, e.key)
+}
+
+func (s *shard) clear() {
+	s.data = make(map[string]*entry)
+	for i := range s.segs {
+		s.segs[i] = list{}
+		s.segs[i].init()
+	}
+}
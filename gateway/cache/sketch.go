@@ -0,0 +1,71 @@
+package cache
+
+// sketch is a count-min sketch of how often keys were requested recently,
+// used by TinyLFU to decide whether a new entry is worth more than the one
+// it would evict. Counters saturate at 15 and are halved once the sketch
+// has seen sampleFactor times its width, so old popularity fades.
+type sketch struct {
+	rows      [sketchDepth][]uint8
+	mask      uint64
+	additions int
+	resetAt   int
+}
+
+const (
+	sketchDepth  = 4
+	maxCount     = 15
+	sampleFactor = 10
+)
+
+// newSketch sizes a sketch for about n distinct keys
+func newSketch(n int) *sketch {
+	width := 64
+	for width < n {
+		width <<= 1
+	}
+	s := &sketch{mask: uint64(width - 1), resetAt: sampleFactor * width}
+	for i := range s.rows {
+		s.rows[i] = make([]uint8, width)
+	}
+	return s
+}
+
+// index spreads h over row i
+func (s *sketch) index(h uint64, i int) uint64 {
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd + uint64(i)*0x9e3779b97f4a7c15
+	h ^= h >> 29
+	return h & s.mask
+}
+
+// increment records one request for the key hashed to h
+func (s *sketch) increment(h uint64) {
+	for i := range s.rows {
+		if c := &s.rows[i][s.index(h, i)]; *c < maxCount {
+			*c++
+		}
+	}
+	if s.additions++; s.additions >= s.resetAt {
+		s.reset()
+	}
+}
+
+// estimate returns how often the key hashed to h was requested
+func (s *sketch) estimate(h uint64) uint8 {
+	est := uint8(maxCount)
+	for i := range s.rows {
+		if c := s.rows[i][s.index(h, i)]; c < est {
+			est = c
+		}
+	}
+	return est
+}
+
+func (s *sketch) reset() {
+	for i := range s.rows {
+		for j := range s.rows[i] {
+			s.rows[i][j] >>= 1
+		}
+	}
+	s.additions /= 2
+}
@@ -0,0 +1,98 @@
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+)
+
+// Client certificate verification modes on the listener
+const (
+	ClientAuthRequire  = "require"  // handshakes without a valid certificate fail
+	ClientAuthOptional = "optional" // a certificate is verified if one is sent
+)
+
+// Sources of a client certificate's identity
+const (
+	IdentitySubject = "subject" // the subject distinguished name
+	IdentitySAN     = "san"     // the first URI, DNS, email or IP SAN
+)
+
+// ClientOptions configures TLS to an upstream
+type ClientOptions struct {
+	CAFile             string // PEM bundle trusted instead of the system roots
+	CertFile           string // client certificate presented for mutual TLS
+	KeyFile            string
+	ServerName         string // verified and sent via SNI instead of the target host
+	InsecureSkipVerify bool   // for local testing only
+}
+
+// ClientConfig builds the TLS config for connecting to an upstream
+func ClientConfig(opts ClientOptions) (*tls.Config, error) {
+	c := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         opts.ServerName,
+		InsecureSkipVerify: opts.InsecureSkipVerify,
+	}
+	if opts.CAFile != "" {
+		pool, err := LoadCAs(opts.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		c.RootCAs = pool
+	}
+	if opts.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load %s: %w", opts.CertFile, err)
+		}
+		c.Certificates = []tls.Certificate{cert}
+	}
+	return c, nil
+}
+
+// LoadCAs reads a PEM bundle of CA certificates
+func LoadCAs(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", path)
+	}
+	return pool, nil
+}
+
+// ParseClientAuth converts a client certificate verification mode to the
+// handshake setting
+func ParseClientAuth(mode string) (tls.ClientAuthType, error) {
+	switch mode {
+	case ClientAuthRequire:
+		return tls.RequireAndVerifyClientCert, nil
+	case ClientAuthOptional:
+		return tls.VerifyClientCertIfGiven, nil
+	default:
+		return tls.NoClientCert, fmt.Errorf("unknown client auth mode %q, must be %s or %s", mode, ClientAuthRequire, ClientAuthOptional)
+	}
+}
+
+// Identity names the holder of a verified client certificate, taken from
+// source. It is empty when the certificate has no such name.
+func Identity(cert *x509.Certificate, source string) string {
+	if source != IdentitySAN {
+		return cert.Subject.String()
+	}
+	switch {
+	case len(cert.URIs) > 0:
+		return cert.URIs[0].String()
+	case len(cert.DNSNames) > 0:
+		return cert.DNSNames[0]
+	case len(cert.EmailAddresses) > 0:
+		return cert.EmailAddresses[0]
+	case len(cert.IPAddresses) > 0:
+		return cert.IPAddresses[0].String()
+	}
+	return ""
+}
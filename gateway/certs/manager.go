@@ -0,0 +1,273 @@
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNoCertificate is returned when no certificate is loaded
+var ErrNoCertificate = errors.New("no TLS certificate configured")
+
+// Pair is a certificate and key file and the server names it is served
+// for. Without Hosts, the names in the certificate are used.
+type Pair struct {
+	CertFile string
+	KeyFile  string
+	Hosts    []string
+}
+
+// Policy is the TLS protocol policy offered to clients
+type Policy struct {
+	MinVersion   uint16
+	CipherSuites []uint16 // TLS 1.2 only; nil means Go's secure defaults
+
+	// Client certificates are verified against ClientCAs unless
+	// ClientAuth is tls.NoClientCert
+	ClientAuth tls.ClientAuthType
+	ClientCAs  *x509.CertPool
+}
+
+// Manager serves certificates by SNI under the configured policy. Both can
+// be replaced at any time, and certificates are reloaded when their files
+// change; handshakes always see a complete, consistent set.
+type Manager struct {
+	mu       sync.RWMutex
+	pairs    []Pair
+	policy   Policy
+	loaded   []*certificate
+	exact    map[string]*certificate
+	wildcard map[string]*certificate // by suffix, e.g. ".example.com"
+
+	reloads   int64
+	failures  int64
+	lastError string
+}
+
+// certificate is a loaded Pair
+type certificate struct {
+	pair     Pair
+	cert     *tls.Certificate
+	hosts    []string
+	loadedAt time.Time
+}
+
+// NewManager creates a manager with no certificates
+func NewManager() *Manager {
+	return &Manager{
+		exact:    make(map[string]*certificate),
+		wildcard: make(map[string]*certificate),
+	}
+}
+
+// Configure loads pairs and switches to them and policy. If any pair fails
+// to load, nothing changes.
+func (m *Manager) Configure(pairs []Pair, policy Policy) error {
+	loaded, err := load(pairs)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err != nil {
+		m.failures++
+		m.lastError = err.Error()
+		return err
+	}
+	m.pairs = pairs
+	m.policy = policy
+	m.lastError = ""
+	m.install(loaded)
+	return nil
+}
+
+// Reload reads the current pairs from disk again. A renewal caught half
+// written fails and keeps the previous certificates until the next change.
+func (m *Manager) Reload() error {
+	m.mu.RLock()
+	pairs := m.pairs
+	m.mu.RUnlock()
+
+	loaded, err := load(pairs)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err != nil {
+		m.failures++
+		m.lastError = err.Error()
+		log.Printf("TLS certificate reload failed, keeping previous certificates: %v", err)
+		return err
+	}
+	m.reloads++
+	m.lastError = ""
+	m.install(loaded)
+	log.Printf("TLS certificates reloaded (%d pairs)", len(loaded))
+	return nil
+}
+
+// install indexes loaded by server name. Callers must hold m.mu.
+func (m *Manager) install(loaded []*certificate) {
+	m.loaded = loaded
+	m.exact = make(map[string]*certificate)
+	m.wildcard = make(map[string]*certificate)
+	for _, c := range loaded {
+		for _, host := range c.hosts {
+			if suffix, ok := strings.CutPrefix(host, "*"); ok {
+				if m.wildcard[suffix] == nil {
+					m.wildcard[suffix] = c
+				}
+			} else if m.exact[host] == nil {
+				m.exact[host] = c
+			}
+		}
+	}
+}
+
+func load(pairs []Pair) ([]*certificate, error) {
+	loaded := make([]*certificate, 0, len(pairs))
+	for _, p := range pairs {
+		cert, err := tls.LoadX509KeyPair(p.CertFile, p.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load %s: %w", p.CertFile, err)
+		}
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return nil, fmt.Errorf("parse %s: %w", p.CertFile, err)
+		}
+		cert.Leaf = leaf
+
+		hosts := p.Hosts
+		if len(hosts) == 0 {
+			hosts = leaf.DNSNames
+		}
+		c := &certificate{pair: p, cert: &cert, loadedAt: time.Now()}
+		for _, h := range hosts {
+			c.hosts = append(c.hosts, strings.ToLower(h))
+		}
+		loaded = append(loaded, c)
+	}
+	return loaded, nil
+}
+
+// Files returns the certificate and key files in use, to be watched for
+// changes
+func (m *Manager) Files() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	files := make([]string, 0, 2*len(m.pairs))
+	for _, p := range m.pairs {
+		files = append(files, p.CertFile, p.KeyFile)
+	}
+	return files
+}
+
+// GetCertificate picks the certificate for the requested server name: an
+// exact match, then a wildcard one level up, then the first pair
+func (m *Manager) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if len(m.loaded) == 0 {
+		return nil, ErrNoCertificate
+	}
+
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+	if c := m.exact[name]; c != nil {
+		return c.cert, nil
+	}
+	if i := strings.IndexByte(name, '.'); i > 0 {
+		if c := m.wildcard[name[i:]]; c != nil {
+			return c.cert, nil
+		}
+	}
+	return m.loaded[0].cert, nil
+}
+
+// TLSConfig returns a server config that applies the manager's current
+// certificates and policy to every handshake. Protocols added to its
+// NextProtos, e.g. by http2.ConfigureServer, are offered too.
+func (m *Manager) TLSConfig() *tls.Config {
+	base := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: m.GetCertificate,
+	}
+	base.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		m.mu.RLock()
+		policy := m.policy
+		m.mu.RUnlock()
+
+		return &tls.Config{
+			MinVersion:     policy.MinVersion,
+			CipherSuites:   policy.CipherSuites,
+			ClientAuth:     policy.ClientAuth,
+			ClientCAs:      policy.ClientCAs,
+			NextProtos:     base.NextProtos,
+			GetCertificate: m.GetCertificate,
+		}, nil
+	}
+	return base
+}
+
+// Stats returns the loaded certificates and reload statistics
+func (m *Manager) Stats() map[string]interface{} {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	certs := make([]map[string]interface{}, 0, len(m.loaded))
+	for _, c := range m.loaded {
+		certs = append(certs, map[string]interface{}{
+			"cert_file": c.pair.CertFile,
+			"hosts":     c.hosts,
+			"not_after": c.cert.Leaf.NotAfter.Format(time.RFC3339),
+			"loaded_at": c.loadedAt.Format(time.RFC3339),
+		})
+	}
+
+	stats := map[string]interface{}{
+		"certificates":    certs,
+		"min_version":     tls.VersionName(m.policy.MinVersion),
+		"client_auth":     m.policy.ClientAuth.String(),
+		"reloads":         m.reloads,
+		"reload_failures": m.failures,
+	}
+	if m.lastError != "" {
+		stats["last_error"] = m.lastError
+	}
+	return stats
+}
+
+// ParseVersion converts a TLS version such as "1.2" to its constant. Only
+// TLS 1.2 and later are accepted.
+func ParseVersion(v string) (uint16, error) {
+	switch v {
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("unsupported TLS version %q, must be 1.2 or 1.3", v)
+	}
+}
+
+// ParseCipherSuite converts a cipher suite name such as
+// "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256" to its ID. Suites Go considers
+// insecure are rejected.
+func ParseCipherSuite(name string) (uint16, error) {
+	for _, s := range tls.CipherSuites() {
+		if s.Name == name {
+			return s.ID, nil
+		}
+	}
+	for _, s := range tls.InsecureCipherSuites() {
+		if s.Name == name {
+			return 0, fmt.Errorf("cipher suite %s is insecure", name)
+		}
+	}
+	return 0, fmt.Errorf("unknown cipher suite %q", name)
+}
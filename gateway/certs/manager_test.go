@@ -0,0 +1,255 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// generate returns a self-signed certificate for names as PEM cert and key
+func generate(t *testing.T, names ...string) (certPEM, keyPEM []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: names[0]},
+		DNSNames:     names,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+}
+
+// writePair writes a certificate for names into dir, named after the
+// first name
+func writePair(t *testing.T, dir string, names ...string) Pair {
+	t.Helper()
+	certPEM, keyPEM := generate(t, names...)
+	p := Pair{
+		CertFile: filepath.Join(dir, names[0]+".crt"),
+		KeyFile:  filepath.Join(dir, names[0]+".key"),
+	}
+	if err := os.WriteFile(p.CertFile, certPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(p.KeyFile, keyPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// served returns the first DNS name of the certificate served for sni
+func served(t *testing.T, m *Manager, sni string) string {
+	t.Helper()
+	cert, err := m.GetCertificate(&tls.ClientHelloInfo{ServerName: sni})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert.Leaf.DNSNames[0]
+}
+
+func TestGetCertificate(t *testing.T) {
+	dir := t.TempDir()
+	override := writePair(t, dir, "ignored.test")
+	override.Hosts = []string{"Override.Test"}
+	m := NewManager()
+	err := m.Configure([]Pair{
+		writePair(t, dir, "default.test"),
+		writePair(t, dir, "*.example.com", "example.org"),
+		writePair(t, dir, "www.example.com"),
+		override,
+	}, Policy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		sni  string
+		want string
+	}{
+		{"www.example.com", "www.example.com"},
+		{"WWW.Example.com.", "www.example.com"},
+		{"api.example.com", "*.example.com"},
+		{"example.org", "*.example.com"},
+		{"a.b.example.com", "default.test"},
+		{"example.com", "default.test"},
+		{"override.test", "ignored.test"},
+		{"ignored.test", "default.test"},
+		{"", "default.test"},
+	}
+	for _, tt := range tests {
+		if got := served(t, m, tt.sni); got != tt.want {
+			t.Errorf("SNI %q: served %s, want %s", tt.sni, got, tt.want)
+		}
+	}
+
+	if _, err := NewManager().GetCertificate(&tls.ClientHelloInfo{}); !errors.Is(err, ErrNoCertificate) {
+		t.Errorf("empty manager: error = %v, want ErrNoCertificate", err)
+	}
+}
+
+func TestReloadKeepsPreviousCertificates(t *testing.T) {
+	dir := t.TempDir()
+	pair := writePair(t, dir, "a.test")
+	m := NewManager()
+	if err := m.Configure([]Pair{pair}, Policy{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A renewal caught half written
+	certPEM, keyPEM := generate(t, "b.test")
+	os.WriteFile(pair.CertFile, certPEM[:len(certPEM)/2], 0o600)
+	if err := m.Reload(); err == nil {
+		t.Fatal("Reload() of a truncated certificate succeeded")
+	}
+	if got := served(t, m, "a.test"); got != "a.test" {
+		t.Errorf("after a failed reload served %s, want the previous a.test", got)
+	}
+
+	// A new certificate with the old key doesn't load either
+	os.WriteFile(pair.CertFile, certPEM, 0o600)
+	if err := m.Reload(); err == nil {
+		t.Fatal("Reload() of a mismatched pair succeeded")
+	}
+	stats := m.Stats()
+	if stats["reload_failures"] != int64(2) || stats["last_error"] == nil {
+		t.Errorf("stats = %v, want 2 failures and the last error", stats)
+	}
+
+	os.WriteFile(pair.KeyFile, keyPEM, 0o600)
+	if err := m.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if got := served(t, m, "b.test"); got != "b.test" {
+		t.Errorf("after the renewal served %s, want b.test", got)
+	}
+	if stats := m.Stats(); stats["reloads"] != int64(1) || stats["last_error"] != nil {
+		t.Errorf("stats = %v, want 1 reload and no error", stats)
+	}
+
+	// A bad new pair leaves the current set in place
+	if err := m.Configure([]Pair{{CertFile: filepath.Join(dir, "missing.crt")}}, Policy{}); err == nil {
+		t.Fatal("Configure() with a missing file succeeded")
+	}
+	if got := served(t, m, "b.test"); got != "b.test" || len(m.Files()) != 2 {
+		t.Errorf("after a failed Configure served %s from %v", got, m.Files())
+	}
+}
+
+func TestTLSConfigPolicy(t *testing.T) {
+	dir := t.TempDir()
+	server := writePair(t, dir, "gateway.test")
+	client := writePair(t, dir, "client.test")
+	clientCert, err := tls.LoadX509KeyPair(client.CertFile, client.KeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCAs, err := LoadCAs(client.CertFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverCAs, err := LoadCAs(server.CertFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		policy     Policy
+		maxVersion uint16 // offered by the client
+		clientCert bool
+		ok         bool
+	}{
+		{"tls 1.2", Policy{MinVersion: tls.VersionTLS12}, tls.VersionTLS12, false, true},
+		{"tls 1.3 required", Policy{MinVersion: tls.VersionTLS13}, tls.VersionTLS12, false, false},
+		{"client cert required", Policy{MinVersion: tls.VersionTLS12, ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}, tls.VersionTLS13, false, false},
+		{"client cert given", Policy{MinVersion: tls.VersionTLS12, ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}, tls.VersionTLS13, true, true},
+		{"client cert optional", Policy{MinVersion: tls.VersionTLS12, ClientAuth: tls.VerifyClientCertIfGiven, ClientCAs: clientCAs}, tls.VersionTLS13, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewManager()
+			if err := m.Configure([]Pair{server}, tt.policy); err != nil {
+				t.Fatal(err)
+			}
+			cfg := m.TLSConfig()
+			// Protocols added after TLSConfig are still offered
+			cfg.NextProtos = []string{"h2"}
+
+			clientCfg := &tls.Config{
+				ServerName: "gateway.test",
+				RootCAs:    serverCAs,
+				MaxVersion: tt.maxVersion,
+				NextProtos: []string{"h2"},
+			}
+			if tt.clientCert {
+				clientCfg.Certificates = []tls.Certificate{clientCert}
+			}
+
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ln.Close()
+			serverErr := make(chan error, 1)
+			go func() {
+				s, err := ln.Accept()
+				if err != nil {
+					serverErr <- err
+					return
+				}
+				defer s.Close()
+				conn := tls.Server(s, cfg)
+				err = conn.Handshake()
+				if err == nil {
+					// TLS 1.3 client certificates are checked after the
+					// client's handshake completes; reading surfaces them
+					_, err = conn.Read(make([]byte, 1))
+				}
+				serverErr <- err
+			}()
+			c, err := net.Dial("tcp", ln.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+			conn := tls.Client(c, clientCfg)
+			err = conn.Handshake()
+			if err == nil {
+				conn.Write([]byte("x"))
+				err = <-serverErr
+			}
+			if (err == nil) != tt.ok {
+				t.Fatalf("handshake error = %v, want ok %v", err, tt.ok)
+			}
+			if tt.ok && conn.ConnectionState().NegotiatedProtocol != "h2" {
+				t.Errorf("negotiated %q, want h2", conn.ConnectionState().NegotiatedProtocol)
+			}
+		})
+	}
+}
@@ -3,11 +3,18 @@ package circuitbreaker
 import (
 	"context"
 	"errors"
+	"math"
 	"sync"
-	"sync/atomic"
 	"time"
 )
 
+// ErrOpen is returned when the circuit rejects a call because it is open
+var ErrOpen = errors.New("circuit breaker is open")
+
+// ErrTooManyProbes is returned when the circuit is half-open and all trial
+// slots are taken
+var ErrTooManyProbes = errors.New("circuit breaker is half-open and probing")
+
 // State represents circuit breaker state
 type State int
 
@@ -17,98 +24,285 @@ const (
 	StateHalfOpen
 )
 
-// Breaker implements a circuit breaker pattern with health scoring
+func (s State) String() string {
+	switch s {
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// Event describes a state transition
+type Event struct {
+	From   State
+	To     State
+	Reason string
+	Time   time.Time
+	// OpenUntil is when an open circuit will admit probes again; zero
+	// unless To is StateOpen
+	OpenUntil time.Time
+}
+
+// Done reports the outcome of a call admitted by Allow. It must be called
+// exactly once.
+type Done func(Outcome)
+
+// Breaker implements a circuit breaker pattern with health scoring.
+//
+// Closed: calls pass; consecutive failures (reset by any success) or the
+// sliding-window rates trip the circuit. Open: calls are rejected until the
+// open timeout elapses; the timeout grows by BackoffMultiplier every time
+// the circuit re-opens from half-open, up to MaxTimeoutSeconds. Half-open:
+// at most HalfOpenMaxCalls trial calls run at once; SuccessThreshold
+// successes close the circuit and any failure re-opens it.
 type Breaker struct {
-	state           atomic.Value // State
-	failureCount    atomic.Int64
-	successCount    atomic.Int64
-	lastFailureTime atomic.Int64
-	failureThreshold int
-	successThreshold int
-	timeoutSeconds   int64
-	health          *HealthTracker
-	mu              sync.RWMutex
-}
-
-// NewBreaker creates a new circuit breaker
+	mu                  sync.Mutex
+	state               State
+	generation          uint64 // bumped on every transition
+	consecutiveFailures int
+	halfOpenSuccesses   int
+	halfOpenInFlight    int
+	reopenCount         int
+	openUntil           time.Time
+	totalFailures       int64
+	totalSuccesses      int64
+	totalRejected       int64
+	settings            Settings
+	window              *window
+	health              *HealthTracker
+	subscribers         []func(Event)
+	now                 func() time.Time // the clock, replaced in tests
+}
+
+// NewBreaker creates a new circuit breaker that trips on consecutive
+// failures only
 func NewBreaker(failureThreshold, successThreshold, timeoutSeconds int, healthDecay float64) *Breaker {
-	b := &Breaker{
-		failureThreshold: failureThreshold,
-		successThreshold: successThreshold,
-		timeoutSeconds:   int64(timeoutSeconds),
-		health:          NewHealthTracker(healthDecay),
+	return NewBreakerWithSettings(Settings{
+		FailureThreshold: failureThreshold,
+		SuccessThreshold: successThreshold,
+		TimeoutSeconds:   timeoutSeconds,
+		HealthDecay:      healthDecay,
+	})
+}
+
+// NewBreakerWithSettings creates a circuit breaker from full settings
+func NewBreakerWithSettings(s Settings) *Breaker {
+	return &Breaker{
+		state:    StateClosed,
+		settings: s,
+		window:   newWindow(s.WindowSeconds),
+		health:   NewHealthTracker(s.HealthDecay),
+		now:      time.Now,
 	}
-	b.state.Store(StateClosed)
-	return b
 }
 
-// Execute executes a function through the circuit breaker
+// Subscribe registers fn to be called after every state transition.
+// Subscribers run synchronously, outside the breaker's lock.
+func (b *Breaker) Subscribe(fn func(Event)) {
+	b.mu.Lock()
+	b.subscribers = append(b.subscribers, fn)
+	b.mu.Unlock()
+}
+
+// Execute executes a function through the circuit breaker. Errors count as
+// failures, and deadline errors additionally as timeouts.
 func (b *Breaker) Execute(ctx context.Context, fn func() error) error {
-	if !b.Allow() {
-		return errors.New("circuit breaker is open")
+	done, err := b.Allow()
+	if err != nil {
+		return err
 	}
 
-	err := fn()
-	
+	err = fn()
 	if err != nil {
-		b.RecordFailure()
+		done(Outcome{Failure: true, Timeout: isTimeout(ctx, err)})
 	} else {
-		b.RecordSuccess()
+		done(Outcome{})
 	}
 
 	return err
 }
 
-// Allow checks if requests are allowed
-func (b *Breaker) Allow() bool {
-	state := b.state.Load().(State)
-	
-	switch state {
-	case StateClosed:
-		return true
+// Allow admits a call or rejects it with ErrOpen or ErrTooManyProbes. An
+// admitted call must report its outcome through the returned Done.
+func (b *Breaker) Allow() (Done, error) {
+	b.mu.Lock()
+
+	now := b.now()
+	var events []Event
+	if b.state == StateOpen && !now.Before(b.openUntil) {
+		events = append(events, b.transition(StateHalfOpen, "open timeout elapsed", now))
+	}
+
+	switch b.state {
 	case StateOpen:
-		if time.Now().Unix() >= b.lastFailureTime.Load()+b.timeoutSeconds {
-			// Timeout expired, try half-open
-			if b.state.CompareAndSwap(StateOpen, StateHalfOpen) {
-				b.successCount.Store(0)
-			}
-			return true
-		}
-		return false
+		b.totalRejected++
+		b.mu.Unlock()
+		return nil, ErrOpen
 	case StateHalfOpen:
-		return true
-	default:
-		return false
+		if b.halfOpenInFlight >= max(b.settings.HalfOpenMaxCalls, 1) {
+			b.totalRejected++
+			b.mu.Unlock()
+			b.notify(events)
+			return nil, ErrTooManyProbes
+		}
+		b.halfOpenInFlight++
 	}
+
+	generation := b.generation
+	probe := b.state == StateHalfOpen
+	b.mu.Unlock()
+	b.notify(events)
+
+	var once sync.Once
+	return func(o Outcome) {
+		once.Do(func() { b.record(generation, probe, o) })
+	}, nil
 }
 
-// RecordSuccess records a successful request
-func (b *Breaker) RecordSuccess() {
-	b.health.RecordSuccess()
-	success := b.successCount.Add(1)
+// record applies an outcome. Outcomes of calls admitted in an earlier
+// generation still count toward health and the window but cannot drive
+// the current state, so a slow call from before a trip can't close it.
+func (b *Breaker) record(generation uint64, probe bool, o Outcome) {
+	if o.Canceled {
+		b.mu.Lock()
+		if probe && generation == b.generation {
+			b.halfOpenInFlight--
+		}
+		b.mu.Unlock()
+		return
+	}
+
+	switch {
+	case o.Timeout:
+		b.health.RecordTimeout()
+	case o.Failure:
+		b.health.RecordFailure()
+	default:
+		b.health.RecordSuccess()
+	}
+
+	b.mu.Lock()
+	now := b.now()
+	b.window.record(o, now)
+	if o.Failure {
+		b.totalFailures++
+	} else {
+		b.totalSuccesses++
+	}
+
+	if generation != b.generation {
+		b.mu.Unlock()
+		return
+	}
 
-	if b.state.Load().(State) == StateHalfOpen {
-		if int(success) >= b.successThreshold {
-			b.state.Store(StateClosed)
-			b.failureCount.Store(0)
+	var events []Event
+	switch b.state {
+	case StateClosed:
+		if o.Failure {
+			b.consecutiveFailures++
+		} else {
+			b.consecutiveFailures = 0
+		}
+		if b.consecutiveFailures >= b.settings.FailureThreshold {
+			events = append(events, b.transition(StateOpen, "consecutive failure threshold reached", now))
+		} else if b.window.exceeds(b.settings, now) {
+			events = append(events, b.transition(StateOpen, "failure or slow-call rate threshold reached", now))
 		}
+	case StateHalfOpen:
+		if probe {
+			b.halfOpenInFlight--
+		}
+		if o.Failure {
+			b.reopenCount++
+			events = append(events, b.transition(StateOpen, "probe failed", now))
+		} else {
+			b.halfOpenSuccesses++
+			if b.halfOpenSuccesses >= b.settings.SuccessThreshold {
+				b.reopenCount = 0
+				b.window.reset()
+				events = append(events, b.transition(StateClosed, "probes succeeded", now))
+			}
+		}
+	}
+	b.mu.Unlock()
+	b.notify(events)
+}
+
+// transition moves to state to, resetting the per-state counters. Callers
+// must hold b.mu and pass the returned event to notify after unlocking.
+func (b *Breaker) transition(to State, reason string, now time.Time) Event {
+	e := Event{From: b.state, To: to, Reason: reason, Time: now}
+
+	b.state = to
+	b.generation++
+	b.consecutiveFailures = 0
+	b.halfOpenSuccesses = 0
+	b.halfOpenInFlight = 0
+
+	if to == StateOpen {
+		b.openUntil = now.Add(b.openTimeout())
+		e.OpenUntil = b.openUntil
+	}
+	return e
+}
+
+// openTimeout is the base timeout grown exponentially by the number of
+// consecutive re-openings, capped at MaxTimeoutSeconds
+func (b *Breaker) openTimeout() time.Duration {
+	timeout := float64(b.settings.TimeoutSeconds)
+	if b.settings.BackoffMultiplier > 1 && b.reopenCount > 0 {
+		timeout *= math.Pow(b.settings.BackoffMultiplier, float64(b.reopenCount))
 	}
+	if limit := float64(b.settings.MaxTimeoutSeconds); limit > 0 && timeout > limit {
+		timeout = limit
+	}
+	return time.Duration(timeout * float64(time.Second))
 }
 
-// RecordFailure records a failed request
-func (b *Breaker) RecordFailure() {
-	b.health.RecordFailure()
-	failures := b.failureCount.Add(1)
-	b.lastFailureTime.Store(time.Now().Unix())
+func (b *Breaker) notify(events []Event) {
+	if len(events) == 0 {
+		return
+	}
+	b.mu.Lock()
+	subscribers := b.subscribers
+	b.mu.Unlock()
+
+	for _, e := range events {
+		for _, fn := range subscribers {
+			fn(e)
+		}
+	}
+}
 
-	if int(failures) >= b.failureThreshold {
-		b.state.Store(StateOpen)
+// Reconfigure updates settings in place, preserving the current state
+// and counters so a config reload doesn't close an open circuit
+func (b *Breaker) Reconfigure(s Settings) {
+	b.mu.Lock()
+	if s.WindowSeconds != b.settings.WindowSeconds {
+		b.window = newWindow(s.WindowSeconds)
 	}
+	b.settings = s
+	b.mu.Unlock()
+	b.health.SetDecay(s.HealthDecay)
+}
+
+// Settings returns the breaker's current configuration
+func (b *Breaker) Settings() Settings {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.settings
 }
 
-// GetState returns current state
+// GetState returns current state. An open circuit whose timeout has
+// elapsed is still reported open until the next Allow moves it to
+// half-open.
 func (b *Breaker) GetState() State {
-	return b.state.Load().(State)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
 }
 
 // GetHealthScore returns current health score
@@ -118,19 +312,25 @@ func (b *Breaker) GetHealthScore() int {
 
 // Stats returns breaker statistics
 func (b *Breaker) Stats() map[string]interface{} {
-	state := b.state.Load().(State)
-	stateStr := "closed"
-	switch state {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	stats := map[string]interface{}{
+		"state":                b.state.String(),
+		"health_score":         b.health.GetScore(),
+		"failures":             b.totalFailures,
+		"successes":            b.totalSuccesses,
+		"rejected":             b.totalRejected,
+		"consecutive_failures": b.consecutiveFailures,
+		"reopen_count":         b.reopenCount,
+		"window":               b.window.stats(b.now()),
+	}
+	switch b.state {
 	case StateOpen:
-		stateStr = "open"
+		stats["open_until"] = b.openUntil.Unix()
 	case StateHalfOpen:
-		stateStr = "half-open"
-	}
-
-	return map[string]interface{}{
-		"state":        stateStr,
-		"health_score": b.health.GetScore(),
-		"failures":     b.failureCount.Load(),
-		"successes":    b.successCount.Load(),
+		stats["half_open_in_flight"] = b.halfOpenInFlight
+		stats["half_open_successes"] = b.halfOpenSuccesses
 	}
+	return stats
 }
@@ -0,0 +1,169 @@
+package circuitbreaker
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// step drives a breaker: "allow" admits the next call (or expects err),
+// "ok", "fail" and "cancel" report the outcome of admitted call n, and
+// "wait" advances the clock by d. want is the state after the step.
+type step struct {
+	do   string
+	n    int
+	d    time.Duration
+	err  error
+	want State
+}
+
+func allow(want State) step                 { return step{do: "allow", want: want} }
+func reject(err error, want State) step     { return step{do: "allow", err: err, want: want} }
+func ok(n int, want State) step             { return step{do: "ok", n: n, want: want} }
+func fail(n int, want State) step           { return step{do: "fail", n: n, want: want} }
+func cancel(n int, want State) step         { return step{do: "cancel", n: n, want: want} }
+func wait(d time.Duration, want State) step { return step{do: "wait", d: d, want: want} }
+
+// testBreaker returns a breaker on a fake clock and a function advancing it
+func testBreaker(s Settings) (*Breaker, func(time.Duration)) {
+	b := NewBreakerWithSettings(s)
+	now := time.Unix(1_000_000, 0)
+	b.now = func() time.Time { return now }
+	return b, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestBreakerStateMachine(t *testing.T) {
+	settings := Settings{
+		FailureThreshold:  2,
+		SuccessThreshold:  2,
+		TimeoutSeconds:    10,
+		HalfOpenMaxCalls:  2,
+		BackoffMultiplier: 2,
+		MaxTimeoutSeconds: 30,
+		WindowSeconds:     10,
+	}
+	const (
+		closed   = StateClosed
+		open     = StateOpen
+		halfOpen = StateHalfOpen
+	)
+	// trip opens the circuit with calls 0 and 1
+	trip := []step{allow(closed), allow(closed), fail(0, closed), fail(1, open)}
+
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{"consecutive failures trip", append(trip, reject(ErrOpen, open))},
+		{"success resets the count", []step{
+			allow(closed), allow(closed), allow(closed),
+			fail(0, closed), ok(1, closed), fail(2, closed),
+		}},
+		{"canceled calls don't count", []step{
+			allow(closed), allow(closed), allow(closed),
+			fail(0, closed), cancel(1, closed), allow(closed), fail(2, open),
+		}},
+		{"open until the timeout elapses", append(trip,
+			wait(9*time.Second, open), reject(ErrOpen, open),
+			wait(time.Second, open), allow(halfOpen),
+		)},
+		{"probes close the circuit", append(trip,
+			wait(10*time.Second, open), allow(halfOpen), ok(2, halfOpen),
+			allow(halfOpen), ok(3, closed), allow(closed),
+		)},
+		{"probe cap", append(trip,
+			wait(10*time.Second, open), allow(halfOpen), allow(halfOpen),
+			reject(ErrTooManyProbes, halfOpen),
+			ok(2, halfOpen), allow(halfOpen), reject(ErrTooManyProbes, halfOpen),
+		)},
+		{"canceled probe frees its slot", append(trip,
+			wait(10*time.Second, open), allow(halfOpen), allow(halfOpen),
+			cancel(2, halfOpen), allow(halfOpen), reject(ErrTooManyProbes, halfOpen),
+		)},
+		{"failed probe reopens with backoff", append(trip,
+			wait(10*time.Second, open), allow(halfOpen), fail(2, open),
+			wait(19*time.Second, open), reject(ErrOpen, open),
+			wait(time.Second, open), allow(halfOpen), fail(3, open),
+			// 40s, capped at 30s
+			wait(29*time.Second, open), reject(ErrOpen, open),
+			wait(time.Second, open), allow(halfOpen),
+		)},
+		{"backoff resets once closed", append(trip,
+			wait(10*time.Second, open), allow(halfOpen), fail(2, open),
+			wait(20*time.Second, open), allow(halfOpen), allow(halfOpen), ok(3, halfOpen), ok(4, closed),
+			allow(closed), allow(closed), fail(5, closed), fail(6, open),
+			wait(10*time.Second, open), allow(halfOpen),
+		)},
+		{"stale outcomes don't drive the state", []step{
+			allow(closed), allow(closed), allow(closed), fail(1, closed), fail(2, open),
+			wait(10*time.Second, open), allow(halfOpen),
+			// Call 0 was admitted while closed
+			fail(0, halfOpen), ok(3, halfOpen),
+			allow(halfOpen), ok(4, closed),
+		}},
+		{"stale success doesn't count", []step{
+			allow(closed), allow(closed), allow(closed), fail(0, closed), fail(1, open),
+			wait(10*time.Second, open), allow(halfOpen),
+			ok(2, halfOpen), ok(3, halfOpen),
+		}},
+		{"done twice counts once", append(trip,
+			wait(10*time.Second, open), allow(halfOpen), ok(2, halfOpen), ok(2, halfOpen),
+		)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, advance := testBreaker(settings)
+			var calls []Done
+			for i, s := range tt.steps {
+				switch s.do {
+				case "allow":
+					done, err := b.Allow()
+					if !errors.Is(err, s.err) {
+						t.Fatalf("step %d: Allow() error = %v, want %v", i, err, s.err)
+					}
+					if err == nil {
+						calls = append(calls, done)
+					}
+				case "ok":
+					calls[s.n](Outcome{})
+				case "fail":
+					calls[s.n](Outcome{Failure: true})
+				case "cancel":
+					calls[s.n](Outcome{Canceled: true})
+				case "wait":
+					advance(s.d)
+				}
+				if got := b.GetState(); got != s.want {
+					t.Fatalf("step %d (%s): state = %s, want %s", i, s.do, got, s.want)
+				}
+			}
+		})
+	}
+}
+
+func TestBreakerEvents(t *testing.T) {
+	b, advance := testBreaker(Settings{FailureThreshold: 1, SuccessThreshold: 1, TimeoutSeconds: 10, HalfOpenMaxCalls: 1})
+	start := b.now()
+	var events []Event
+	b.Subscribe(func(e Event) { events = append(events, e) })
+
+	done, _ := b.Allow()
+	done(Outcome{Failure: true})
+	advance(10 * time.Second)
+	done, _ = b.Allow()
+	done(Outcome{})
+
+	want := []Event{
+		{From: StateClosed, To: StateOpen, Reason: "consecutive failure threshold reached", Time: start, OpenUntil: start.Add(10 * time.Second)},
+		{From: StateOpen, To: StateHalfOpen, Reason: "open timeout elapsed", Time: start.Add(10 * time.Second)},
+		{From: StateHalfOpen, To: StateClosed, Reason: "probes succeeded", Time: start.Add(10 * time.Second)},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("got %d events, want %d: %+v", len(events), len(want), events)
+	}
+	for i := range want {
+		if events[i] != want[i] {
+			t.Errorf("event %d = %+v, want %+v", i, events[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package circuitbreaker
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+)
+
+// Outcome describes how a call through the breaker went
+type Outcome struct {
+	Failure bool // counts toward the failure threshold and rate
+	Slow    bool // took longer than the slow-call threshold
+	Timeout bool // failed by exceeding its deadline
+
+	// Canceled calls were abandoned by the caller, e.g. a client that went
+	// away or a losing hedged request. They say nothing about the upstream
+	// and are not counted.
+	Canceled bool
+}
+
+// Classifier turns a completed upstream call into an Outcome. Transport
+// errors are always failures; responses are failures when their HTTP
+// status or gRPC status code is listed, and slow when they exceed the
+// slow-call threshold.
+type Classifier struct {
+	failureStatus map[int]bool
+	failureGRPC   map[int]bool
+	slowCall      time.Duration
+}
+
+// NewClassifier creates a classifier. A zero slowCall disables slow-call
+// detection.
+func NewClassifier(failureStatus, failureGRPC []int, slowCall time.Duration) *Classifier {
+	c := &Classifier{
+		failureStatus: make(map[int]bool, len(failureStatus)),
+		failureGRPC:   make(map[int]bool, len(failureGRPC)),
+		slowCall:      slowCall,
+	}
+	for _, code := range failureStatus {
+		c.failureStatus[code] = true
+	}
+	for _, code := range failureGRPC {
+		c.failureGRPC[code] = true
+	}
+	return c
+}
+
+// Classify returns the outcome of a call that returned statusCode and err
+// after elapsed. ctx is the call's context, used to detect deadlines; a
+// context canceled with context.DeadlineExceeded as its cause timed out.
+func (c *Classifier) Classify(ctx context.Context, statusCode int, err error, elapsed time.Duration) Outcome {
+	o := Outcome{Slow: c.slowCall > 0 && elapsed >= c.slowCall}
+	if err != nil && errors.Is(context.Cause(ctx), context.Canceled) {
+		return Outcome{Canceled: true}
+	}
+	if err != nil {
+		o.Failure = true
+		o.Timeout = isTimeout(ctx, err)
+		return o
+	}
+	o.Failure = c.failureStatus[statusCode]
+	return o
+}
+
+// grpcDeadlineExceeded is gRPC's DEADLINE_EXCEEDED status code
+const grpcDeadlineExceeded = 4
+
+// ClassifyGRPC returns the outcome of a gRPC call that ended with status
+// code, or with err if its stream broke first. elapsed is the time to the
+// response headers, as streaming calls may rightly last much longer.
+func (c *Classifier) ClassifyGRPC(ctx context.Context, code int, err error, elapsed time.Duration) Outcome {
+	o := c.Classify(ctx, 0, err, elapsed)
+	if err == nil {
+		o.Failure = c.failureGRPC[code]
+		o.Timeout = code == grpcDeadlineExceeded
+	}
+	return o
+}
+
+func isTimeout(ctx context.Context, err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(context.Cause(ctx), context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
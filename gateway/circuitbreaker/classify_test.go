@@ -0,0 +1,81 @@
+package circuitbreaker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// netTimeout is a net.Error that timed out
+type netTimeout struct{}
+
+func (netTimeout) Error() string   { return "i/o timeout" }
+func (netTimeout) Timeout() bool   { return true }
+func (netTimeout) Temporary() bool { return true }
+
+func TestClassify(t *testing.T) {
+	c := NewClassifier([]int{502, 503}, []int{14}, time.Second)
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	timedOut, cancelCause := context.WithCancelCause(context.Background())
+	cancelCause(context.DeadlineExceeded)
+	expired, cancelExpired := context.WithTimeout(context.Background(), -time.Second)
+	defer cancelExpired()
+	refused := errors.New("connection refused")
+
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		status  int
+		err     error
+		elapsed time.Duration
+		want    Outcome
+	}{
+		{"ok", context.Background(), 200, nil, 0, Outcome{}},
+		{"listed status", context.Background(), 503, nil, 0, Outcome{Failure: true}},
+		{"unlisted status", context.Background(), 500, nil, 0, Outcome{}},
+		{"slow", context.Background(), 200, nil, time.Second, Outcome{Slow: true}},
+		{"just under slow", context.Background(), 200, nil, time.Second - 1, Outcome{}},
+		{"slow failure", context.Background(), 502, nil, 2 * time.Second, Outcome{Failure: true, Slow: true}},
+		{"transport error", context.Background(), 0, refused, 0, Outcome{Failure: true}},
+		{"deadline error", context.Background(), 0, context.DeadlineExceeded, 0, Outcome{Failure: true, Timeout: true}},
+		{"network timeout", context.Background(), 0, netTimeout{}, 0, Outcome{Failure: true, Timeout: true}},
+		{"expired context", expired, 0, refused, 0, Outcome{Failure: true, Timeout: true}},
+		{"canceled by caller", canceled, 0, context.Canceled, 0, Outcome{Canceled: true}},
+		{"canceled by header timeout", timedOut, 0, context.Canceled, 0, Outcome{Failure: true, Timeout: true}},
+		{"canceled after the response", canceled, 503, nil, 0, Outcome{Failure: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := c.Classify(tt.ctx, tt.status, tt.err, tt.elapsed); got != tt.want {
+				t.Errorf("Classify() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClassifyGRPC(t *testing.T) {
+	c := NewClassifier(nil, []int{14}, time.Second)
+	tests := []struct {
+		name    string
+		code    int
+		err     error
+		elapsed time.Duration
+		want    Outcome
+	}{
+		{"ok", 0, nil, 0, Outcome{}},
+		{"listed code", 14, nil, 0, Outcome{Failure: true}},
+		{"unlisted code", 13, nil, 0, Outcome{}},
+		{"deadline exceeded", 4, nil, 0, Outcome{Timeout: true}},
+		{"slow headers", 0, nil, time.Second, Outcome{Slow: true}},
+		{"broken stream", 0, errors.New("stream reset"), 0, Outcome{Failure: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := c.ClassifyGRPC(context.Background(), tt.code, tt.err, tt.elapsed); got != tt.want {
+				t.Errorf("ClassifyGRPC() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
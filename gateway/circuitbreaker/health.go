@@ -39,7 +39,17 @@ func (ht *HealthTracker) GetScore() int {
 	return int(ht.healthScore.Load())
 }
 
+// SetDecay changes the EMA decay factor used for future updates
+func (ht *HealthTracker) SetDecay(decay float64) {
+	ht.mu.Lock()
+	ht.alpha = decay
+	ht.mu.Unlock()
+}
+
 func (ht *HealthTracker) updateHealth(delta int) {
+	ht.mu.Lock()
+	defer ht.mu.Unlock()
+
 	current := float64(ht.healthScore.Load())
 	// Exponential moving average update
 	newScore := current*ht.alpha + float64(delta)*(1-ht.alpha)
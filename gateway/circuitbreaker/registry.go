@@ -0,0 +1,156 @@
+package circuitbreaker
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Settings holds the tunable parameters of a breaker
+type Settings struct {
+	FailureThreshold int
+	SuccessThreshold int
+	TimeoutSeconds   int
+	HealthDecay      float64
+
+	// Rate thresholds are percentages of calls in the sliding window; zero
+	// disables them. They only apply once MinimumCalls were recorded.
+	FailureRateThreshold  float64
+	SlowCallRateThreshold float64
+	WindowSeconds         int
+	MinimumCalls          int
+
+	// HalfOpenMaxCalls bounds concurrent trial calls in half-open state.
+	// Each re-opening from half-open multiplies the open timeout by
+	// BackoffMultiplier, up to MaxTimeoutSeconds.
+	HalfOpenMaxCalls  int
+	BackoffMultiplier float64
+	MaxTimeoutSeconds int
+}
+
+// Registry hands out one breaker per key (an upstream target or a route)
+// so a failing backend only trips its own circuit. The number of breakers
+// is bounded; when full, the least recently used closed breaker is evicted
+// so dynamically discovered targets cannot grow the map without limit.
+type Registry struct {
+	mu          sync.RWMutex
+	breakers    map[string]*registryEntry
+	maxBreakers int
+	subscribers []func(key string, e Event)
+	now         func() time.Time // the clock, replaced in tests
+}
+
+type registryEntry struct {
+	breaker  *Breaker
+	lastUsed atomic.Int64 // Unix nanoseconds
+}
+
+// NewRegistry creates a registry holding at most maxBreakers breakers
+func NewRegistry(maxBreakers int) *Registry {
+	return &Registry{
+		breakers:    make(map[string]*registryEntry),
+		maxBreakers: maxBreakers,
+		now:         time.Now,
+	}
+}
+
+// Get returns the breaker for key, creating it with settings if needed. An
+// existing breaker whose settings differ is reconfigured in place, keeping
+// its state.
+func (r *Registry) Get(key string, settings Settings) *Breaker {
+	now := r.now().UnixNano()
+
+	r.mu.RLock()
+	e, ok := r.breakers[key]
+	r.mu.RUnlock()
+
+	if !ok {
+		r.mu.Lock()
+		if e, ok = r.breakers[key]; !ok {
+			if len(r.breakers) >= r.maxBreakers {
+				r.evict()
+			}
+			e = &registryEntry{breaker: r.newBreaker(key, settings)}
+			r.breakers[key] = e
+		}
+		r.mu.Unlock()
+	}
+
+	e.lastUsed.Store(now)
+	if e.breaker.Settings() != settings {
+		e.breaker.Reconfigure(settings)
+	}
+	return e.breaker
+}
+
+// Subscribe registers fn to be called with the key of every breaker that
+// changes state. It applies to breakers created after the call, so
+// subscribe before serving traffic.
+func (r *Registry) Subscribe(fn func(key string, e Event)) {
+	r.mu.Lock()
+	r.subscribers = append(r.subscribers, fn)
+	r.mu.Unlock()
+}
+
+// newBreaker creates a breaker forwarding its events to the registry's
+// subscribers. Callers must hold r.mu.
+func (r *Registry) newBreaker(key string, settings Settings) *Breaker {
+	b := NewBreakerWithSettings(settings)
+	for _, fn := range r.subscribers {
+		fn := fn
+		b.Subscribe(func(e Event) { fn(key, e) })
+	}
+	return b
+}
+
+// Resize changes the maximum number of breakers, evicting as needed
+func (r *Registry) Resize(maxBreakers int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.maxBreakers = maxBreakers
+	for len(r.breakers) > r.maxBreakers {
+		r.evict()
+	}
+}
+
+// evict removes the least recently used breaker, preferring closed ones so
+// an open circuit isn't silently reset. Callers must hold r.mu.
+func (r *Registry) evict() {
+	var victim string
+	var victimUsed int64
+	victimOpen := true
+
+	for k, e := range r.breakers {
+		used := e.lastUsed.Load()
+		open := e.breaker.GetState() != StateClosed
+		if victim == "" || (victimOpen && !open) || (victimOpen == open && used < victimUsed) {
+			victim, victimUsed, victimOpen = k, used, open
+		}
+	}
+	delete(r.breakers, victim)
+}
+
+// States returns the state of every breaker by key
+func (r *Registry) States() map[string]State {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	states := make(map[string]State, len(r.breakers))
+	for k, e := range r.breakers {
+		states[k] = e.breaker.GetState()
+	}
+	return states
+}
+
+// Stats returns per-breaker statistics by key
+func (r *Registry) Stats() map[string]interface{} {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	stats := make(map[string]interface{}, len(r.breakers))
+	for k, e := range r.breakers {
+		stats[k] = e.breaker.Stats()
+	}
+	return stats
+}
@@ -0,0 +1,100 @@
+package circuitbreaker
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// testRegistry returns a registry whose clock moves a second on every read
+func testRegistry(maxBreakers int) *Registry {
+	r := NewRegistry(maxBreakers)
+	now := time.Unix(1_000_000, 0)
+	r.now = func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	}
+	return r
+}
+
+func keys(r *Registry) []string {
+	var keys []string
+	for k := range r.States() {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestRegistryEviction(t *testing.T) {
+	settings := Settings{FailureThreshold: 1, SuccessThreshold: 1, TimeoutSeconds: 60}
+	tests := []struct {
+		name string
+		max  int
+		gets []string
+		open []string // tripped right after they are first fetched
+		want []string
+	}{
+		{"under the limit", 3, []string{"a", "b", "c"}, nil, []string{"a", "b", "c"}},
+		{"least recently used goes", 2, []string{"a", "b", "a", "c"}, nil, []string{"a", "c"}},
+		{"open circuits kept", 2, []string{"a", "b", "c"}, []string{"a"}, []string{"a", "c"}},
+		{"oldest open circuit when all are open", 2, []string{"a", "b", "c"}, []string{"a", "b"}, []string{"b", "c"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := testRegistry(tt.max)
+			for _, key := range tt.gets {
+				b := r.Get(key, settings)
+				for _, o := range tt.open {
+					if o == key && b.GetState() == StateClosed {
+						done, _ := b.Allow()
+						done(Outcome{Failure: true})
+					}
+				}
+			}
+			if got := keys(r); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("breakers = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRegistryGet(t *testing.T) {
+	r := testRegistry(10)
+	var transitions []string
+	r.Subscribe(func(key string, e Event) { transitions = append(transitions, key+" "+e.To.String()) })
+
+	settings := Settings{FailureThreshold: 1, SuccessThreshold: 1, TimeoutSeconds: 60}
+	a := r.Get("a", settings)
+	if r.Get("a", settings) != a || r.Get("b", settings) == a {
+		t.Fatal("Get() doesn't return one breaker per key")
+	}
+
+	done, _ := a.Allow()
+	done(Outcome{Failure: true})
+	if !reflect.DeepEqual(transitions, []string{"a open"}) {
+		t.Errorf("transitions = %v, want [a open]", transitions)
+	}
+
+	// New settings are applied in place, keeping the open circuit
+	settings.FailureThreshold = 5
+	if got := r.Get("a", settings); got != a || got.Settings().FailureThreshold != 5 || got.GetState() != StateOpen {
+		t.Errorf("reconfigured breaker: same %v, threshold %d, state %s", got == a, got.Settings().FailureThreshold, got.GetState())
+	}
+}
+
+func TestRegistryResize(t *testing.T) {
+	r := testRegistry(10)
+	for _, key := range []string{"a", "b", "c", "b"} {
+		r.Get(key, Settings{})
+	}
+	r.Resize(1)
+	if got := keys(r); !reflect.DeepEqual(got, []string{"b"}) {
+		t.Errorf("after Resize(1) breakers = %v, want [b]", got)
+	}
+	r.Get("d", Settings{})
+	if got := keys(r); !reflect.DeepEqual(got, []string{"d"}) {
+		t.Errorf("breakers = %v, want [d]", got)
+	}
+}
@@ -0,0 +1,110 @@
+package circuitbreaker
+
+import (
+	"sync"
+	"time"
+)
+
+// window counts calls, failures and slow calls over the last N seconds
+// using one bucket per second
+type window struct {
+	mu      sync.Mutex
+	buckets []bucket
+}
+
+type bucket struct {
+	second   int64
+	calls    int64
+	failures int64
+	slow     int64
+}
+
+func newWindow(seconds int) *window {
+	if seconds <= 0 {
+		seconds = 1
+	}
+	return &window{buckets: make([]bucket, seconds)}
+}
+
+// record counts o in the bucket for the second of now
+func (w *window) record(o Outcome, now time.Time) {
+	second := now.Unix()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	b := &w.buckets[second%int64(len(w.buckets))]
+	if b.second != second {
+		*b = bucket{second: second}
+	}
+	b.calls++
+	if o.Failure {
+		b.failures++
+	}
+	if o.Slow {
+		b.slow++
+	}
+}
+
+// reset clears every bucket
+func (w *window) reset() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for i := range w.buckets {
+		w.buckets[i] = bucket{}
+	}
+}
+
+// totals sums the buckets that still fall inside the window ending at now
+func (w *window) totals(now time.Time) (calls, failures, slow int64) {
+	cutoff := now.Unix() - int64(len(w.buckets))
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, b := range w.buckets {
+		if b.second > cutoff {
+			calls += b.calls
+			failures += b.failures
+			slow += b.slow
+		}
+	}
+	return calls, failures, slow
+}
+
+// exceeds reports whether either rate threshold in s is reached at now
+func (w *window) exceeds(s Settings, now time.Time) bool {
+	if s.FailureRateThreshold <= 0 && s.SlowCallRateThreshold <= 0 {
+		return false
+	}
+
+	calls, failures, slow := w.totals(now)
+	if calls == 0 || calls < int64(s.MinimumCalls) {
+		return false
+	}
+	if s.FailureRateThreshold > 0 && percent(failures, calls) >= s.FailureRateThreshold {
+		return true
+	}
+	if s.SlowCallRateThreshold > 0 && percent(slow, calls) >= s.SlowCallRateThreshold {
+		return true
+	}
+	return false
+}
+
+func (w *window) stats(now time.Time) map[string]interface{} {
+	calls, failures, slow := w.totals(now)
+	return map[string]interface{}{
+		"seconds":        len(w.buckets),
+		"calls":          calls,
+		"failure_rate":   percent(failures, calls),
+		"slow_call_rate": percent(slow, calls),
+	}
+}
+
+func percent(n, total int64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(n) * 100 / float64(total)
+}
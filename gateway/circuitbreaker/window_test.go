@@ -0,0 +1,83 @@
+package circuitbreaker
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWindowExceeds(t *testing.T) {
+	now := time.Unix(1_000_000, 0)
+	// ok, failed and slow calls recorded ago before now
+	type calls struct {
+		ago                time.Duration
+		ok, failures, slow int
+	}
+	rates := Settings{FailureRateThreshold: 50, SlowCallRateThreshold: 50, WindowSeconds: 10, MinimumCalls: 10}
+
+	tests := []struct {
+		name     string
+		settings Settings
+		calls    []calls
+		want     bool
+	}{
+		{"healthy", rates, []calls{{0, 10, 0, 0}}, false},
+		{"failure rate reached", rates, []calls{{0, 5, 5, 0}}, true},
+		{"failure rate below", rates, []calls{{0, 6, 4, 0}}, false},
+		{"slow-call rate reached", rates, []calls{{0, 5, 0, 5}}, true},
+		{"slow failures count once each", rates, []calls{{0, 6, 0, 0}, {0, 0, 4, 4}}, false},
+		{"under minimum calls", rates, []calls{{0, 0, 9, 0}}, false},
+		{"spread over the window", rates, []calls{{9 * time.Second, 0, 5, 0}, {0, 5, 0, 0}}, true},
+		{"older calls dropped", rates, []calls{{10 * time.Second, 0, 10, 0}, {0, 10, 0, 0}}, false},
+		{"bucket reused", Settings{FailureRateThreshold: 50, WindowSeconds: 2}, []calls{{2 * time.Second, 0, 5, 0}, {0, 5, 0, 0}}, false},
+		{"failure rate only", Settings{FailureRateThreshold: 50, WindowSeconds: 10}, []calls{{0, 1, 0, 9}}, false},
+		{"disabled", Settings{WindowSeconds: 10}, []calls{{0, 0, 10, 10}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newWindow(tt.settings.WindowSeconds)
+			for _, c := range tt.calls {
+				at := now.Add(-c.ago)
+				for i := 0; i < c.ok; i++ {
+					w.record(Outcome{}, at)
+				}
+				for i := 0; i < c.failures; i++ {
+					w.record(Outcome{Failure: true, Slow: i < c.slow}, at)
+				}
+				for i := c.failures; i < c.slow; i++ {
+					w.record(Outcome{Slow: true}, at)
+				}
+			}
+			if got := w.exceeds(tt.settings, now); got != tt.want {
+				calls, failures, slow := w.totals(now)
+				t.Errorf("exceeds() = %v with %d calls, %d failures, %d slow", got, calls, failures, slow)
+			}
+		})
+	}
+}
+
+func TestBreakerTripsOnRate(t *testing.T) {
+	b, advance := testBreaker(Settings{
+		FailureThreshold:     100,
+		SuccessThreshold:     1,
+		TimeoutSeconds:       10,
+		FailureRateThreshold: 50,
+		WindowSeconds:        10,
+		MinimumCalls:         4,
+	})
+	var reason string
+	b.Subscribe(func(e Event) { reason = e.Reason })
+
+	for _, failure := range []bool{false, true, false} {
+		done, _ := b.Allow()
+		done(Outcome{Failure: failure})
+		advance(time.Second)
+	}
+	if b.GetState() != StateClosed {
+		t.Fatal("tripped under minimum calls")
+	}
+	done, _ := b.Allow()
+	done(Outcome{Failure: true})
+	if b.GetState() != StateOpen || reason != "failure or slow-call rate threshold reached" {
+		t.Errorf("state %s (%q), want open on the failure rate", b.GetState(), reason)
+	}
+}
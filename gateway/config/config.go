@@ -0,0 +1,995 @@
+package config
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gateway/apikey"
+	"gateway/balancer"
+	"gateway/certs"
+	"gateway/jwt"
+	"gateway/ratelimit"
+	"gateway/rewrite"
+	"gateway/router"
+)
+
+// Config is the gateway configuration. It mirrors the control-plane's
+// GatewayConfig model field for field.
+type Config struct {
+	ListenAddr     string            `json:"listen_addr"`
+	MetricsAddr    string            `json:"metrics_addr"`
+	Routes         []RouteConfig     `json:"routes"`
+	Upstreams      []UpstreamConfig  `json:"upstreams,omitempty"`
+	RateLimit      RateLimitConfig   `json:"rate_limit"`
+	CircuitBreaker CircuitConfig     `json:"circuit_breaker"`
+	Cache          CacheConfig       `json:"cache"`
+	ConnectionPool PoolConfig        `json:"connection_pool"`
+	Timeouts       TimeoutConfig     `json:"timeouts"`
+	LoadShedding   LoadShedConfig    `json:"load_shedding"`
+	HealthChecks   HealthCheckConfig `json:"health_checks"`
+	RetryBudget    RetryBudgetConfig `json:"retry_budget"`
+	HedgeBudget    HedgeBudgetConfig `json:"hedge_budget"`
+	HTTP2          HTTP2Config       `json:"http2"`
+	TLS            TLSConfig         `json:"tls"`
+	JWT            JWTConfig         `json:"jwt"`
+	APIKeys        APIKeysConfig     `json:"api_keys"`
+
+	// AccessLog logs one line per request, naming the client by the
+	// identity it was rate limited by
+	AccessLog bool `json:"access_log"`
+
+	// DebugRoutes serves /debug/routes, which reveals every route and
+	// upstream; otherwise the path is proxied like any other
+	DebugRoutes bool `json:"debug_routes,omitempty"`
+}
+
+// RouteConfig describes a single proxied route
+type RouteConfig struct {
+	Path        string   `json:"path"`
+	Backend     string   `json:"backend,omitempty"`
+	Upstream    string   `json:"upstream,omitempty"` // name of an upstreams entry, instead of backend
+	Methods     []string `json:"methods"`
+	RateLimit   int      `json:"rate_limit_per_minute"`
+	Timeout     int      `json:"timeout_seconds"`
+	EnableCache bool     `json:"enable_cache"`
+	HealthCheck bool     `json:"health_check"` // actively probe this route's backend or upstream
+
+	// CacheAuthenticated lets requests carrying credentials use the cache.
+	// Their responses are still only stored when marked public, s-maxage
+	// or must-revalidate.
+	CacheAuthenticated bool `json:"cache_authenticated,omitempty"`
+
+	// FlushIntervalMs batches flushes of streamed response bodies; zero
+	// flushes every chunk as soon as it arrives
+	FlushIntervalMs int `json:"flush_interval_ms,omitempty"`
+
+	// Predicates further restrict which requests match the route; all of
+	// them must hold
+	Predicates []PredicateConfig `json:"predicates,omitempty"`
+
+	// Rewrite maps the public path onto the backend's own URL layout
+	Rewrite *RewriteConfig `json:"rewrite,omitempty"`
+
+	// CircuitBreaker overrides the global breaker thresholds for this route
+	CircuitBreaker *CircuitOverride `json:"circuit_breaker,omitempty"`
+
+	// Retry re-sends failed upstream attempts; nil means a single attempt
+	Retry *RetryConfig `json:"retry,omitempty"`
+
+	// Hedge sends GET and HEAD requests to a second target when the first
+	// is slow to answer
+	Hedge *HedgeConfig `json:"hedge,omitempty"`
+
+	// Upgrade allows protocol upgrades such as WebSocket; without it the
+	// Upgrade header is not forwarded
+	Upgrade *UpgradeConfig `json:"upgrade,omitempty"`
+
+	// JWT requires a valid bearer token, checked against the jwt section,
+	// with these scopes and claims
+	JWT *RouteJWTConfig `json:"jwt,omitempty"`
+
+	// APIKey requires a key from the api_keys store that allows this route
+	APIKey bool `json:"api_key,omitempty"`
+
+	// RateLimitKey keys the route's rate limiting by these parts of the
+	// request, combined, instead of by client identity
+	RateLimitKey []RateLimitKeyConfig `json:"rate_limit_key,omitempty"`
+
+	// RateLimitKeyMissing is what happens to requests lacking a part of
+	// RateLimitKey, such as a header or claim: RateLimitKeyClient (the
+	// default) limits them by client identity, RateLimitKeyReject answers
+	// them with 400
+	RateLimitKeyMissing string `json:"rate_limit_key_missing,omitempty"`
+}
+
+// RouteJWTConfig is what a route requires of a token. Claims maps claim
+// paths such as "role" or "realm_access.roles" to accepted values.
+type RouteJWTConfig struct {
+	Scopes []string            `json:"scopes,omitempty"`
+	Claims map[string][]string `json:"claims,omitempty"`
+}
+
+// Compile builds the route's token requirement
+func (r *RouteJWTConfig) Compile() *jwt.Requirement {
+	return &jwt.Requirement{Scopes: r.Scopes, Claims: r.Claims}
+}
+
+// UpgradeConfig limits upgraded connections on a route. A tunnel is closed
+// after IdleTimeoutSeconds without traffic in either direction, or after
+// MaxLifetimeSeconds in total (zero means no limit).
+type UpgradeConfig struct {
+	IdleTimeoutSeconds int `json:"idle_timeout_seconds"`
+	MaxLifetimeSeconds int `json:"max_lifetime_seconds"`
+	MaxConnections     int `json:"max_connections"`
+}
+
+// HedgeConfig configures request hedging. The delay is DelayMs when set,
+// otherwise the route's observed latency at Percentile, but at least
+// MinDelayMs.
+type HedgeConfig struct {
+	DelayMs    int     `json:"delay_ms,omitempty"`
+	Percentile float64 `json:"percentile"`
+	MinDelayMs int     `json:"min_delay_ms"`
+	MaxHedges  int     `json:"max_hedges"` // extra attempts per request
+}
+
+// Retry conditions
+const (
+	RetryOnConnectError = "connect_error"
+	RetryOnReset        = "reset"
+)
+
+// RetryConfig is a route's retry policy. Only idempotent methods are
+// retried unless RetryNonIdempotent is set.
+type RetryConfig struct {
+	MaxAttempts        int      `json:"max_attempts"` // including the first
+	RetryOn            []string `json:"retry_on"`     // connect_error, reset
+	StatusCodes        []int    `json:"status_codes,omitempty"`
+	BaseBackoffMs      int      `json:"base_backoff_ms"`
+	MaxBackoffMs       int      `json:"max_backoff_ms"`
+	RetryNonIdempotent bool     `json:"retry_non_idempotent"`
+	MaxBodyBytes       int64    `json:"max_body_bytes"` // larger request bodies are sent once
+}
+
+// RewriteConfig holds per-route path rewrite rules. See rewrite.Rules.
+type RewriteConfig struct {
+	StripPrefix string `json:"strip_prefix,omitempty"`
+	AddPrefix   string `json:"add_prefix,omitempty"`
+	Regex       string `json:"regex,omitempty"`
+	Replacement string `json:"replacement,omitempty"`
+	Template    string `json:"template,omitempty"`
+}
+
+// Compile builds the rewriter for these rules
+func (c *RewriteConfig) Compile() (*rewrite.Rewriter, error) {
+	return rewrite.New(rewrite.Rules{
+		StripPrefix: c.StripPrefix,
+		AddPrefix:   c.AddPrefix,
+		Regex:       c.Regex,
+		Replacement: c.Replacement,
+		Template:    c.Template,
+	})
+}
+
+// PredicateConfig is a routing condition on the Host header, a request
+// header, a query parameter or the client IP. See router.NewPredicate.
+type PredicateConfig struct {
+	Type   string   `json:"type"`
+	Name   string   `json:"name,omitempty"`
+	Value  string   `json:"value,omitempty"`
+	Values []string `json:"values,omitempty"`
+}
+
+// Compile builds the router predicate. Value is shorthand for a single
+// entry in Values.
+func (p PredicateConfig) Compile() (router.Predicate, error) {
+	values := p.Values
+	if p.Value != "" {
+		values = append([]string{p.Value}, values...)
+	}
+	return router.NewPredicate(p.Type, p.Name, values)
+}
+
+// CompilePredicates builds every predicate of the route
+func (r *RouteConfig) CompilePredicates() ([]router.Predicate, error) {
+	preds := make([]router.Predicate, 0, len(r.Predicates))
+	for i, pc := range r.Predicates {
+		pred, err := pc.Compile()
+		if err != nil {
+			return nil, fmt.Errorf("predicates[%d]: %w", i, err)
+		}
+		preds = append(preds, pred)
+	}
+	return preds, nil
+}
+
+// UpstreamConfig is a named pool of backend targets and the strategy used
+// to balance requests across them
+type UpstreamConfig struct {
+	Name     string         `json:"name"`
+	Targets  []TargetConfig `json:"targets"`
+	Strategy string         `json:"strategy,omitempty"` // see balancer strategy names, default round_robin
+	HashKey  *HashKeyConfig `json:"hash_key,omitempty"` // required for consistent_hash
+
+	// HealthCheck enables active probes of every target and overrides the
+	// global health_checks settings; zero fields inherit them
+	HealthCheck *HealthCheckConfig `json:"health_check,omitempty"`
+
+	// Protocol spoken to the targets; see the Protocol constants
+	Protocol string `json:"protocol,omitempty"`
+
+	// TLS overrides how https targets are verified and can present a
+	// client certificate for mutual TLS
+	TLS *UpstreamTLSConfig `json:"tls,omitempty"`
+}
+
+// UpstreamTLSConfig configures TLS to an upstream's targets. Without
+// CAFile, the system roots are trusted.
+type UpstreamTLSConfig struct {
+	CAFile             string `json:"ca_file,omitempty"`
+	CertFile           string `json:"cert_file,omitempty"` // client certificate for mutual TLS
+	KeyFile            string `json:"key_file,omitempty"`
+	ServerName         string `json:"server_name,omitempty"` // overrides the target host for SNI and verification
+	InsecureSkipVerify bool   `json:"insecure_skip_verify,omitempty"`
+}
+
+// Compile loads the files and builds the TLS client config
+func (t *UpstreamTLSConfig) Compile() (*tls.Config, error) {
+	return certs.ClientConfig(certs.ClientOptions{
+		CAFile:             t.CAFile,
+		CertFile:           t.CertFile,
+		KeyFile:            t.KeyFile,
+		ServerName:         t.ServerName,
+		InsecureSkipVerify: t.InsecureSkipVerify,
+	})
+}
+
+// Upstream protocols
+const (
+	ProtocolHTTP1 = "http1" // HTTP/1.1, or HTTP/2 if negotiated over TLS (default)
+	ProtocolH2C   = "h2c"   // cleartext HTTP/2 with prior knowledge, for http targets
+	ProtocolH2    = "h2"    // HTTP/2 over TLS only, for https targets
+)
+
+// TargetConfig is one server in an upstream pool
+type TargetConfig struct {
+	URL    string `json:"url"`
+	Weight int    `json:"weight,omitempty"` // default 1
+}
+
+// HashKeyConfig selects the consistent-hashing key: a header, cookie or
+// path parameter by name, or the client IP
+type HashKeyConfig struct {
+	Source string `json:"source"`
+	Name   string `json:"name,omitempty"`
+}
+
+// Compile builds the balanced pool for this upstream
+func (u *UpstreamConfig) Compile() (*balancer.Pool, error) {
+	targets := make([]*balancer.Target, 0, len(u.Targets))
+	for _, tc := range u.Targets {
+		t, err := balancer.NewTarget(tc.URL, tc.Weight)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, t)
+	}
+
+	var key balancer.KeyFunc
+	if u.HashKey != nil {
+		var err error
+		if key, err = balancer.NewKeyFunc(u.HashKey.Source, u.HashKey.Name); err != nil {
+			return nil, err
+		}
+	}
+
+	b, err := balancer.New(u.Strategy, targets, key)
+	if err != nil {
+		return nil, err
+	}
+	protocol := u.Protocol
+	if protocol == "" {
+		protocol = ProtocolHTTP1
+	}
+	pool := &balancer.Pool{Name: u.Name, Protocol: protocol, Targets: targets, Balancer: b}
+	if u.TLS != nil {
+		if pool.TLS, err = u.TLS.Compile(); err != nil {
+			return nil, err
+		}
+	}
+	return pool, nil
+}
+
+// RateLimitConfig configures the sharded token bucket limiter
+type RateLimitConfig struct {
+	Enabled     bool `json:"enabled"`
+	BurstSize   int  `json:"burst_size"`
+	DefaultRate int  `json:"default_rate_per_minute"`
+	NumShards   int  `json:"num_shards"`
+
+	// TrustedProxies are the CIDR ranges whose X-Forwarded-For entries
+	// are believed by "forwarded" key parts
+	TrustedProxies []string `json:"trusted_proxies,omitempty"`
+}
+
+// CompileTrustedProxies parses the trusted proxy ranges
+func (c *RateLimitConfig) CompileTrustedProxies() ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(c.TrustedProxies))
+	for _, cidr := range c.TrustedProxies {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q", cidr)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// Handling of requests missing a rate limit key part
+const (
+	RateLimitKeyClient = "client"
+	RateLimitKeyReject = "reject"
+)
+
+// RateLimitKeyConfig is one part of a route's rate limit key. See
+// ratelimit.NewKeyPart.
+type RateLimitKeyConfig struct {
+	Type string `json:"type"`
+	Name string `json:"name,omitempty"`
+}
+
+// CompileRateLimitKey builds the route's rate limit key function, or nil
+// when the route uses the default key
+func (r *RouteConfig) CompileRateLimitKey() (*ratelimit.KeyFunc, error) {
+	if len(r.RateLimitKey) == 0 {
+		return nil, nil
+	}
+	parts := make([]ratelimit.KeyPart, 0, len(r.RateLimitKey))
+	for i, kc := range r.RateLimitKey {
+		part, err := ratelimit.NewKeyPart(kc.Type, kc.Name)
+		if err != nil {
+			return nil, fmt.Errorf("rate_limit_key[%d]: %w", i, err)
+		}
+		parts = append(parts, part)
+	}
+	return ratelimit.NewKeyFunc(r.Path, parts), nil
+}
+
+// CircuitConfig configures the backend circuit breaker
+type CircuitConfig struct {
+	Enabled          bool    `json:"enabled"`
+	FailureThreshold int     `json:"failure_threshold"`
+	SuccessThreshold int     `json:"success_threshold"`
+	TimeoutSeconds   int     `json:"timeout_seconds"`
+	HealthDecay      float64 `json:"health_decay"`
+	Scope            string  `json:"scope"`        // "target" (default) or "route"
+	MaxBreakers      int     `json:"max_breakers"` // bound on tracked breakers
+
+	// Failure classification: upstream responses with these status codes
+	// count as failures, and calls slower than SlowCallThresholdMs as slow
+	FailureStatusCodes  []int `json:"failure_status_codes"`
+	SlowCallThresholdMs int   `json:"slow_call_threshold_ms"`
+
+	// gRPC calls answer HTTP 200 and report their status in trailers;
+	// calls ending with these gRPC status codes count as failures
+	FailureGRPCCodes []int `json:"failure_grpc_codes"`
+
+	// Rate thresholds in percent over a sliding window, alongside the
+	// consecutive failure threshold. Zero disables a threshold.
+	FailureRateThreshold  float64 `json:"failure_rate_threshold"`
+	SlowCallRateThreshold float64 `json:"slow_call_rate_threshold"`
+	WindowSeconds         int     `json:"window_seconds"`
+	MinimumCalls          int     `json:"minimum_calls"`
+
+	// Half-open admits at most HalfOpenMaxCalls concurrent probes. Every
+	// re-opening multiplies the open timeout by BackoffMultiplier, capped
+	// at MaxTimeoutSeconds.
+	HalfOpenMaxCalls  int     `json:"half_open_max_calls"`
+	BackoffMultiplier float64 `json:"backoff_multiplier"`
+	MaxTimeoutSeconds int     `json:"max_timeout_seconds"`
+}
+
+// Breaker scopes
+const (
+	BreakerScopeTarget = "target"
+	BreakerScopeRoute  = "route"
+)
+
+// CircuitOverride replaces the global breaker thresholds for one route.
+// Zero fields inherit the global value.
+type CircuitOverride struct {
+	FailureThreshold int `json:"failure_threshold,omitempty"`
+	SuccessThreshold int `json:"success_threshold,omitempty"`
+	TimeoutSeconds   int `json:"timeout_seconds,omitempty"`
+}
+
+// CacheConfig configures the response cache
+type CacheConfig struct {
+	Enabled    bool `json:"enabled"`
+	MaxSize    int  `json:"max_size_mb"`
+	MaxEntries int  `json:"max_entries"`
+	TTLSeconds int  `json:"ttl_seconds"`
+
+	// MaxObjectBytes is the largest response buffered for caching or
+	// shared between coalesced requests; larger ones are streamed
+	MaxObjectBytes int64 `json:"max_object_bytes"`
+
+	// GraceSeconds keeps expired responses so they can be revalidated
+	// with a conditional request instead of fetched again
+	GraceSeconds int `json:"grace_seconds"`
+
+	// Stale windows for responses without stale-while-revalidate or
+	// stale-if-error directives of their own
+	StaleWhileRevalidateSeconds int `json:"stale_while_revalidate_seconds"`
+	StaleIfErrorSeconds         int `json:"stale_if_error_seconds"`
+
+	// PurgeEnabled serves /admin/cache/purge, which requires PurgeToken as
+	// a bearer token; otherwise the path is proxied like any other
+	PurgeEnabled bool   `json:"purge_enabled,omitempty"`
+	PurgeToken   string `json:"purge_token,omitempty"`
+}
+
+// PoolConfig configures the upstream connection pool
+type PoolConfig struct {
+	MaxConnections int `json:"max_connections"`
+	MaxIdle        int `json:"max_idle"`
+	IdleTimeout    int `json:"idle_timeout_seconds"`
+}
+
+// TimeoutConfig holds server and upstream timeouts in seconds
+type TimeoutConfig struct {
+	ConnectSeconds int `json:"connect"`
+	ReadSeconds    int `json:"read"`
+	WriteSeconds   int `json:"write"`
+	TotalSeconds   int `json:"total"`
+}
+
+// LoadShedConfig configures load shedding
+type LoadShedConfig struct {
+	Enabled         bool `json:"enabled"`
+	MaxQueueDepth   int  `json:"max_queue_depth"`
+	CPUPercentLimit int  `json:"cpu_percent_limit"`
+}
+
+// RetryBudgetConfig caps retries across all routes at Percent of the
+// requests seen over the last WindowSeconds, with a floor of MinPerSecond
+type RetryBudgetConfig struct {
+	Percent       float64 `json:"percent"`
+	MinPerSecond  int     `json:"min_retries_per_second"`
+	WindowSeconds int     `json:"window_seconds"`
+}
+
+// HedgeBudgetConfig caps hedged requests across all routes at Percent of
+// the requests seen over the last WindowSeconds
+type HedgeBudgetConfig struct {
+	Percent       float64 `json:"percent"`
+	WindowSeconds int     `json:"window_seconds"`
+}
+
+// HTTP2Config configures HTTP/2 on the listener. With H2C, clients may
+// speak cleartext HTTP/2, either with prior knowledge or by upgrading;
+// HTTP/2 over TLS is always negotiated when TLS is enabled.
+type HTTP2Config struct {
+	H2C                  bool   `json:"h2c"`
+	MaxConcurrentStreams uint32 `json:"max_concurrent_streams"`
+}
+
+// TLSConfig configures the HTTPS listener, which runs alongside the plain
+// HTTP listener on listen_addr. Certificates are chosen by SNI; the first
+// one is served to clients asking for an unknown name or none.
+type TLSConfig struct {
+	Enabled      bool                `json:"enabled"`
+	ListenAddr   string              `json:"listen_addr"`
+	Certificates []CertificateConfig `json:"certificates"`
+	MinVersion   string              `json:"min_version"`             // "1.2" or "1.3"
+	CipherSuites []string            `json:"cipher_suites,omitempty"` // TLS 1.2 suites by name, default Go's secure set
+
+	// RedirectHosts are answered on the HTTP listener with a redirect to
+	// HTTPS. "*.example.com" matches subdomains and "*" every host.
+	RedirectHosts []string `json:"redirect_hosts,omitempty"`
+
+	// ClientAuth verifies client certificates on the HTTPS listener
+	ClientAuth *ClientAuthConfig `json:"client_auth,omitempty"`
+}
+
+// ClientAuthConfig verifies client certificates against the CAs in CAFile.
+// The identity of a verified certificate is forwarded to backends in
+// IdentityHeader, which is stripped from every other request, and can key
+// rate limiting.
+type ClientAuthConfig struct {
+	Mode                string `json:"mode"` // "require" or "optional"
+	CAFile              string `json:"ca_file"`
+	Identity            string `json:"identity"` // "subject" or "san"
+	IdentityHeader      string `json:"identity_header"`
+	RateLimitByIdentity bool   `json:"rate_limit_by_identity"`
+}
+
+// CertificateConfig is a certificate and key served for Hosts, or for the
+// DNS names in the certificate when Hosts is empty
+type CertificateConfig struct {
+	CertFile string   `json:"cert_file"`
+	KeyFile  string   `json:"key_file"`
+	Hosts    []string `json:"hosts,omitempty"`
+}
+
+// Compile converts the TLS config into certificate pairs and a policy
+func (t *TLSConfig) Compile() ([]certs.Pair, certs.Policy, error) {
+	var policy certs.Policy
+	var err error
+	if policy.MinVersion, err = certs.ParseVersion(t.MinVersion); err != nil {
+		return nil, policy, err
+	}
+	for _, name := range t.CipherSuites {
+		id, err := certs.ParseCipherSuite(name)
+		if err != nil {
+			return nil, policy, err
+		}
+		policy.CipherSuites = append(policy.CipherSuites, id)
+	}
+
+	if ca := t.ClientAuth; ca != nil {
+		if policy.ClientAuth, err = certs.ParseClientAuth(ca.Mode); err != nil {
+			return nil, policy, err
+		}
+		if policy.ClientCAs, err = certs.LoadCAs(ca.CAFile); err != nil {
+			return nil, policy, err
+		}
+	}
+
+	pairs := make([]certs.Pair, len(t.Certificates))
+	for i, c := range t.Certificates {
+		pairs[i] = certs.Pair{CertFile: c.CertFile, KeyFile: c.KeyFile, Hosts: c.Hosts}
+	}
+	return pairs, policy, nil
+}
+
+// Redirects reports whether HTTP requests for host are redirected to HTTPS
+func (t *TLSConfig) Redirects(host string) bool {
+	if !t.Enabled {
+		return false
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(host)
+
+	for _, want := range t.RedirectHosts {
+		switch {
+		case want == "*":
+			return true
+		case strings.HasPrefix(want, "*."):
+			if strings.HasSuffix(host, want[1:]) && len(host) > len(want)-1 {
+				return true
+			}
+		case strings.EqualFold(host, want):
+			return true
+		}
+	}
+	return false
+}
+
+// JWTConfig configures how bearer tokens are validated on routes with a
+// jwt block. Keys come from Keys, a JWKS endpoint, or both.
+type JWTConfig struct {
+	Issuer             string         `json:"issuer,omitempty"`
+	Audiences          []string       `json:"audiences,omitempty"`
+	LeewaySeconds      int            `json:"leeway_seconds"`
+	Keys               []JWTKeyConfig `json:"keys,omitempty"`
+	JWKSURL            string         `json:"jwks_url,omitempty"`
+	JWKSRefreshSeconds int            `json:"jwks_refresh_seconds"`
+
+	// ForwardClaims maps claim paths to the headers they are forwarded to
+	// backends in. The headers are removed from every other request.
+	ForwardClaims map[string]string `json:"forward_claims,omitempty"`
+
+	// RateLimitClaim keys rate limiting of authenticated requests by
+	// this claim, e.g. "sub", instead of the client address
+	RateLimitClaim string `json:"rate_limit_claim,omitempty"`
+}
+
+// JWTKeyConfig is a static verification key: a shared secret for HS256 or
+// a PEM public key or certificate for RS256 and ES256
+type JWTKeyConfig struct {
+	ID            string `json:"kid,omitempty"` // empty matches any key ID
+	Alg           string `json:"alg"`
+	Secret        string `json:"secret,omitempty"`
+	PublicKeyFile string `json:"public_key_file,omitempty"`
+}
+
+// Enabled reports whether any keys are configured
+func (j *JWTConfig) Enabled() bool {
+	return len(j.Keys) > 0 || j.JWKSURL != ""
+}
+
+// Compile loads the keys and builds the validator
+func (j *JWTConfig) Compile() (*jwt.Validator, error) {
+	keys := make([]*jwt.Key, 0, len(j.Keys))
+	for i, kc := range j.Keys {
+		key, err := kc.Compile()
+		if err != nil {
+			return nil, fmt.Errorf("keys[%d]: %w", i, err)
+		}
+		keys = append(keys, key)
+	}
+	return jwt.NewValidator(jwt.Options{
+		Issuer:      j.Issuer,
+		Audiences:   j.Audiences,
+		Leeway:      time.Duration(j.LeewaySeconds) * time.Second,
+		Keys:        keys,
+		JWKSURL:     j.JWKSURL,
+		JWKSRefresh: time.Duration(j.JWKSRefreshSeconds) * time.Second,
+	}), nil
+}
+
+// Compile loads the key
+func (k *JWTKeyConfig) Compile() (*jwt.Key, error) {
+	switch k.Alg {
+	case jwt.HS256:
+		if k.Secret == "" || k.PublicKeyFile != "" {
+			return nil, fmt.Errorf("%s keys need a secret and no public_key_file", k.Alg)
+		}
+		return jwt.NewHMACKey(k.ID, []byte(k.Secret)), nil
+	case jwt.RS256, jwt.ES256:
+		if k.PublicKeyFile == "" || k.Secret != "" {
+			return nil, fmt.Errorf("%s keys need a public_key_file and no secret", k.Alg)
+		}
+		data, err := os.ReadFile(k.PublicKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		key, err := jwt.ParsePublicKey(k.ID, k.Alg, data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", k.PublicKeyFile, err)
+		}
+		return key, nil
+	default:
+		return nil, fmt.Errorf("unsupported alg %q, must be %s, %s or %s", k.Alg, jwt.RS256, jwt.ES256, jwt.HS256)
+	}
+}
+
+// APIKeysConfig configures API key authentication on routes with api_key
+// set. Keys are read from a request header, or from a query parameter when
+// QueryParam is set, and looked up by hash in the store loaded from File.
+type APIKeysConfig struct {
+	File       string `json:"file,omitempty"`
+	Header     string `json:"header"`
+	QueryParam string `json:"query_param,omitempty"`
+
+	store *apikey.Store // loaded by Parse once the config is valid
+}
+
+// Enabled reports whether a key file is configured
+func (a *APIKeysConfig) Enabled() bool {
+	return a.File != ""
+}
+
+// Store returns the keys loaded from File, or nil when disabled
+func (a *APIKeysConfig) Store() *apikey.Store {
+	return a.store
+}
+
+// HealthCheckConfig configures active upstream health checks. A target is
+// taken out of rotation after UnhealthyThreshold consecutive failed probes
+// and put back after HealthyThreshold consecutive passing ones.
+type HealthCheckConfig struct {
+	Path               string `json:"path,omitempty"`
+	IntervalSeconds    int    `json:"interval_seconds,omitempty"`
+	TimeoutSeconds     int    `json:"timeout_seconds,omitempty"`
+	ExpectedStatusMin  int    `json:"expected_status_min,omitempty"`
+	ExpectedStatusMax  int    `json:"expected_status_max,omitempty"`
+	ExpectedBody       string `json:"expected_body,omitempty"` // substring the body must contain
+	HealthyThreshold   int    `json:"healthy_threshold,omitempty"`
+	UnhealthyThreshold int    `json:"unhealthy_threshold,omitempty"`
+}
+
+// Default returns a configuration populated with the control-plane defaults
+func Default() *Config {
+	return &Config{
+		ListenAddr:  ":8080",
+		MetricsAddr: ":9090",
+		RateLimit: RateLimitConfig{
+			Enabled:     true,
+			BurstSize:   10,
+			DefaultRate: 1000,
+			NumShards:   16,
+		},
+		CircuitBreaker: CircuitConfig{
+			Enabled:          true,
+			FailureThreshold: 5,
+			SuccessThreshold: 3,
+			TimeoutSeconds:   60,
+			HealthDecay:      0.95,
+			Scope:            BreakerScopeTarget,
+			MaxBreakers:      1000,
+
+			FailureStatusCodes: []int{502, 503, 504},
+			FailureGRPCCodes:   []int{4, 13, 14}, // DEADLINE_EXCEEDED, INTERNAL, UNAVAILABLE
+			WindowSeconds:      60,
+			MinimumCalls:       20,
+
+			HalfOpenMaxCalls:  1,
+			BackoffMultiplier: 2,
+			MaxTimeoutSeconds: 600,
+		},
+		Cache: CacheConfig{
+			Enabled:        true,
+			MaxSize:        100,
+			MaxEntries:     1000,
+			TTLSeconds:     300,
+			MaxObjectBytes: 1 << 20,
+			GraceSeconds:   600,
+		},
+		ConnectionPool: PoolConfig{
+			MaxConnections: 1000,
+			MaxIdle:        100,
+			IdleTimeout:    60,
+		},
+		Timeouts: TimeoutConfig{
+			ConnectSeconds: 2,
+			ReadSeconds:    30,
+			WriteSeconds:   10,
+			TotalSeconds:   60,
+		},
+		LoadShedding: LoadShedConfig{
+			Enabled:         true,
+			MaxQueueDepth:   1000,
+			CPUPercentLimit: 90,
+		},
+		HealthChecks: HealthCheckConfig{
+			Path:               "/health",
+			IntervalSeconds:    10,
+			TimeoutSeconds:     2,
+			ExpectedStatusMin:  200,
+			ExpectedStatusMax:  399,
+			HealthyThreshold:   2,
+			UnhealthyThreshold: 3,
+		},
+		RetryBudget: RetryBudgetConfig{
+			Percent:       20,
+			MinPerSecond:  10,
+			WindowSeconds: 10,
+		},
+		HedgeBudget: HedgeBudgetConfig{
+			Percent:       10,
+			WindowSeconds: 10,
+		},
+		HTTP2: HTTP2Config{
+			H2C:                  true,
+			MaxConcurrentStreams: 250,
+		},
+		TLS: TLSConfig{
+			ListenAddr: ":8443",
+			MinVersion: "1.2",
+		},
+		JWT: JWTConfig{
+			LeewaySeconds:      60,
+			JWKSRefreshSeconds: 300,
+		},
+		APIKeys: APIKeysConfig{
+			Header: "X-API-Key",
+		},
+	}
+}
+
+// UnmarshalJSON applies route defaults before decoding so omitted fields
+// behave like the control-plane model
+func (r *RouteConfig) UnmarshalJSON(data []byte) error {
+	type plain RouteConfig
+	route := plain{
+		RateLimit: 100,
+		Timeout:   30,
+	}
+	err := json.Unmarshal(data, &route)
+	*r = RouteConfig(route)
+	return err
+}
+
+// UnmarshalJSON applies retry defaults so "retry": {} enables three
+// attempts on connection failures
+func (r *RetryConfig) UnmarshalJSON(data []byte) error {
+	type plain RetryConfig
+	retry := plain{
+		MaxAttempts:   3,
+		RetryOn:       []string{RetryOnConnectError, RetryOnReset},
+		BaseBackoffMs: 25,
+		MaxBackoffMs:  250,
+		MaxBodyBytes:  1 << 20,
+	}
+	err := json.Unmarshal(data, &retry)
+	*r = RetryConfig(retry)
+	return err
+}
+
+// UnmarshalJSON applies hedge defaults so "hedge": {} hedges once at the
+// route's p95 latency
+func (h *HedgeConfig) UnmarshalJSON(data []byte) error {
+	type plain HedgeConfig
+	hedge := plain{
+		Percentile: 95,
+		MinDelayMs: 5,
+		MaxHedges:  1,
+	}
+	err := json.Unmarshal(data, &hedge)
+	*h = HedgeConfig(hedge)
+	return err
+}
+
+// UnmarshalJSON applies upgrade defaults so "upgrade": {} allows
+// WebSockets with sensible limits
+func (u *UpgradeConfig) UnmarshalJSON(data []byte) error {
+	type plain UpgradeConfig
+	upgrade := plain{
+		IdleTimeoutSeconds: 300,
+		MaxLifetimeSeconds: 3600,
+		MaxConnections:     1000,
+	}
+	err := json.Unmarshal(data, &upgrade)
+	*u = UpgradeConfig(upgrade)
+	return err
+}
+
+// UnmarshalJSON applies client auth defaults so only ca_file is needed to
+// require client certificates
+func (c *ClientAuthConfig) UnmarshalJSON(data []byte) error {
+	type plain ClientAuthConfig
+	auth := plain{
+		Mode:           certs.ClientAuthRequire,
+		Identity:       certs.IdentitySubject,
+		IdentityHeader: "X-Client-Cert-Identity",
+	}
+	err := json.Unmarshal(data, &auth)
+	*c = ClientAuthConfig(auth)
+	return err
+}
+
+// snapshot pairs a configuration with its version so readers never see
+// one without the other
+type snapshot struct {
+	cfg     *Config
+	version int64
+}
+
+var (
+	current        atomic.Pointer[snapshot]
+	setMu          sync.Mutex
+	reloads        atomic.Int64
+	reloadFailures atomic.Int64
+
+	subscribersMu sync.RWMutex
+	subscribers   []func(cfg *Config, version int64)
+)
+
+// LoadConfig reads, decodes and validates the configuration file at path
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read config: %w", err)
+	}
+	return Parse(data)
+}
+
+// Parse decodes and validates a JSON configuration document. All problems
+// are reported together as ValidationErrors.
+func Parse(data []byte) (*Config, error) {
+	var raw interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("parse config: %w", err)
+	}
+
+	var errs ValidationErrors
+	checkDocument("", raw, configType, &errs)
+
+	// Decoding carries on past values of the wrong type, leaving their
+	// defaults, so the remaining problems are found too. The walk above
+	// has already reported them with their array indices.
+	cfg := Default()
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if err := dec.Decode(cfg); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if !errors.As(err, &typeErr) {
+			return nil, fmt.Errorf("parse config: %w", err)
+		}
+		if len(errs) == 0 {
+			errs.add(typeErr.Field, "expected %s, got %s", typeErr.Type, typeErr.Value)
+		}
+	}
+
+	keys, err := cfg.validate()
+	if err != nil {
+		var verrs ValidationErrors
+		if errors.As(err, &verrs) {
+			// A value that failed to decode is only reported once
+			reported := make(map[string]bool, len(errs))
+			for _, e := range errs {
+				reported[e.Path] = true
+			}
+			for _, e := range verrs {
+				if !reported[e.Path] {
+					errs = append(errs, e)
+				}
+			}
+		} else {
+			return nil, err
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	// Validate itself leaves the config untouched; the keys it loaded are
+	// attached only once the config is accepted
+	cfg.APIKeys.store = keys
+	return cfg, nil
+}
+
+// SetConfig publishes cfg as the active configuration and returns its
+// version. Versions start at 1 and increase by one on every call.
+func SetConfig(cfg *Config) int64 {
+	setMu.Lock()
+	defer setMu.Unlock()
+
+	var next int64 = 1
+	if prev := current.Load(); prev != nil {
+		next = prev.version + 1
+	}
+	current.Store(&snapshot{cfg: cfg, version: next})
+	return next
+}
+
+// Version returns the version of the active configuration, or 0 if none
+// has been set
+func Version() int64 {
+	if s := current.Load(); s != nil {
+		return s.version
+	}
+	return 0
+}
+
+// Current returns the active configuration together with its version
+func Current() (*Config, int64) {
+	if s := current.Load(); s != nil {
+		return s.cfg, s.version
+	}
+	return nil, 0
+}
+
+// OnReload registers fn to be called after the watcher publishes a new
+// configuration. Subscribers run synchronously in registration order.
+func OnReload(fn func(cfg *Config, version int64)) {
+	subscribersMu.Lock()
+	subscribers = append(subscribers, fn)
+	subscribersMu.Unlock()
+}
+
+func notifyReload(cfg *Config, version int64) {
+	subscribersMu.RLock()
+	defer subscribersMu.RUnlock()
+	for _, fn := range subscribers {
+		fn(cfg, version)
+	}
+}
+
+// Stats returns reload statistics
+func Stats() map[string]interface{} {
+	return map[string]interface{}{
+		"version":         Version(),
+		"reloads":         reloads.Load(),
+		"reload_failures": reloadFailures.Load(),
+	}
+}
+
+// GetConfig returns the active configuration, or nil if none is set
+func GetConfig() *Config {
+	cfg, _ := Current()
+	return cfg
+}
@@ -0,0 +1,107 @@
+package config
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseReportsAllProblems(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+		want []ValidationError
+	}{
+		{
+			name: "valid",
+			doc:  `{"routes": [{"path": "/a", "backend": "http://a", "methods": ["GET"]}]}`,
+		},
+		{
+			name: "type error in a later route",
+			doc: `{"routes": [
+				{"path": "/a", "backend": "http://a", "methods": ["GET"]},
+				{"path": "/b", "backend": "http://b", "methods": ["GET"], "timeout_seconds": "30"}
+			]}`,
+			want: []ValidationError{
+				{Path: "routes[1].timeout_seconds", Message: `expected integer, got string "30"`},
+			},
+		},
+		{
+			name: "type, unknown field and validation errors together",
+			doc: `{
+				"listen_adr": ":8080",
+				"routes": [
+					{"path": "/a", "backend": "http://a", "methods": ["GET"], "enable_cache": "yes"},
+					{"path": "/b", "upstream": "missing", "methods": ["GET"]}
+				],
+				"cache": {"max_entries": 1.5}
+			}`,
+			want: []ValidationError{
+				{Path: "cache.max_entries", Message: "expected integer, got number 1.5"},
+				{Path: "listen_adr", Message: "unknown field"},
+				{Path: "routes[0].enable_cache", Message: `expected boolean, got string "yes"`},
+				{Path: "routes[1].upstream", Message: `unknown upstream "missing"`},
+			},
+		},
+		{
+			name: "nested array index",
+			doc:  `{"routes": [{"path": "/a", "backend": "http://a", "methods": ["GET", 7]}]}`,
+			want: []ValidationError{
+				{Path: "routes[0].methods[1]", Message: "expected string, got number 7"},
+			},
+		},
+		{
+			name: "target weight above the cap",
+			doc: `{
+				"upstreams": [{"name": "u", "targets": [{"url": "http://a", "weight": 1000000}]}],
+				"routes": [{"path": "/a", "upstream": "u", "methods": ["GET"]}]
+			}`,
+			want: []ValidationError{
+				{Path: "upstreams[0].targets[0].weight", Message: "must be between 0 and 100, got 1000000"},
+			},
+		},
+		{
+			name: "rate limit key policy",
+			doc: `{"routes": [
+				{"path": "/a", "backend": "http://a", "methods": ["GET"], "rate_limit_key_missing": "reject"},
+				{"path": "/b", "backend": "http://b", "methods": ["GET"], "rate_limit_key": [{"type": "header", "name": "X-T"}], "rate_limit_key_missing": "skip"}
+			]}`,
+			want: []ValidationError{
+				{Path: "routes[0].rate_limit_key_missing", Message: "requires rate_limit_key"},
+				{Path: "routes[1].rate_limit_key_missing", Message: `must be "client" or "reject", got "skip"`},
+			},
+		},
+		{
+			name: "breaker open timeout",
+			doc: `{
+				"routes": [{"path": "/a", "backend": "http://a", "methods": ["GET"]}],
+				"circuit_breaker": {"timeout_seconds": 0}
+			}`,
+			want: []ValidationError{
+				{Path: "circuit_breaker.timeout_seconds", Message: "must be positive, got 0"},
+			},
+		},
+		{
+			name: "out of range",
+			doc:  `{"routes": [{"path": "/a", "backend": "http://a", "methods": ["GET"]}], "cache": {"max_entries": 1e30}}`,
+			want: []ValidationError{
+				{Path: "cache.max_entries", Message: "expected integer, got number 1e+30"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse([]byte(tt.doc))
+			var got ValidationErrors
+			if err != nil && !errors.As(err, &got) {
+				t.Fatalf("Parse() error = %v, want ValidationErrors", err)
+			}
+			if len(got) == 0 && len(tt.want) == 0 {
+				return
+			}
+			if !reflect.DeepEqual([]ValidationError(got), tt.want) {
+				t.Errorf("Parse() errors =\n%v\nwant\n%v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+)
+
+// Changes records which sections differ between two configurations
+type Changes struct {
+	Listener       bool
+	Routes         bool
+	Upstreams      bool
+	RateLimit      bool
+	RateLimitShape bool // num_shards changed, limiter must be rebuilt
+	CircuitBreaker bool
+	Cache          bool
+	CacheSize      bool
+	ConnectionPool bool
+	Timeouts       bool
+	LoadShedding   bool
+	HealthChecks   bool
+	RetryBudget    bool
+	HedgeBudget    bool
+	TLS            bool // certificates, policy or redirects
+	JWT            bool
+	APIKeys        bool // settings or the contents of the key file
+	AccessLog      bool
+	DebugRoutes    bool
+}
+
+// Diff compares two configurations section by section. A nil old config is
+// treated as differing in every section.
+func Diff(old, new *Config) Changes {
+	if old == nil {
+		return Changes{
+			Listener: true, Routes: true, Upstreams: true, RateLimit: true, RateLimitShape: true,
+			CircuitBreaker: true, Cache: true, CacheSize: true,
+			ConnectionPool: true, Timeouts: true, LoadShedding: true, HealthChecks: true,
+			RetryBudget: true, HedgeBudget: true, TLS: true, JWT: true,
+			APIKeys: true, AccessLog: true, DebugRoutes: true,
+		}
+	}
+
+	// Listeners are only set up at startup
+	listener := old.ListenAddr != new.ListenAddr || old.MetricsAddr != new.MetricsAddr ||
+		old.HTTP2 != new.HTTP2 || old.TLS.Enabled != new.TLS.Enabled || old.TLS.ListenAddr != new.TLS.ListenAddr
+
+	return Changes{
+		Listener:       listener,
+		Routes:         !reflect.DeepEqual(old.Routes, new.Routes),
+		Upstreams:      !reflect.DeepEqual(old.Upstreams, new.Upstreams),
+		RateLimit:      !reflect.DeepEqual(old.RateLimit, new.RateLimit),
+		RateLimitShape: old.RateLimit.NumShards != new.RateLimit.NumShards,
+		CircuitBreaker: !reflect.DeepEqual(old.CircuitBreaker, new.CircuitBreaker),
+		Cache:          old.Cache != new.Cache,
+		CacheSize:      old.Cache.MaxSize != new.Cache.MaxSize || old.Cache.MaxEntries != new.Cache.MaxEntries,
+		ConnectionPool: old.ConnectionPool != new.ConnectionPool,
+		Timeouts:       old.Timeouts != new.Timeouts,
+		LoadShedding:   old.LoadShedding != new.LoadShedding,
+		HealthChecks:   old.HealthChecks != new.HealthChecks,
+		RetryBudget:    old.RetryBudget != new.RetryBudget,
+		HedgeBudget:    old.HedgeBudget != new.HedgeBudget,
+		TLS:            !reflect.DeepEqual(old.TLS, new.TLS),
+		JWT:            !reflect.DeepEqual(old.JWT, new.JWT),
+		APIKeys:        !reflect.DeepEqual(old.APIKeys, new.APIKeys),
+		AccessLog:      old.AccessLog != new.AccessLog,
+		DebugRoutes:    old.DebugRoutes != new.DebugRoutes,
+	}
+}
+
+// Any reports whether any section changed
+func (c Changes) Any() bool {
+	return c != Changes{}
+}
+
+func (c Changes) String() string {
+	var parts []string
+	add := func(changed bool, name string) {
+		if changed {
+			parts = append(parts, name)
+		}
+	}
+	add(c.Listener, "listener")
+	add(c.Routes, "routes")
+	add(c.Upstreams, "upstreams")
+	add(c.RateLimit, "rate_limit")
+	add(c.CircuitBreaker, "circuit_breaker")
+	add(c.Cache, "cache")
+	add(c.ConnectionPool, "connection_pool")
+	add(c.Timeouts, "timeouts")
+	add(c.LoadShedding, "load_shedding")
+	add(c.HealthChecks, "health_checks")
+	add(c.RetryBudget, "retry_budget")
+	add(c.HedgeBudget, "hedge_budget")
+	add(c.TLS, "tls")
+	add(c.JWT, "jwt")
+	add(c.APIKeys, "api_keys")
+	add(c.AccessLog, "access_log")
+	add(c.DebugRoutes, "debug_routes")
+	if len(parts) == 0 {
+		return "none"
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,54 @@
+package config
+
+import "testing"
+
+func TestDiff(t *testing.T) {
+	const doc = `{
+		"upstreams": [{"name": "u", "targets": [{"url": "http://a"}]}],
+		"routes": [{"path": "/a", "upstream": "u", "methods": ["GET"]}]
+	}`
+	tests := []struct {
+		name   string
+		change func(c *Config)
+		want   Changes
+	}{
+		{"nothing", func(c *Config) {}, Changes{}},
+		{"route", func(c *Config) { c.Routes[0].Methods = []string{"POST"} }, Changes{Routes: true}},
+		{"upstream target", func(c *Config) { c.Upstreams[0].Targets[0].URL = "http://b" }, Changes{Upstreams: true}},
+		{"rate", func(c *Config) { c.RateLimit.DefaultRate++ }, Changes{RateLimit: true}},
+		{"shards", func(c *Config) { c.RateLimit.NumShards++ }, Changes{RateLimit: true, RateLimitShape: true}},
+		{"cache policy", func(c *Config) { c.Cache.GraceSeconds++ }, Changes{Cache: true}},
+		{"cache size", func(c *Config) { c.Cache.MaxEntries++ }, Changes{Cache: true, CacheSize: true}},
+		{"breaker", func(c *Config) { c.CircuitBreaker.FailureThreshold++ }, Changes{CircuitBreaker: true}},
+		{"timeouts", func(c *Config) { c.Timeouts.TotalSeconds++ }, Changes{Timeouts: true}},
+		{"listen address", func(c *Config) { c.ListenAddr = ":9999" }, Changes{Listener: true}},
+		{"tls listener", func(c *Config) { c.TLS.ListenAddr = ":9443" }, Changes{Listener: true, TLS: true}},
+		{"two sections", func(c *Config) {
+			c.RetryBudget.Percent++
+			c.HedgeBudget.Percent++
+		}, Changes{RetryBudget: true, HedgeBudget: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			old, err := Parse([]byte(doc))
+			if err != nil {
+				t.Fatal(err)
+			}
+			next, _ := Parse([]byte(doc))
+			tt.change(next)
+
+			got := Diff(old, next)
+			if got != tt.want {
+				t.Errorf("Diff() = %v, want %v", got, tt.want)
+			}
+			if got.Any() != (tt.want != Changes{}) {
+				t.Errorf("Any() = %v", got.Any())
+			}
+		})
+	}
+
+	cfg, _ := Parse([]byte(doc))
+	if all := Diff(nil, cfg); !all.Routes || !all.Listener || !all.JWT {
+		t.Errorf("Diff(nil, cfg) = %v, want every section", all)
+	}
+}
@@ -0,0 +1,812 @@
+package config
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+
+	"gateway/apikey"
+	"gateway/balancer"
+	"gateway/certs"
+	"gateway/ratelimit"
+	"gateway/router"
+)
+
+// ValidationError is a single configuration problem located by JSON path
+type ValidationError struct {
+	Path    string
+	Message string
+}
+
+func (e ValidationError) Error() string {
+	if e.Path == "" {
+		return e.Message
+	}
+	return e.Path + ": " + e.Message
+}
+
+// ValidationErrors collects every problem found while loading a config
+type ValidationErrors []ValidationError
+
+func (e ValidationErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("invalid config (%d problems): %s", len(e), strings.Join(msgs, "; "))
+}
+
+func (e *ValidationErrors) add(path, format string, args ...interface{}) {
+	*e = append(*e, ValidationError{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+var validMethods = map[string]bool{
+	"GET":     true,
+	"HEAD":    true,
+	"POST":    true,
+	"PUT":     true,
+	"PATCH":   true,
+	"DELETE":  true,
+	"OPTIONS": true,
+	"CONNECT": true,
+	"TRACE":   true,
+}
+
+// Validate checks semantic constraints that decoding alone cannot enforce
+func (c *Config) Validate() error {
+	_, err := c.validate()
+	return err
+}
+
+// validate is Validate, also returning the API key store it loaded so
+// Parse doesn't read the key file a second time
+func (c *Config) validate() (*apikey.Store, error) {
+	var errs ValidationErrors
+
+	if len(c.Routes) == 0 {
+		errs.add("routes", "at least one route is required")
+	}
+
+	upstreams := make(map[string]bool, len(c.Upstreams))
+	for i := range c.Upstreams {
+		path := fmt.Sprintf("upstreams[%d]", i)
+		u := &c.Upstreams[i]
+		c.validateUpstream(path, u, &errs)
+		if u.Name == "" {
+			continue
+		}
+		if upstreams[u.Name] {
+			errs.add(path+".name", "duplicate upstream name %q", u.Name)
+		}
+		upstreams[u.Name] = true
+	}
+
+	// Building a throwaway route table catches malformed patterns and
+	// routes that would shadow each other, e.g. "/u/{id}" and "/u/{name}"
+	// with the same methods and predicates
+	table := router.New()
+	for i, route := range c.Routes {
+		path := fmt.Sprintf("routes[%d]", i)
+		c.validateRoute(path, &route, &errs)
+		if route.Upstream != "" && !upstreams[route.Upstream] {
+			errs.add(path+".upstream", "unknown upstream %q", route.Upstream)
+		}
+
+		preds := make([]router.Predicate, 0, len(route.Predicates))
+		for j, pc := range route.Predicates {
+			pred, err := pc.Compile()
+			if err != nil {
+				errs.add(fmt.Sprintf("%s.predicates[%d]", path, j), "%v", err)
+				continue
+			}
+			preds = append(preds, pred)
+		}
+		if err := table.Add(route.Path, route.Methods, preds, i); err != nil {
+			errs.add(path+".path", "%v", err)
+		}
+	}
+
+	if c.RateLimit.NumShards <= 0 {
+		errs.add("rate_limit.num_shards", "must be positive, got %d", c.RateLimit.NumShards)
+	}
+	if c.RateLimit.BurstSize <= 0 {
+		errs.add("rate_limit.burst_size", "must be positive, got %d", c.RateLimit.BurstSize)
+	}
+	if c.RateLimit.DefaultRate <= 0 {
+		errs.add("rate_limit.default_rate_per_minute", "must be positive, got %d", c.RateLimit.DefaultRate)
+	}
+	if _, err := c.RateLimit.CompileTrustedProxies(); err != nil {
+		errs.add("rate_limit.trusted_proxies", "%v", err)
+	}
+	if c.CircuitBreaker.FailureThreshold <= 0 {
+		errs.add("circuit_breaker.failure_threshold", "must be positive, got %d", c.CircuitBreaker.FailureThreshold)
+	}
+	if c.CircuitBreaker.SuccessThreshold <= 0 {
+		errs.add("circuit_breaker.success_threshold", "must be positive, got %d", c.CircuitBreaker.SuccessThreshold)
+	}
+	if d := c.CircuitBreaker.HealthDecay; d < 0 || d > 1 {
+		errs.add("circuit_breaker.health_decay", "must be between 0 and 1, got %v", d)
+	}
+	if s := c.CircuitBreaker.Scope; s != BreakerScopeTarget && s != BreakerScopeRoute {
+		errs.add("circuit_breaker.scope", "must be %q or %q, got %q", BreakerScopeTarget, BreakerScopeRoute, s)
+	}
+	if c.CircuitBreaker.MaxBreakers <= 0 {
+		errs.add("circuit_breaker.max_breakers", "must be positive, got %d", c.CircuitBreaker.MaxBreakers)
+	}
+	for i, code := range c.CircuitBreaker.FailureStatusCodes {
+		if code < 100 || code > 599 {
+			errs.add(fmt.Sprintf("circuit_breaker.failure_status_codes[%d]", i), "invalid HTTP status %d", code)
+		}
+	}
+	for i, code := range c.CircuitBreaker.FailureGRPCCodes {
+		if code < 1 || code > 16 {
+			errs.add(fmt.Sprintf("circuit_breaker.failure_grpc_codes[%d]", i), "invalid gRPC error code %d", code)
+		}
+	}
+	if c.CircuitBreaker.SlowCallThresholdMs < 0 {
+		errs.add("circuit_breaker.slow_call_threshold_ms", "must not be negative, got %d", c.CircuitBreaker.SlowCallThresholdMs)
+	}
+	if r := c.CircuitBreaker.FailureRateThreshold; r < 0 || r > 100 {
+		errs.add("circuit_breaker.failure_rate_threshold", "must be a percentage between 0 and 100, got %v", r)
+	}
+	if r := c.CircuitBreaker.SlowCallRateThreshold; r < 0 || r > 100 {
+		errs.add("circuit_breaker.slow_call_rate_threshold", "must be a percentage between 0 and 100, got %v", r)
+	}
+	if c.CircuitBreaker.SlowCallRateThreshold > 0 && c.CircuitBreaker.SlowCallThresholdMs == 0 {
+		errs.add("circuit_breaker.slow_call_rate_threshold", "requires slow_call_threshold_ms")
+	}
+	if c.CircuitBreaker.WindowSeconds <= 0 {
+		errs.add("circuit_breaker.window_seconds", "must be positive, got %d", c.CircuitBreaker.WindowSeconds)
+	}
+	if c.CircuitBreaker.MinimumCalls < 0 {
+		errs.add("circuit_breaker.minimum_calls", "must not be negative, got %d", c.CircuitBreaker.MinimumCalls)
+	}
+	if c.CircuitBreaker.HalfOpenMaxCalls <= 0 {
+		errs.add("circuit_breaker.half_open_max_calls", "must be positive, got %d", c.CircuitBreaker.HalfOpenMaxCalls)
+	}
+	if m := c.CircuitBreaker.BackoffMultiplier; m < 1 {
+		errs.add("circuit_breaker.backoff_multiplier", "must be at least 1, got %v", m)
+	}
+	if c.CircuitBreaker.TimeoutSeconds <= 0 {
+		errs.add("circuit_breaker.timeout_seconds", "must be positive, got %d", c.CircuitBreaker.TimeoutSeconds)
+	}
+	if c.CircuitBreaker.MaxTimeoutSeconds < c.CircuitBreaker.TimeoutSeconds {
+		errs.add("circuit_breaker.max_timeout_seconds", "must be at least timeout_seconds (%d), got %d", c.CircuitBreaker.TimeoutSeconds, c.CircuitBreaker.MaxTimeoutSeconds)
+	}
+	c.validateTLS(&errs)
+	c.validateJWT(&errs)
+	keys := c.validateAPIKeys(&errs)
+	if c.HTTP2.MaxConcurrentStreams == 0 {
+		errs.add("http2.max_concurrent_streams", "must be positive")
+	}
+	if c.Cache.MaxSize < 0 {
+		errs.add("cache.max_size_mb", "must not be negative, got %d", c.Cache.MaxSize)
+	}
+	if c.Cache.MaxEntries <= 0 {
+		errs.add("cache.max_entries", "must be positive, got %d", c.Cache.MaxEntries)
+	}
+	if c.Cache.MaxObjectBytes < 0 {
+		errs.add("cache.max_object_bytes", "must not be negative, got %d", c.Cache.MaxObjectBytes)
+	}
+	if c.Cache.GraceSeconds < 0 {
+		errs.add("cache.grace_seconds", "must not be negative, got %d", c.Cache.GraceSeconds)
+	}
+	if c.Cache.StaleWhileRevalidateSeconds < 0 {
+		errs.add("cache.stale_while_revalidate_seconds", "must not be negative, got %d", c.Cache.StaleWhileRevalidateSeconds)
+	}
+	if c.Cache.StaleIfErrorSeconds < 0 {
+		errs.add("cache.stale_if_error_seconds", "must not be negative, got %d", c.Cache.StaleIfErrorSeconds)
+	}
+	if c.Cache.PurgeEnabled && c.Cache.PurgeToken == "" {
+		errs.add("cache.purge_token", "is required when purge_enabled is set")
+	}
+	validateHealthCheck("health_checks", &c.HealthChecks, false, &errs)
+	if p := c.RetryBudget.Percent; p < 0 || p > 100 {
+		errs.add("retry_budget.percent", "must be a percentage between 0 and 100, got %v", p)
+	}
+	if c.RetryBudget.MinPerSecond < 0 {
+		errs.add("retry_budget.min_retries_per_second", "must not be negative, got %d", c.RetryBudget.MinPerSecond)
+	}
+	if c.RetryBudget.WindowSeconds <= 0 {
+		errs.add("retry_budget.window_seconds", "must be positive, got %d", c.RetryBudget.WindowSeconds)
+	}
+	if p := c.HedgeBudget.Percent; p < 0 || p > 100 {
+		errs.add("hedge_budget.percent", "must be a percentage between 0 and 100, got %v", p)
+	}
+	if c.HedgeBudget.WindowSeconds <= 0 {
+		errs.add("hedge_budget.window_seconds", "must be positive, got %d", c.HedgeBudget.WindowSeconds)
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return keys, nil
+}
+
+func (c *Config) validateRoute(path string, route *RouteConfig, errs *ValidationErrors) {
+	switch {
+	case route.Backend != "" && route.Upstream != "":
+		errs.add(path, "backend and upstream are mutually exclusive")
+	case route.Backend != "":
+		validateURL(path+".backend", route.Backend, errs)
+	case route.Upstream == "":
+		errs.add(path, "one of backend or upstream is required")
+	}
+
+	if len(route.Methods) == 0 {
+		errs.add(path+".methods", "at least one method is required")
+	}
+	for j, m := range route.Methods {
+		if !validMethods[m] {
+			errs.add(fmt.Sprintf("%s.methods[%d]", path, j), "unknown HTTP method %q", m)
+		}
+	}
+
+	if route.Rewrite != nil {
+		c.validateRewrite(path+".rewrite", route, errs)
+	}
+
+	if j := route.JWT; j != nil {
+		if !c.JWT.Enabled() {
+			errs.add(path+".jwt", "requires keys or a jwks_url in the jwt section")
+		}
+		for claim, values := range j.Claims {
+			if claim == "" {
+				errs.add(path+".jwt.claims", "claim names must not be empty")
+			} else if len(values) == 0 {
+				errs.add(path+".jwt.claims."+claim, "at least one accepted value is required")
+			}
+		}
+	}
+
+	if route.CacheAuthenticated && !route.EnableCache {
+		errs.add(path+".cache_authenticated", "requires enable_cache")
+	}
+
+	if route.APIKey && !c.APIKeys.Enabled() {
+		errs.add(path+".api_key", "requires a file in the api_keys section")
+	}
+
+	if o := route.CircuitBreaker; o != nil {
+		if o.FailureThreshold < 0 {
+			errs.add(path+".circuit_breaker.failure_threshold", "must not be negative, got %d", o.FailureThreshold)
+		}
+		if o.SuccessThreshold < 0 {
+			errs.add(path+".circuit_breaker.success_threshold", "must not be negative, got %d", o.SuccessThreshold)
+		}
+		if o.TimeoutSeconds < 0 {
+			errs.add(path+".circuit_breaker.timeout_seconds", "must not be negative, got %d", o.TimeoutSeconds)
+		}
+	}
+
+	if rc := route.Retry; rc != nil {
+		validateRetry(path+".retry", rc, errs)
+	}
+
+	if h := route.Hedge; h != nil {
+		if h.DelayMs < 0 {
+			errs.add(path+".hedge.delay_ms", "must not be negative, got %d", h.DelayMs)
+		}
+		if h.Percentile <= 0 || h.Percentile > 100 {
+			errs.add(path+".hedge.percentile", "must be a percentage between 0 and 100, got %v", h.Percentile)
+		}
+		if h.MinDelayMs < 0 {
+			errs.add(path+".hedge.min_delay_ms", "must not be negative, got %d", h.MinDelayMs)
+		}
+		if h.MaxHedges <= 0 {
+			errs.add(path+".hedge.max_hedges", "must be positive, got %d", h.MaxHedges)
+		}
+	}
+
+	if u := route.Upgrade; u != nil {
+		if u.IdleTimeoutSeconds <= 0 {
+			errs.add(path+".upgrade.idle_timeout_seconds", "must be positive, got %d", u.IdleTimeoutSeconds)
+		}
+		if u.MaxLifetimeSeconds < 0 {
+			errs.add(path+".upgrade.max_lifetime_seconds", "must not be negative, got %d", u.MaxLifetimeSeconds)
+		}
+		if u.MaxConnections <= 0 {
+			errs.add(path+".upgrade.max_connections", "must be positive, got %d", u.MaxConnections)
+		}
+	}
+
+	if route.RateLimit < 0 {
+		errs.add(path+".rate_limit_per_minute", "must not be negative, got %d", route.RateLimit)
+	}
+	if len(route.RateLimitKey) > 0 {
+		c.validateRateLimitKey(path+".rate_limit_key", route, errs)
+	}
+	switch route.RateLimitKeyMissing {
+	case "":
+	case RateLimitKeyClient, RateLimitKeyReject:
+		if len(route.RateLimitKey) == 0 {
+			errs.add(path+".rate_limit_key_missing", "requires rate_limit_key")
+		}
+	default:
+		errs.add(path+".rate_limit_key_missing", "must be %q or %q, got %q", RateLimitKeyClient, RateLimitKeyReject, route.RateLimitKeyMissing)
+	}
+	if route.FlushIntervalMs < 0 {
+		errs.add(path+".flush_interval_ms", "must not be negative, got %d", route.FlushIntervalMs)
+	}
+	if route.Timeout <= 0 {
+		errs.add(path+".timeout_seconds", "must be positive, got %d", route.Timeout)
+	}
+}
+
+// validateRateLimitKey checks that every key part can be found in the
+// route's requests. Only headers and claims may still be missing.
+func (c *Config) validateRateLimitKey(path string, route *RouteConfig, errs *ValidationErrors) {
+	params, _ := router.ParamNames(route.Path)
+	for i, kc := range route.RateLimitKey {
+		partPath := fmt.Sprintf("%s[%d]", path, i)
+		if _, err := ratelimit.NewKeyPart(kc.Type, kc.Name); err != nil {
+			errs.add(partPath, "%v", err)
+			continue
+		}
+		switch kc.Type {
+		case ratelimit.PartForwarded:
+			if len(c.RateLimit.TrustedProxies) == 0 {
+				errs.add(partPath, "requires rate_limit.trusted_proxies")
+			}
+		case ratelimit.PartClaim:
+			if route.JWT == nil {
+				errs.add(partPath, "requires the route's jwt block")
+			}
+		case ratelimit.PartAPIKey:
+			if !route.APIKey {
+				errs.add(partPath, "requires api_key on the route")
+			}
+		case ratelimit.PartParam:
+			if !contains(params, kc.Name) {
+				errs.add(partPath, "route path %q has no parameter %q", route.Path, kc.Name)
+			}
+		case ratelimit.PartGlobal:
+			if len(route.RateLimitKey) > 1 {
+				errs.add(partPath, "global cannot be combined with other parts")
+			}
+		}
+	}
+}
+
+func contains(values []string, v string) bool {
+	for _, s := range values {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Config) validateUpstream(path string, u *UpstreamConfig, errs *ValidationErrors) {
+	if u.Name == "" {
+		errs.add(path+".name", "is required")
+	}
+	if len(u.Targets) == 0 {
+		errs.add(path+".targets", "at least one target is required")
+	}
+	seen := make(map[string]bool, len(u.Targets))
+	for i, t := range u.Targets {
+		tpath := fmt.Sprintf("%s.targets[%d]", path, i)
+		validateURL(tpath+".url", t.URL, errs)
+		if seen[t.URL] {
+			errs.add(tpath+".url", "duplicate target %q", t.URL)
+		}
+		seen[t.URL] = true
+		if t.Weight < 0 || t.Weight > balancer.MaxWeight {
+			errs.add(tpath+".weight", "must be between 0 and %d, got %d", balancer.MaxWeight, t.Weight)
+		}
+	}
+
+	if u.HealthCheck != nil {
+		validateHealthCheck(path+".health_check", u.HealthCheck, true, errs)
+	}
+
+	// h2c and h2 differ only in TLS, so the target scheme must agree
+	var scheme string
+	switch u.Protocol {
+	case "", ProtocolHTTP1:
+	case ProtocolH2C:
+		scheme = "http"
+	case ProtocolH2:
+		scheme = "https"
+	default:
+		errs.add(path+".protocol", "must be %q, %q or %q, got %q", ProtocolHTTP1, ProtocolH2C, ProtocolH2, u.Protocol)
+	}
+	for i, t := range u.Targets {
+		if tu, err := url.Parse(t.URL); err == nil && scheme != "" && tu.Scheme != scheme {
+			errs.add(fmt.Sprintf("%s.targets[%d].url", path, i), "protocol %s requires %s targets, got %q", u.Protocol, scheme, t.URL)
+		}
+	}
+
+	if u.TLS != nil {
+		validateUpstreamTLS(path, u, errs)
+	}
+
+	switch u.Strategy {
+	case "", balancer.RoundRobin, balancer.WeightedRoundRobin, balancer.LeastOutstanding, balancer.RandomTwoChoices:
+		if u.HashKey != nil {
+			errs.add(path+".hash_key", "only used by the consistent_hash strategy")
+		}
+	case balancer.ConsistentHash:
+		if u.HashKey == nil {
+			errs.add(path+".hash_key", "required by the consistent_hash strategy")
+		} else if _, err := balancer.NewKeyFunc(u.HashKey.Source, u.HashKey.Name); err != nil {
+			errs.add(path+".hash_key", "%v", err)
+		}
+	default:
+		errs.add(path+".strategy", "unknown strategy %q", u.Strategy)
+	}
+}
+
+func validateUpstreamTLS(path string, u *UpstreamConfig, errs *ValidationErrors) {
+	if u.Protocol == ProtocolH2C {
+		errs.add(path+".tls", "not used by h2c upstreams")
+		return
+	}
+	for i, t := range u.Targets {
+		if tu, err := url.Parse(t.URL); err == nil && tu.Scheme != "https" {
+			errs.add(fmt.Sprintf("%s.targets[%d].url", path, i), "tls requires https targets, got %q", t.URL)
+		}
+	}
+
+	t := u.TLS
+	if (t.CertFile == "") != (t.KeyFile == "") {
+		errs.add(path+".tls", "cert_file and key_file must be set together")
+		return
+	}
+	// Loading the files catches unreadable or mismatched ones before the
+	// config is accepted
+	if _, err := t.Compile(); err != nil {
+		errs.add(path+".tls", "%v", err)
+	}
+}
+
+// http2Ciphers are the TLS 1.2 suites HTTP/2 requires one of
+var http2Ciphers = map[string]bool{
+	"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256":   true,
+	"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256": true,
+}
+
+func (c *Config) validateTLS(errs *ValidationErrors) {
+	t := &c.TLS
+	if _, err := certs.ParseVersion(t.MinVersion); err != nil {
+		errs.add("tls.min_version", "%v", err)
+	}
+	http2Cipher := false
+	for i, name := range t.CipherSuites {
+		if _, err := certs.ParseCipherSuite(name); err != nil {
+			errs.add(fmt.Sprintf("tls.cipher_suites[%d]", i), "%v", err)
+		}
+		http2Cipher = http2Cipher || http2Ciphers[name]
+	}
+	if len(t.CipherSuites) > 0 && !http2Cipher {
+		errs.add("tls.cipher_suites", "must include TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 or TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, required by HTTP/2")
+	}
+	for i, host := range t.RedirectHosts {
+		if host != "*" && strings.Contains(host, "*") && (!strings.HasPrefix(host, "*.") || strings.Count(host, "*") > 1) {
+			errs.add(fmt.Sprintf("tls.redirect_hosts[%d]", i), "invalid host pattern %q, wildcards must look like *.example.com or *", host)
+		}
+	}
+
+	if ca := t.ClientAuth; ca != nil {
+		if _, err := certs.ParseClientAuth(ca.Mode); err != nil {
+			errs.add("tls.client_auth.mode", "%v", err)
+		}
+		if ca.CAFile == "" {
+			errs.add("tls.client_auth.ca_file", "is required")
+		} else if _, err := certs.LoadCAs(ca.CAFile); err != nil {
+			errs.add("tls.client_auth.ca_file", "%v", err)
+		}
+		if ca.Identity != certs.IdentitySubject && ca.Identity != certs.IdentitySAN {
+			errs.add("tls.client_auth.identity", "must be %q or %q, got %q", certs.IdentitySubject, certs.IdentitySAN, ca.Identity)
+		}
+		if ca.IdentityHeader == "" {
+			errs.add("tls.client_auth.identity_header", "is required")
+		}
+	}
+
+	if !t.Enabled {
+		return
+	}
+	if t.ListenAddr == "" {
+		errs.add("tls.listen_addr", "is required")
+	} else if t.ListenAddr == c.ListenAddr {
+		errs.add("tls.listen_addr", "must differ from listen_addr %q", c.ListenAddr)
+	}
+	if len(t.Certificates) == 0 {
+		errs.add("tls.certificates", "at least one certificate is required")
+	}
+	for i, cert := range t.Certificates {
+		path := fmt.Sprintf("tls.certificates[%d]", i)
+		if cert.CertFile == "" {
+			errs.add(path+".cert_file", "is required")
+		}
+		if cert.KeyFile == "" {
+			errs.add(path+".key_file", "is required")
+		}
+	}
+}
+
+func (c *Config) validateJWT(errs *ValidationErrors) {
+	j := &c.JWT
+	if j.LeewaySeconds < 0 {
+		errs.add("jwt.leeway_seconds", "must not be negative, got %d", j.LeewaySeconds)
+	}
+	for i := range j.Keys {
+		if _, err := j.Keys[i].Compile(); err != nil {
+			errs.add(fmt.Sprintf("jwt.keys[%d]", i), "%v", err)
+		}
+	}
+	if j.JWKSURL != "" {
+		validateURL("jwt.jwks_url", j.JWKSURL, errs)
+		if j.JWKSRefreshSeconds <= 0 {
+			errs.add("jwt.jwks_refresh_seconds", "must be positive, got %d", j.JWKSRefreshSeconds)
+		}
+	}
+	for claim, header := range j.ForwardClaims {
+		if claim == "" || header == "" {
+			errs.add("jwt.forward_claims", "claim and header names must not be empty, got %q: %q", claim, header)
+		}
+	}
+}
+
+// validateAPIKeys checks that the key file parses and only names known
+// routes, so a broken one is rejected like any other config problem and
+// the last good keys stay active. It returns the loaded keys.
+func (c *Config) validateAPIKeys(errs *ValidationErrors) *apikey.Store {
+	a := &c.APIKeys
+	if !a.Enabled() {
+		return nil
+	}
+	if a.Header == "" && a.QueryParam == "" {
+		errs.add("api_keys.header", "a header or query_param is required")
+	}
+
+	store, err := apikey.Load(a.File)
+	if err != nil {
+		errs.add("api_keys.file", "%v", err)
+		return nil
+	}
+	routes := make(map[string]bool, len(c.Routes))
+	for _, r := range c.Routes {
+		routes[r.Path] = true
+	}
+	for _, k := range store.Keys() {
+		for _, path := range k.Routes {
+			if !routes[path] {
+				errs.add("api_keys.file", "key %q allows unknown route %q", k.ID, path)
+			}
+		}
+	}
+	return store
+}
+
+func validateURL(path, raw string, errs *ValidationErrors) {
+	if u, err := url.Parse(raw); err != nil {
+		errs.add(path, "invalid URL: %v", err)
+	} else if u.Scheme != "http" && u.Scheme != "https" {
+		errs.add(path, "scheme must be http or https, got %q", raw)
+	} else if u.Host == "" {
+		errs.add(path, "missing host in %q", raw)
+	}
+}
+
+func (c *Config) validateRewrite(path string, route *RouteConfig, errs *ValidationErrors) {
+	rw, err := route.Rewrite.Compile()
+	if err != nil {
+		errs.add(path, "%v", err)
+		return
+	}
+
+	// Every template parameter must be captured by the route pattern
+	names, err := router.ParamNames(route.Path)
+	if err != nil {
+		return // reported against the path already
+	}
+	captured := make(map[string]bool, len(names))
+	for _, name := range names {
+		captured[name] = true
+	}
+	for _, name := range rw.Params() {
+		if !captured[name] {
+			errs.add(path+".template", "parameter {%s} is not captured by route path %q", name, route.Path)
+		}
+	}
+}
+
+var configType = reflect.TypeOf(Config{})
+
+// checkDocument walks the raw JSON document alongside the Go type and
+// records every object key that has no matching json tag and every value
+// of the wrong JSON type, located with their array indices
+func checkDocument(path string, raw interface{}, t reflect.Type, errs *ValidationErrors) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if raw == nil {
+		return
+	}
+	if want := jsonKind(t); want != "" && !fits(raw, want, t) {
+		errs.add(path, "expected %s, got %s", want, describe(raw))
+		return
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		obj := raw.(map[string]interface{})
+		fields := jsonFields(t)
+		for _, k := range sortedKeys(obj) {
+			child := joinPath(path, k)
+			ft, ok := fields[k]
+			if !ok {
+				errs.add(child, "unknown field")
+				continue
+			}
+			checkDocument(child, obj[k], ft, errs)
+		}
+	case reflect.Slice, reflect.Array:
+		for i, v := range raw.([]interface{}) {
+			checkDocument(fmt.Sprintf("%s[%d]", path, i), v, t.Elem(), errs)
+		}
+	case reflect.Map:
+		obj := raw.(map[string]interface{})
+		for _, k := range sortedKeys(obj) {
+			checkDocument(joinPath(path, k), obj[k], t.Elem(), errs)
+		}
+	}
+}
+
+// jsonKind names the JSON type values of t are decoded from, or "" when
+// any is accepted
+func jsonKind(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Struct, reflect.Map:
+		return "object"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return "integer"
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "non-negative integer"
+	case reflect.Float32, reflect.Float64:
+		return "number"
+	}
+	return ""
+}
+
+// fits reports whether raw, a value of the JSON type kind, decodes into t
+func fits(raw interface{}, kind string, t reflect.Type) bool {
+	switch v := raw.(type) {
+	case map[string]interface{}:
+		return kind == "object"
+	case []interface{}:
+		return kind == "array"
+	case string:
+		return kind == "string"
+	case bool:
+		return kind == "boolean"
+	case float64:
+		switch kind {
+		case "number":
+			return true
+		case "integer":
+			return v == math.Trunc(v) && math.Abs(v) < 1<<63 && !reflect.Zero(t).OverflowInt(int64(v))
+		case "non-negative integer":
+			return v == math.Trunc(v) && v >= 0 && v < 1<<64 && !reflect.Zero(t).OverflowUint(uint64(v))
+		}
+	}
+	return false
+}
+
+// describe names a raw JSON value for type errors
+func describe(raw interface{}) string {
+	switch v := raw.(type) {
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return fmt.Sprintf("string %q", v)
+	case bool:
+		return fmt.Sprintf("boolean %v", v)
+	case float64:
+		return fmt.Sprintf("number %v", v)
+	}
+	return fmt.Sprintf("%T", raw)
+}
+
+func jsonFields(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" || !f.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if name == "" {
+			name = f.Name
+		}
+		fields[name] = f.Type
+	}
+	return fields
+}
+
+func sortedKeys(obj map[string]interface{}) []string {
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func validateRetry(path string, rc *RetryConfig, errs *ValidationErrors) {
+	if rc.MaxAttempts <= 0 {
+		errs.add(path+".max_attempts", "must be positive, got %d", rc.MaxAttempts)
+	}
+	for i, on := range rc.RetryOn {
+		if on != RetryOnConnectError && on != RetryOnReset {
+			errs.add(fmt.Sprintf("%s.retry_on[%d]", path, i), "must be %q or %q, got %q", RetryOnConnectError, RetryOnReset, on)
+		}
+	}
+	for i, code := range rc.StatusCodes {
+		if code < 100 || code > 599 {
+			errs.add(fmt.Sprintf("%s.status_codes[%d]", path, i), "invalid HTTP status %d", code)
+		}
+	}
+	if rc.BaseBackoffMs < 0 {
+		errs.add(path+".base_backoff_ms", "must not be negative, got %d", rc.BaseBackoffMs)
+	}
+	if rc.MaxBackoffMs < rc.BaseBackoffMs {
+		errs.add(path+".max_backoff_ms", "must be at least base_backoff_ms (%d), got %d", rc.BaseBackoffMs, rc.MaxBackoffMs)
+	}
+	if rc.MaxBodyBytes < 0 {
+		errs.add(path+".max_body_bytes", "must not be negative, got %d", rc.MaxBodyBytes)
+	}
+}
+
+// validateHealthCheck checks health check settings. Overrides may leave
+// fields at zero to inherit the global value.
+func validateHealthCheck(path string, h *HealthCheckConfig, override bool, errs *ValidationErrors) {
+	if h.Path != "" && !strings.HasPrefix(h.Path, "/") {
+		errs.add(path+".path", "must start with '/', got %q", h.Path)
+	} else if h.Path == "" && !override {
+		errs.add(path+".path", "is required")
+	}
+
+	positive := func(field string, v int) {
+		if v < 0 || (v == 0 && !override) {
+			errs.add(path+"."+field, "must be positive, got %d", v)
+		}
+	}
+	positive("interval_seconds", h.IntervalSeconds)
+	positive("timeout_seconds", h.TimeoutSeconds)
+	positive("healthy_threshold", h.HealthyThreshold)
+	positive("unhealthy_threshold", h.UnhealthyThreshold)
+
+	status := func(field string, code int) {
+		if (code != 0 || !override) && (code < 100 || code > 599) {
+			errs.add(path+"."+field, "invalid HTTP status %d", code)
+		}
+	}
+	status("expected_status_min", h.ExpectedStatusMin)
+	status("expected_status_max", h.ExpectedStatusMax)
+	if h.ExpectedStatusMin != 0 && h.ExpectedStatusMax != 0 && h.ExpectedStatusMin > h.ExpectedStatusMax {
+		errs.add(path, "expected_status_min %d is above expected_status_max %d", h.ExpectedStatusMin, h.ExpectedStatusMax)
+	}
+}
@@ -0,0 +1,181 @@
+package config
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// reloadDebounce is how long the file must be quiet before it is reloaded
+const reloadDebounce = 100 * time.Millisecond
+
+// WatchConfig reloads the configuration whenever the file at path, or a
+// file it references such as the API key store, changes. A file that fails
+// validation is logged and ignored, leaving the last good configuration
+// active. Accepted changes are passed to OnReload subscribers.
+func WatchConfig(path string) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+
+	var watcher atomic.Pointer[FileWatcher]
+	w, err := WatchFiles(watchedFiles(abs, GetConfig()), func() {
+		reload(abs)
+		if w := watcher.Load(); w != nil {
+			if err := w.SetFiles(watchedFiles(abs, GetConfig())); err != nil {
+				log.Printf("Config watcher: %v", err)
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+	watcher.Store(w)
+	return nil
+}
+
+// watchedFiles returns the config file and the files cfg loads
+func watchedFiles(path string, cfg *Config) []string {
+	files := []string{path}
+	if cfg != nil && cfg.APIKeys.Enabled() {
+		files = append(files, cfg.APIKeys.File)
+	}
+	return files
+}
+
+// FileWatcher calls a function whenever one of a set of files changes.
+// Directories are watched rather than files so editors and tools that
+// replace a file via rename don't silently drop the watch.
+type FileWatcher struct {
+	watcher  *fsnotify.Watcher
+	onChange func()
+
+	mu    sync.Mutex
+	files map[string]bool
+	dirs  map[string]bool
+}
+
+// WatchFiles calls onChange, from a single goroutine, once the files at
+// paths have settled after a change
+func WatchFiles(paths []string, onChange func()) (*FileWatcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("create watcher: %w", err)
+	}
+
+	w := &FileWatcher{
+		watcher:  watcher,
+		onChange: onChange,
+		files:    make(map[string]bool),
+		dirs:     make(map[string]bool),
+	}
+	if err := w.SetFiles(paths); err != nil {
+		watcher.Close()
+		return nil, err
+	}
+
+	go w.run()
+	return w, nil
+}
+
+// SetFiles replaces the set of watched files
+func (w *FileWatcher) SetFiles(paths []string) error {
+	files := make(map[string]bool, len(paths))
+	dirs := make(map[string]bool)
+	for _, p := range paths {
+		abs, err := filepath.Abs(p)
+		if err != nil {
+			return err
+		}
+		files[abs] = true
+		dirs[filepath.Dir(abs)] = true
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for dir := range dirs {
+		if w.dirs[dir] {
+			continue
+		}
+		if err := w.watcher.Add(dir); err != nil {
+			return fmt.Errorf("watch %s: %w", dir, err)
+		}
+	}
+	for dir := range w.dirs {
+		if !dirs[dir] {
+			w.watcher.Remove(dir)
+		}
+	}
+	w.files, w.dirs = files, dirs
+	return nil
+}
+
+// Close stops watching
+func (w *FileWatcher) Close() error {
+	return w.watcher.Close()
+}
+
+func (w *FileWatcher) watched(name string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.files[filepath.Clean(name)]
+}
+
+func (w *FileWatcher) run() {
+	// Writers often truncate and then write, producing several events
+	// per save; wait for the files to settle before reloading
+	debounce := time.NewTimer(time.Hour)
+	debounce.Stop()
+
+	for {
+		select {
+		case <-debounce.C:
+			w.onChange()
+		case event, ok := <-w.watcher.Events:
+			if !ok {
+				return
+			}
+			if !w.watched(event.Name) {
+				continue
+			}
+			if !event.Has(fsnotify.Write) && !event.Has(fsnotify.Create) {
+				continue
+			}
+
+			debounce.Reset(reloadDebounce)
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Printf("File watcher error: %v", err)
+		}
+	}
+}
+
+// reload validates the file, diffs it against the active configuration and
+// publishes it to subscribers when something actually changed
+func reload(path string) {
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		reloadFailures.Add(1)
+		log.Printf("Config reload rejected, keeping version %d: %v", Version(), err)
+		return
+	}
+
+	changes := Diff(GetConfig(), cfg)
+	if !changes.Any() {
+		return
+	}
+
+	version := SetConfig(cfg)
+	reloads.Add(1)
+	log.Printf("Config reloaded from %s (version %d, changed: %s)", path, version, changes)
+	notifyReload(cfg, version)
+}
@@ -5,6 +5,10 @@ go 1.21
 require (
 	github.com/fsnotify/fsnotify v1.7.0
 	github.com/prometheus/client_golang v1.18.0
+	golang.org/x/net v0.19.0
 )
 
-require golang.org/x/sys v0.15.0 // indirect
+require (
+	golang.org/x/sys v0.15.0 // indirect
+	golang.org/x/text v0.14.0 // indirect
+)
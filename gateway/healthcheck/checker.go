@@ -0,0 +1,362 @@
+package healthcheck
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"gateway/balancer"
+	"gateway/circuitbreaker"
+
+	"golang.org/x/net/http2"
+)
+
+// maxBodyBytes bounds how much of a probe response is read for body matching
+const maxBodyBytes = 64 << 10
+
+// Settings configures the probes of one upstream pool
+type Settings struct {
+	Path               string
+	Interval           time.Duration
+	Timeout            time.Duration
+	StatusMin          int
+	StatusMax          int
+	Body               string // substring the response body must contain
+	HealthyThreshold   int    // consecutive passes to mark a target healthy
+	UnhealthyThreshold int    // consecutive failures to mark it unhealthy
+	HealthDecay        float64
+	H2C                bool // probe over cleartext HTTP/2, for upstreams that speak only that
+
+	// Transport, when set, sends the probes instead of the checker's own
+	// clients, so they use the upstream's TLS settings. Reloads rebuild
+	// it, so a new one is swapped into running monitors rather than
+	// restarting them.
+	Transport http.RoundTripper
+}
+
+// sameProbe reports whether a and b differ at most in their transport
+func sameProbe(a, b Settings) bool {
+	a.Transport, b.Transport = nil, nil
+	return a == b
+}
+
+// Checker actively probes upstream targets and takes unhealthy ones out of
+// their pool's rotation until they recover. Probe state is keyed by pool
+// and target URL so it survives config reloads that rebuild the pools.
+type Checker struct {
+	client   *http.Client
+	h2c      *http.Client
+	mu       sync.Mutex
+	monitors map[string]*monitor
+}
+
+// monitor probes one target of one pool
+type monitor struct {
+	pool     string
+	url      *url.URL
+	settings Settings
+	stop     chan struct{}
+	health   *circuitbreaker.HealthTracker
+
+	mu          sync.Mutex
+	target      *balancer.Target  // the copy in the newest compiled pool
+	transport   http.RoundTripper // the newest Settings.Transport
+	healthy     bool
+	passes      int
+	failures    int
+	lastStatus  int
+	lastError   string
+	lastChecked time.Time
+}
+
+// NewChecker creates a health checker with no targets
+func NewChecker() *Checker {
+	// Probes must reflect the target itself, not where it redirects
+	noRedirect := func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	return &Checker{
+		client: &http.Client{CheckRedirect: noRedirect},
+		h2c: &http.Client{
+			CheckRedirect: noRedirect,
+			Transport: &http2.Transport{
+				AllowHTTP: true,
+				DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, network, addr)
+				},
+			},
+		},
+		monitors: make(map[string]*monitor),
+	}
+}
+
+// Sync starts probing every target of the pools named in settings and
+// stops probing everything else. Targets of pools without settings are
+// marked healthy. Known targets keep their health, which is applied to the
+// freshly compiled Target before it serves traffic.
+func (c *Checker) Sync(pools map[string]*balancer.Pool, settings map[string]Settings) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	seen := make(map[string]bool)
+	for name, pool := range pools {
+		s, checked := settings[name]
+		for _, t := range pool.Targets {
+			if !checked {
+				t.SetHealthy(true)
+				continue
+			}
+
+			key := name + " " + t.String()
+			seen[key] = true
+			m := c.monitors[key]
+			if m != nil && !sameProbe(m.settings, s) {
+				close(m.stop)
+				m = m.restart(s)
+				c.monitors[key] = m
+				go c.run(m)
+			}
+			if m == nil {
+				m = newMonitor(name, t.URL, s)
+				c.monitors[key] = m
+				go c.run(m)
+			}
+			m.attach(t, s.Transport)
+		}
+	}
+
+	for key, m := range c.monitors {
+		if !seen[key] {
+			close(m.stop)
+			delete(c.monitors, key)
+		}
+	}
+}
+
+// Stop stops all probes
+func (c *Checker) Stop() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, m := range c.monitors {
+		close(m.stop)
+		delete(c.monitors, key)
+	}
+}
+
+// Stats returns the health of every probed target, grouped by pool
+func (c *Checker) Stats() map[string]interface{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := make(map[string]interface{})
+	for _, m := range c.monitors {
+		pool, ok := stats[m.pool].(map[string]interface{})
+		if !ok {
+			pool = make(map[string]interface{})
+			stats[m.pool] = pool
+		}
+		pool[m.url.String()] = m.stats()
+	}
+	return stats
+}
+
+// Counts returns how many targets are probed and how many of them are
+// currently unhealthy
+func (c *Checker) Counts() (total, unhealthy int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, m := range c.monitors {
+		m.mu.Lock()
+		if !m.healthy {
+			unhealthy++
+		}
+		m.mu.Unlock()
+	}
+	return len(c.monitors), unhealthy
+}
+
+func newMonitor(pool string, u *url.URL, s Settings) *monitor {
+	return &monitor{
+		pool:      pool,
+		url:       u,
+		settings:  s,
+		stop:      make(chan struct{}),
+		health:    circuitbreaker.NewHealthTracker(s.HealthDecay),
+		transport: s.Transport,
+		healthy:   true,
+	}
+}
+
+// restart returns a monitor with new settings that keeps m's health state
+func (m *monitor) restart(s Settings) *monitor {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Detach so a probe still running on the old monitor can't flip the
+	// target after the new one takes over
+	m.target = nil
+	m.health.SetDecay(s.HealthDecay)
+	return &monitor{
+		pool:        m.pool,
+		url:         m.url,
+		settings:    s,
+		stop:        make(chan struct{}),
+		health:      m.health,
+		transport:   s.Transport,
+		healthy:     m.healthy,
+		passes:      m.passes,
+		failures:    m.failures,
+		lastStatus:  m.lastStatus,
+		lastError:   m.lastError,
+		lastChecked: m.lastChecked,
+	}
+}
+
+// attach points the monitor at a newly compiled copy of its target, and
+// the transport compiled with it. Copies from older config versions no
+// longer serve traffic and are dropped.
+func (m *monitor) attach(t *balancer.Target, transport http.RoundTripper) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t.SetHealthy(m.healthy)
+	m.target = t
+	m.transport = transport
+}
+
+func (c *Checker) run(m *monitor) {
+	ticker := time.NewTicker(m.settings.Interval)
+	defer ticker.Stop()
+
+	for {
+		c.probe(m)
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// probe runs one check and updates the target's health
+func (c *Checker) probe(m *monitor) {
+	ctx, cancel := context.WithTimeout(context.Background(), m.settings.Timeout)
+	defer cancel()
+
+	s := m.settings
+	m.mu.Lock()
+	s.Transport = m.transport
+	m.mu.Unlock()
+	status, err := c.check(ctx, m.url, s)
+
+	switch {
+	case err == nil:
+		m.health.RecordSuccess()
+	case errors.Is(err, context.DeadlineExceeded):
+		m.health.RecordTimeout()
+	default:
+		m.health.RecordFailure()
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.lastChecked = time.Now()
+	m.lastStatus = status
+	m.lastError = ""
+	if err != nil {
+		m.lastError = err.Error()
+		m.failures++
+		m.passes = 0
+		if m.healthy && m.failures >= m.settings.UnhealthyThreshold {
+			log.Printf("Health check: %s in %s is unhealthy: %v", m.url, m.pool, err)
+			m.setHealthy(false)
+		}
+		return
+	}
+
+	m.passes++
+	m.failures = 0
+	if !m.healthy && m.passes >= m.settings.HealthyThreshold {
+		log.Printf("Health check: %s in %s is healthy again", m.url, m.pool)
+		m.setHealthy(true)
+	}
+}
+
+// setHealthy flips the target in or out of rotation. Callers must hold m.mu.
+func (m *monitor) setHealthy(healthy bool) {
+	m.healthy = healthy
+	if m.target != nil {
+		m.target.SetHealthy(healthy)
+	}
+}
+
+// check sends one probe and returns the status code and why it failed, if
+// it did
+func (c *Checker) check(ctx context.Context, target *url.URL, s Settings) (int, error) {
+	u := target.ResolveReference(&url.URL{Path: s.Path})
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("User-Agent", "gateway-health-check")
+
+	client := c.client
+	switch {
+	case s.Transport != nil:
+		client = &http.Client{Transport: s.Transport, CheckRedirect: c.client.CheckRedirect}
+	case s.H2C:
+		client = c.h2c
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < s.StatusMin || resp.StatusCode > s.StatusMax {
+		return resp.StatusCode, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	if s.Body != "" {
+		body, err := io.ReadAll(io.LimitReader(resp.Body, maxBodyBytes))
+		if err != nil {
+			return resp.StatusCode, err
+		}
+		if !strings.Contains(string(body), s.Body) {
+			return resp.StatusCode, fmt.Errorf("body does not contain %q", s.Body)
+		}
+	}
+	return resp.StatusCode, nil
+}
+
+func (m *monitor) stats() map[string]interface{} {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stats := map[string]interface{}{
+		"healthy":              m.healthy,
+		"health_score":         m.health.GetScore(),
+		"consecutive_passes":   m.passes,
+		"consecutive_failures": m.failures,
+		"last_status":          m.lastStatus,
+	}
+	if !m.lastChecked.IsZero() {
+		stats["last_checked"] = m.lastChecked.Format(time.RFC3339)
+	}
+	if m.lastError != "" {
+		stats["last_error"] = m.lastError
+	}
+	return stats
+}
@@ -0,0 +1,71 @@
+package healthcheck
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"gateway/balancer"
+)
+
+func TestSyncRestartsOnlyOnProbeChanges(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+
+	base := Settings{
+		Path:               "/health",
+		Interval:           time.Hour,
+		Timeout:            time.Second,
+		StatusMin:          200,
+		StatusMax:          399,
+		HealthyThreshold:   1,
+		UnhealthyThreshold: 1,
+		HealthDecay:        0.9,
+		Transport:          &http.Transport{},
+	}
+	rebuilt := base
+	rebuilt.Transport = &http.Transport{}
+	withPath := base
+	withPath.Path = "/ready"
+
+	tests := []struct {
+		name    string
+		next    Settings
+		restart bool
+	}{
+		{"unchanged", base, false},
+		{"rebuilt transport", rebuilt, false},
+		{"new path", withPath, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewChecker()
+			defer c.Stop()
+
+			sync := func(s Settings) *monitor {
+				target, err := balancer.NewTarget(upstream.URL, 1)
+				if err != nil {
+					t.Fatal(err)
+				}
+				pool := &balancer.Pool{Name: "api", Targets: []*balancer.Target{target}}
+				c.Sync(map[string]*balancer.Pool{"api": pool}, map[string]Settings{"api": s})
+
+				c.mu.Lock()
+				defer c.mu.Unlock()
+				return c.monitors["api "+target.String()]
+			}
+			first := sync(base)
+			second := sync(tt.next)
+
+			if restarted := first != second; restarted != tt.restart {
+				t.Errorf("restarted = %v, want %v", restarted, tt.restart)
+			}
+			second.mu.Lock()
+			defer second.mu.Unlock()
+			if second.transport != tt.next.Transport {
+				t.Error("monitor does not probe with the newest transport")
+			}
+		})
+	}
+}
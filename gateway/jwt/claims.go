@@ -0,0 +1,151 @@
+package jwt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// ErrForbidden is returned by Requirement.Check when a valid token lacks a
+// required scope or claim
+var ErrForbidden = errors.New("insufficient scope")
+
+// maxNumericDate bounds "exp" and "nbf" to dates time.Time can represent
+const maxNumericDate = 1 << 40
+
+// Claims is a verified token's payload. Numbers are json.Number.
+type Claims map[string]interface{}
+
+// Lookup returns the claim at a dotted path, such as "sub" or
+// "realm_access.roles"
+func (c Claims) Lookup(path string) (interface{}, bool) {
+	var v interface{} = map[string]interface{}(c)
+	for _, name := range strings.Split(path, ".") {
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if v, ok = obj[name]; !ok {
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// Values returns the claim at path as strings: each element of a list, or
+// the value itself
+func (c Claims) Values(path string) []string {
+	v, ok := c.Lookup(path)
+	if !ok {
+		return nil
+	}
+	if list, ok := v.([]interface{}); ok {
+		values := make([]string, 0, len(list))
+		for _, e := range list {
+			values = append(values, format(e))
+		}
+		return values
+	}
+	return []string{format(v)}
+}
+
+// String formats the claim at path as a header value, with list elements
+// separated by commas. It is empty when the claim is absent.
+func (c Claims) String(path string) string {
+	return strings.Join(c.Values(path), ",")
+}
+
+// Scopes returns the scopes granted by the token, from the space separated
+// "scope" claim or the "scp" list
+func (c Claims) Scopes() []string {
+	if scope, ok := c["scope"].(string); ok {
+		return strings.Fields(scope)
+	}
+	return c.Values("scp")
+}
+
+// time returns a NumericDate claim
+func (c Claims) time(name string) (time.Time, bool, error) {
+	v, ok := c[name]
+	if !ok {
+		return time.Time{}, false, nil
+	}
+	n, ok := v.(json.Number)
+	if !ok {
+		return time.Time{}, false, fmt.Errorf("%w: %s is not a number", ErrMalformed, name)
+	}
+	secs, err := n.Float64()
+	if err != nil || math.IsNaN(secs) || math.Abs(secs) > maxNumericDate {
+		return time.Time{}, false, fmt.Errorf("%w: %s %s out of range", ErrMalformed, name, n)
+	}
+	whole, frac := math.Modf(secs)
+	return time.Unix(int64(whole), int64(frac*float64(time.Second))), true, nil
+}
+
+func format(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case nil:
+		return ""
+	default:
+		data, _ := json.Marshal(v)
+		return string(data)
+	}
+}
+
+// Requirement is what a route demands of a verified token
+type Requirement struct {
+	Scopes []string            // all must be granted
+	Claims map[string][]string // each claim must have one of its values
+}
+
+// Check returns an error wrapping ErrForbidden if c does not satisfy r
+func (r *Requirement) Check(c Claims) error {
+	granted := make(map[string]bool)
+	for _, s := range c.Scopes() {
+		granted[s] = true
+	}
+	for _, s := range r.Scopes {
+		if !granted[s] {
+			return fmt.Errorf("%w: missing scope %q", ErrForbidden, s)
+		}
+	}
+
+	for path, want := range r.Claims {
+		if !containsAny(c.Values(path), want) {
+			return fmt.Errorf("%w: claim %s must be one of %v", ErrForbidden, path, want)
+		}
+	}
+	return nil
+}
+
+func containsAny(got, want []string) bool {
+	for _, g := range got {
+		for _, w := range want {
+			if g == w {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+type contextKey struct{}
+
+// WithClaims returns a copy of ctx carrying c
+func WithClaims(ctx context.Context, c Claims) context.Context {
+	return context.WithValue(ctx, contextKey{}, c)
+}
+
+// FromContext returns the claims stored in ctx, or nil
+func FromContext(ctx context.Context) Claims {
+	c, _ := ctx.Value(contextKey{}).(Claims)
+	return c
+}
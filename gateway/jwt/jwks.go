@@ -0,0 +1,194 @@
+package jwt
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// maxJWKSBytes bounds the size of a fetched key set
+	maxJWKSBytes = 1 << 20
+
+	// fetchTimeout bounds one fetch of the key set
+	fetchTimeout = 5 * time.Second
+
+	// minRefreshInterval bounds the early refreshes triggered by tokens
+	// signed with an unknown key ID
+	minRefreshInterval = 10 * time.Second
+)
+
+// JWKS is a key set fetched from a URL and refreshed in the background. A
+// token signed with a key ID not in the set triggers an early refresh, at
+// most once per minRefreshInterval, so rotated keys are picked up before
+// the next scheduled one. A failed fetch keeps the previous keys.
+type JWKS struct {
+	url      string
+	interval time.Duration
+	client   *http.Client
+	stop     chan struct{}
+	stopOnce sync.Once
+	fetchMu  sync.Mutex // one fetch at a time
+
+	mu          sync.RWMutex
+	keys        []*Key
+	refreshing  chan struct{} // closed when the early refresh in flight ends
+	fetchedAt   time.Time
+	lastAttempt time.Time
+	fetches     int64
+	failures    int64
+	lastError   string
+}
+
+// NewJWKS creates a key set for url, refreshed every interval once started
+func NewJWKS(url string, interval time.Duration) *JWKS {
+	return &JWKS{
+		url:      url,
+		interval: interval,
+		client:   &http.Client{Timeout: fetchTimeout},
+		stop:     make(chan struct{}),
+	}
+}
+
+// Start fetches the key set now and then every refresh interval
+func (s *JWKS) Start() {
+	go func() {
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+
+		for {
+			s.fetch(context.Background())
+			select {
+			case <-s.stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop stops refreshing the key set
+func (s *JWKS) Stop() {
+	s.stopOnce.Do(func() { close(s.stop) })
+}
+
+// Keys returns the keys in the set. When no key has kid, it waits until ctx
+// is done for an early refresh: the one in flight, or a new one if the set
+// wasn't refreshed in the last minRefreshInterval. The refresh itself runs
+// in the background, so a caller giving up doesn't abort it for others.
+func (s *JWKS) Keys(ctx context.Context, kid string) []*Key {
+	s.mu.RLock()
+	keys := s.keys
+	s.mu.RUnlock()
+	if has(keys, kid) {
+		return keys
+	}
+
+	s.mu.Lock()
+	done := s.refreshing
+	if done == nil && time.Since(s.lastAttempt) >= minRefreshInterval {
+		done = make(chan struct{})
+		s.refreshing = done
+		go s.refresh(done)
+	}
+	s.mu.Unlock()
+
+	if done != nil {
+		select {
+		case <-done:
+		case <-ctx.Done():
+		}
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.keys
+}
+
+// refresh fetches the key set early and closes done
+func (s *JWKS) refresh(done chan struct{}) {
+	s.fetch(context.Background())
+
+	s.mu.Lock()
+	s.refreshing = nil
+	s.mu.Unlock()
+	close(done)
+}
+
+func has(keys []*Key, kid string) bool {
+	for _, k := range keys {
+		if kid == "" || k.ID == kid {
+			return true
+		}
+	}
+	return false
+}
+
+// fetch downloads and installs the key set
+func (s *JWKS) fetch(ctx context.Context) {
+	s.fetchMu.Lock()
+	defer s.fetchMu.Unlock()
+
+	keys, err := s.download(ctx)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastAttempt = time.Now()
+	s.fetches++
+	if err != nil {
+		s.failures++
+		s.lastError = err.Error()
+		log.Printf("JWKS fetch from %s failed, keeping %d keys: %v", s.url, len(s.keys), err)
+		return
+	}
+	s.keys = keys
+	s.fetchedAt = s.lastAttempt
+	s.lastError = ""
+}
+
+func (s *JWKS) download(ctx context.Context) ([]*Key, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxJWKSBytes))
+	if err != nil {
+		return nil, err
+	}
+	return ParseJWKS(data)
+}
+
+// Stats returns the size and freshness of the key set
+func (s *JWKS) Stats() map[string]interface{} {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	stats := map[string]interface{}{
+		"url":      s.url,
+		"keys":     len(s.keys),
+		"fetches":  s.fetches,
+		"failures": s.failures,
+	}
+	if !s.fetchedAt.IsZero() {
+		stats["fetched_at"] = s.fetchedAt.Format(time.RFC3339)
+	}
+	if s.lastError != "" {
+		stats["last_error"] = s.lastError
+	}
+	return stats
+}
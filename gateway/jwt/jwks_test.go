@@ -0,0 +1,45 @@
+package jwt
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestJWKSUnknownKeyRefresh(t *testing.T) {
+	var fetches atomic.Int64
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		<-release
+		fmt.Fprint(w, `{"keys": [{"kty": "oct", "kid": "rotated", "k": "c2VjcmV0"}]}`)
+	}))
+	defer server.Close()
+	s := NewJWKS(server.URL, time.Hour)
+
+	// A caller that gives up leaves the refresh running for the others
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if keys := s.Keys(ctx, "rotated"); len(keys) != 0 {
+		t.Fatalf("got %d keys before the refresh finished", len(keys))
+	}
+	close(release)
+
+	deadline, stop := context.WithTimeout(context.Background(), 5*time.Second)
+	defer stop()
+	if keys := s.Keys(deadline, "rotated"); !has(keys, "rotated") {
+		t.Fatalf("rotated key not picked up, got %d keys", len(keys))
+	}
+
+	// Random key IDs don't refetch within minRefreshInterval
+	for i := 0; i < 10; i++ {
+		s.Keys(context.Background(), fmt.Sprintf("random-%d", i))
+	}
+	if n := fetches.Load(); n != 1 {
+		t.Errorf("key set fetched %d times, want 1", n)
+	}
+}
@@ -0,0 +1,265 @@
+package jwt
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Supported signing algorithms
+const (
+	RS256 = "RS256"
+	ES256 = "ES256"
+	HS256 = "HS256"
+)
+
+// Reasons a token is rejected. Verify wraps them with details.
+var (
+	ErrMalformed   = errors.New("malformed token")
+	ErrAlgorithm   = errors.New("unsupported algorithm")
+	ErrUnknownKey  = errors.New("no key for token")
+	ErrSignature   = errors.New("invalid signature")
+	ErrExpired     = errors.New("token expired")
+	ErrNotYetValid = errors.New("token not valid yet")
+	ErrIssuer      = errors.New("untrusted issuer")
+	ErrAudience    = errors.New("token not meant for this audience")
+)
+
+// reasons names each rejection in Stats
+var reasons = []struct {
+	err  error
+	name string
+}{
+	{ErrMalformed, "malformed"},
+	{ErrAlgorithm, "algorithm"},
+	{ErrUnknownKey, "unknown_key"},
+	{ErrSignature, "signature"},
+	{ErrExpired, "expired"},
+	{ErrNotYetValid, "not_yet_valid"},
+	{ErrIssuer, "issuer"},
+	{ErrAudience, "audience"},
+}
+
+// Options configures a Validator
+type Options struct {
+	Issuer      string        // required "iss" when set
+	Audiences   []string      // "aud" must contain one of them when set
+	Leeway      time.Duration // clock skew allowed on "exp" and "nbf"
+	Keys        []*Key        // static keys
+	JWKSURL     string        // key set fetched in the background when set
+	JWKSRefresh time.Duration
+}
+
+// Validator verifies signed tokens and their registered claims. Keys come
+// from static config, a JWKS endpoint, or both.
+type Validator struct {
+	opts Options
+	jwks *JWKS // nil without a JWKS URL
+
+	verified atomic.Int64
+	mu       sync.Mutex
+	rejected map[string]int64
+}
+
+// NewValidator creates a validator. Call Start to begin fetching the JWKS.
+func NewValidator(opts Options) *Validator {
+	v := &Validator{
+		opts:     opts,
+		rejected: make(map[string]int64),
+	}
+	if opts.JWKSURL != "" {
+		v.jwks = NewJWKS(opts.JWKSURL, opts.JWKSRefresh)
+	}
+	return v
+}
+
+// Start fetches the JWKS and keeps refreshing it until Stop
+func (v *Validator) Start() {
+	if v.jwks != nil {
+		v.jwks.Start()
+	}
+}
+
+// Stop stops refreshing the JWKS
+func (v *Validator) Stop() {
+	if v.jwks != nil {
+		v.jwks.Stop()
+	}
+}
+
+// header is the JOSE header of a token
+type header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// Verify checks a compact serialized token's signature, expiry, issuer and
+// audience, and returns its claims
+func (v *Validator) Verify(ctx context.Context, token string) (Claims, error) {
+	claims, err := v.verify(ctx, token)
+	if err != nil {
+		v.reject(err)
+		return nil, err
+	}
+	v.verified.Add(1)
+	return claims, nil
+}
+
+func (v *Validator) verify(ctx context.Context, token string) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: expected 3 parts, got %d", ErrMalformed, len(parts))
+	}
+
+	var h header
+	if err := decodeSegment(parts[0], &h); err != nil {
+		return nil, fmt.Errorf("%w: header: %v", ErrMalformed, err)
+	}
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("%w: claims: %v", ErrMalformed, err)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: signature: %v", ErrMalformed, err)
+	}
+
+	// The algorithm must be one the key was issued for, so a token can't
+	// pick a weaker one, such as HS256 with an RSA public key as secret
+	switch h.Alg {
+	case RS256, ES256, HS256:
+	default:
+		return nil, fmt.Errorf("%w %q", ErrAlgorithm, h.Alg)
+	}
+	keys := v.keys(ctx, h)
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%w: kid %q, alg %s", ErrUnknownKey, h.Kid, h.Alg)
+	}
+	signed := token[:len(parts[0])+1+len(parts[1])]
+	verified := false
+	for _, k := range keys {
+		if k.verify(signed, sig) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, ErrSignature
+	}
+
+	if err := v.checkClaims(claims, time.Now()); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// keys returns the keys that may have signed a token with header h: those
+// with its key ID, or every key for its algorithm when it has none
+func (v *Validator) keys(ctx context.Context, h header) []*Key {
+	var keys []*Key
+	for _, k := range v.opts.Keys {
+		if k.matches(h) {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) == 0 && v.jwks != nil {
+		for _, k := range v.jwks.Keys(ctx, h.Kid) {
+			if k.matches(h) {
+				keys = append(keys, k)
+			}
+		}
+	}
+	return keys
+}
+
+func (v *Validator) checkClaims(c Claims, now time.Time) error {
+	exp, ok, err := c.time("exp")
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("%w: no exp claim", ErrMalformed)
+	}
+	if now.After(exp.Add(v.opts.Leeway)) {
+		return fmt.Errorf("%w at %s", ErrExpired, exp.Format(time.RFC3339))
+	}
+
+	nbf, ok, err := c.time("nbf")
+	if err != nil {
+		return err
+	}
+	if ok && now.Add(v.opts.Leeway).Before(nbf) {
+		return fmt.Errorf("%w until %s", ErrNotYetValid, nbf.Format(time.RFC3339))
+	}
+
+	if v.opts.Issuer != "" {
+		if iss, _ := c["iss"].(string); iss != v.opts.Issuer {
+			return fmt.Errorf("%w %q", ErrIssuer, iss)
+		}
+	}
+
+	if len(v.opts.Audiences) > 0 {
+		aud := c.Values("aud")
+		for _, want := range v.opts.Audiences {
+			for _, got := range aud {
+				if got == want {
+					return nil
+				}
+			}
+		}
+		return fmt.Errorf("%w %v", ErrAudience, aud)
+	}
+	return nil
+}
+
+func (v *Validator) reject(err error) {
+	reason := "other"
+	for _, r := range reasons {
+		if errors.Is(err, r.err) {
+			reason = r.name
+			break
+		}
+	}
+
+	v.mu.Lock()
+	v.rejected[reason]++
+	v.mu.Unlock()
+}
+
+// Stats returns verification counts by outcome and the JWKS state
+func (v *Validator) Stats() map[string]interface{} {
+	v.mu.Lock()
+	rejected := make(map[string]int64, len(v.rejected))
+	for reason, n := range v.rejected {
+		rejected[reason] = n
+	}
+	v.mu.Unlock()
+
+	stats := map[string]interface{}{
+		"verified":    v.verified.Load(),
+		"rejected":    rejected,
+		"static_keys": len(v.opts.Keys),
+	}
+	if v.jwks != nil {
+		stats["jwks"] = v.jwks.Stats()
+	}
+	return stats
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	// Numbers are kept as written so large IDs forward unchanged
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	return d.Decode(v)
+}
@@ -0,0 +1,100 @@
+package jwt
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+// sign returns an HS256 token with header h and claims c
+func sign(t *testing.T, h map[string]interface{}, c Claims, secret string) string {
+	t.Helper()
+	enc := func(v interface{}) string {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+	signed := enc(h) + "." + enc(c)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(signed))
+	return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestVerify(t *testing.T) {
+	ec, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	esKey, err := newKey("es", ES256, &ec.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := NewValidator(Options{
+		Issuer:    "https://issuer",
+		Audiences: []string{"api"},
+		Leeway:    30 * time.Second,
+		Keys:      []*Key{NewHMACKey("hs", []byte("secret")), esKey},
+	})
+
+	now := time.Now().Unix()
+	valid := func() Claims {
+		return Claims{"iss": "https://issuer", "aud": "api", "exp": now + 60, "sub": "alice"}
+	}
+	with := func(k string, val interface{}) Claims {
+		c := valid()
+		if val == nil {
+			delete(c, k)
+		} else {
+			c[k] = val
+		}
+		return c
+	}
+	hs := map[string]interface{}{"alg": "HS256", "kid": "hs"}
+
+	tests := []struct {
+		name  string
+		token string
+		want  error
+	}{
+		{"valid", sign(t, hs, valid(), "secret"), nil},
+		{"no kid tries every key", sign(t, map[string]interface{}{"alg": "HS256"}, valid(), "secret"), nil},
+		{"audience list", sign(t, hs, with("aud", []string{"other", "api"}), "secret"), nil},
+		{"within leeway", sign(t, hs, with("exp", now-10), "secret"), nil},
+
+		{"alg none", sign(t, map[string]interface{}{"alg": "none", "kid": "hs"}, valid(), "secret"), ErrAlgorithm},
+		{"alg HS512", sign(t, map[string]interface{}{"alg": "HS512", "kid": "hs"}, valid(), "secret"), ErrAlgorithm},
+		{"alg of another key", sign(t, map[string]interface{}{"alg": "HS256", "kid": "es"}, valid(), "secret"), ErrUnknownKey},
+		{"unknown kid", sign(t, map[string]interface{}{"alg": "HS256", "kid": "nope"}, valid(), "secret"), ErrUnknownKey},
+		{"wrong secret", sign(t, hs, valid(), "guess"), ErrSignature},
+		{"expired", sign(t, hs, with("exp", now-60), "secret"), ErrExpired},
+		{"no exp", sign(t, hs, with("exp", nil), "secret"), ErrMalformed},
+		{"exp not a number", sign(t, hs, with("exp", "soon"), "secret"), ErrMalformed},
+		{"not yet valid", sign(t, hs, with("nbf", now+60), "secret"), ErrNotYetValid},
+		{"wrong issuer", sign(t, hs, with("iss", "https://evil"), "secret"), ErrIssuer},
+		{"wrong audience", sign(t, hs, with("aud", "other"), "secret"), ErrAudience},
+		{"no audience", sign(t, hs, with("aud", nil), "secret"), ErrAudience},
+		{"two parts", "a.b", ErrMalformed},
+		{"bad base64", "!!.!!.!!", ErrMalformed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := v.Verify(context.Background(), tt.token)
+			if tt.want == nil && err != nil {
+				t.Fatalf("Verify() error = %v, want none", err)
+			}
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("Verify() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
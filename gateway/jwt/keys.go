@@ -0,0 +1,183 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// Key verifies the signatures of one algorithm
+type Key struct {
+	ID  string // matches the token's "kid"; empty matches any
+	Alg string
+	key interface{} // *rsa.PublicKey, *ecdsa.PublicKey or []byte
+}
+
+// NewHMACKey creates an HS256 key from a shared secret
+func NewHMACKey(id string, secret []byte) *Key {
+	return &Key{ID: id, Alg: HS256, key: secret}
+}
+
+// ParsePublicKey parses a PEM encoded public key or certificate for alg,
+// RS256 or ES256
+func ParsePublicKey(id, alg string, data []byte) (*Key, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+
+	var pub interface{}
+	var err error
+	switch block.Type {
+	case "CERTIFICATE":
+		var cert *x509.Certificate
+		if cert, err = x509.ParseCertificate(block.Bytes); err == nil {
+			pub = cert.PublicKey
+		}
+	case "RSA PUBLIC KEY":
+		pub, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		pub, err = x509.ParsePKIXPublicKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newKey(id, alg, pub)
+}
+
+// newKey checks that pub suits alg
+func newKey(id, alg string, pub interface{}) (*Key, error) {
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		if alg != RS256 {
+			return nil, fmt.Errorf("RSA key cannot verify %s", alg)
+		}
+	case *ecdsa.PublicKey:
+		if alg != ES256 || k.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("ECDSA key on %s cannot verify %s", k.Curve.Params().Name, alg)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", pub)
+	}
+	return &Key{ID: id, Alg: alg, key: pub}, nil
+}
+
+// matches reports whether k may have signed a token with header h
+func (k *Key) matches(h header) bool {
+	return k.Alg == h.Alg && (k.ID == "" || h.Kid == "" || k.ID == h.Kid)
+}
+
+// verify checks sig over signed
+func (k *Key) verify(signed string, sig []byte) bool {
+	digest := sha256.Sum256([]byte(signed))
+	switch key := k.key.(type) {
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig) == nil
+	case *ecdsa.PublicKey:
+		// JWS encodes ES256 signatures as the fixed size r || s
+		if len(sig) != 64 {
+			return false
+		}
+		r := new(big.Int).SetBytes(sig[:32])
+		s := new(big.Int).SetBytes(sig[32:])
+		return ecdsa.Verify(key, digest[:], r, s)
+	case []byte:
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(signed))
+		return hmac.Equal(mac.Sum(nil), sig)
+	}
+	return false
+}
+
+// jwk is one key of a JSON Web Key Set (RFC 7517)
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	K   string `json:"k"`
+}
+
+// ParseJWKS parses a JSON Web Key Set. Encryption keys and keys of
+// unsupported types are skipped; their algorithm defaults from the key type.
+func ParseJWKS(data []byte) ([]*Key, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+
+	keys := make([]*Key, 0, len(set.Keys))
+	for _, j := range set.Keys {
+		if j.Use != "" && j.Use != "sig" {
+			continue
+		}
+		k, err := j.key()
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", j.Kid, err)
+		}
+		if k != nil {
+			keys = append(keys, k)
+		}
+	}
+	return keys, nil
+}
+
+// key converts j, or returns nil if it is not a supported signing key
+func (j *jwk) key() (*Key, error) {
+	b64 := base64.RawURLEncoding
+	switch {
+	case j.Kty == "RSA" && (j.Alg == "" || j.Alg == RS256):
+		n, err := b64.DecodeString(j.N)
+		if err != nil {
+			return nil, fmt.Errorf("n: %w", err)
+		}
+		e, err := b64.DecodeString(j.E)
+		if err != nil {
+			return nil, fmt.Errorf("e: %w", err)
+		}
+		exp := new(big.Int).SetBytes(e)
+		if !exp.IsInt64() || exp.Int64() > 1<<31-1 {
+			return nil, errors.New("e too large")
+		}
+		pub := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exp.Int64())}
+		return newKey(j.Kid, RS256, pub)
+	case j.Kty == "EC" && j.Crv == "P-256" && (j.Alg == "" || j.Alg == ES256):
+		x, err := b64.DecodeString(j.X)
+		if err != nil {
+			return nil, fmt.Errorf("x: %w", err)
+		}
+		y, err := b64.DecodeString(j.Y)
+		if err != nil {
+			return nil, fmt.Errorf("y: %w", err)
+		}
+		pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, errors.New("point not on curve")
+		}
+		return newKey(j.Kid, ES256, pub)
+	case j.Kty == "oct" && (j.Alg == "" || j.Alg == HS256):
+		secret, err := b64.DecodeString(j.K)
+		if err != nil {
+			return nil, fmt.Errorf("k: %w", err)
+		}
+		return NewHMACKey(j.Kid, secret), nil
+	}
+	return nil, nil
+}
@@ -2,20 +2,30 @@ package main
 
 import (
 	"context"
+	"crypto/subtle"
+	"crypto/tls"
 	"encoding/json"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
 	"gateway/cache"
+	"gateway/certs"
 	"gateway/circuitbreaker"
 	"gateway/config"
+	"gateway/healthcheck"
 	"gateway/metrics"
 	"gateway/proxy"
 	"gateway/ratelimit"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 )
 
 func main() {
@@ -27,11 +37,6 @@ func main() {
 
 	config.SetConfig(cfg)
 
-	// Watch for config changes
-	if err := config.WatchConfig("../config/gateway.json"); err != nil {
-		log.Printf("Warning: failed to watch config: %v", err)
-	}
-
 	// Initialize components
 	limiter := ratelimit.NewLimiter(
 		cfg.RateLimit.NumShards,
@@ -39,40 +44,68 @@ func main() {
 		cfg.RateLimit.BurstSize,
 	)
 
-	breaker := circuitbreaker.NewBreaker(
-		cfg.CircuitBreaker.FailureThreshold,
-		cfg.CircuitBreaker.SuccessThreshold,
-		cfg.CircuitBreaker.TimeoutSeconds,
-		cfg.CircuitBreaker.HealthDecay,
-	)
+	breakers := circuitbreaker.NewRegistry(cfg.CircuitBreaker.MaxBreakers)
 
-	c := cache.NewCache(1000, cfg.Cache.MaxSize)
+	c := cache.NewCache(cfg.Cache.MaxEntries, cfg.Cache.MaxSize)
 	
 	coalescer := proxy.NewCoalescer(60 * time.Second)
 	
 	collector := metrics.NewCollector()
 
+	// Log and count breaker transitions
+	breakers.Subscribe(func(key string, e circuitbreaker.Event) {
+		collector.RecordBreakerTransition(e.To.String())
+		if e.To == circuitbreaker.StateOpen {
+			log.Printf("Circuit breaker %s: %s -> %s (%s), retry at %s", key, e.From, e.To, e.Reason, e.OpenUntil.Format(time.RFC3339))
+		} else {
+			log.Printf("Circuit breaker %s: %s -> %s (%s)", key, e.From, e.To, e.Reason)
+		}
+	})
+
 	// Create proxy handler
-	proxyHandler := proxy.NewProxyHandler(cfg, limiter, breaker, c, coalescer, collector)
+	checker := healthcheck.NewChecker()
+	defer checker.Stop()
+
+	proxyHandler, err := proxy.NewProxyHandler(cfg, limiter, breakers, checker, c, coalescer, collector)
+	if err != nil {
+		log.Fatalf("Failed to build proxy: %v", err)
+	}
+
+	// Watch for config changes; accepted versions are swapped into the
+	// proxy handler atomically
+	config.OnReload(proxyHandler.Reload)
+	if err := config.WatchConfig("../config/gateway.json"); err != nil {
+		log.Printf("Warning: failed to watch config: %v", err)
+	}
+
+	certManager := certs.NewManager()
+	if cfg.TLS.Enabled {
+		if err := watchCertificates(cfg, certManager); err != nil {
+			log.Fatalf("Failed to load TLS certificates: %v", err)
+		}
+	}
 
 	// Setup server
 	mux := http.NewServeMux()
-	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-		healthHandler(breaker)(w, r)
-	})
-	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
-		metricsHandler(collector, breaker)(w, r)
-	})
+	mux.HandleFunc("/health", healthHandler(proxyHandler))
+	mux.HandleFunc("/health/upstreams", upstreamHealthHandler(proxyHandler))
+	mux.HandleFunc("/metrics", metricsHandler(collector, proxyHandler, certManager))
+	mux.HandleFunc("/debug/routes", routeDebugHandler(proxyHandler))
+	mux.HandleFunc("/admin/cache/purge", cachePurgeHandler(proxyHandler))
 	mux.Handle("/", proxyHandler)
 
-	server := &http.Server{
-		Addr:         cfg.ListenAddr,
-		Handler:      mux,
-		ReadTimeout:  time.Duration(cfg.Timeouts.ReadSeconds) * time.Second,
-		WriteTimeout: time.Duration(cfg.Timeouts.WriteSeconds) * time.Second,
-		IdleTimeout:  120 * time.Second,
+	// HTTP/2 is negotiated over TLS; h2c additionally serves it in
+	// cleartext, which gRPC clients commonly use inside a cluster
+	var handler http.Handler = mux
+	if cfg.TLS.Enabled {
+		handler = httpsRedirect(mux, cfg.TLS.ListenAddr)
+	}
+	if cfg.HTTP2.H2C {
+		handler = h2c.NewHandler(handler, &http2.Server{MaxConcurrentStreams: cfg.HTTP2.MaxConcurrentStreams})
 	}
 
+	server := newServer(cfg, cfg.ListenAddr, handler, nil)
+
 	// Start server in goroutine
 	go func() {
 		log.Printf("Starting gateway on %s", cfg.ListenAddr)
@@ -81,6 +114,18 @@ func main() {
 		}
 	}()
 
+	// The HTTPS listener serves the same routes alongside plain HTTP
+	var tlsServer *http.Server
+	if cfg.TLS.Enabled {
+		tlsServer = newServer(cfg, cfg.TLS.ListenAddr, mux, certManager.TLSConfig())
+		go func() {
+			log.Printf("Starting gateway TLS listener on %s", cfg.TLS.ListenAddr)
+			if err := tlsServer.ListenAndServeTLS("", ""); err != nil && err != http.ErrServerClosed {
+				log.Fatalf("TLS server failed: %v", err)
+			}
+		}()
+	}
+
 	// Graceful shutdown
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
@@ -92,37 +137,291 @@ func main() {
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
+	if tlsServer != nil {
+		go tlsServer.Shutdown(ctx)
+	}
 	if err := server.Shutdown(ctx); err != nil {
 		log.Fatalf("Server forced to shutdown: %v", err)
 	}
 
+	// Shutdown doesn't wait for hijacked connections, so upgraded tunnels
+	// get whatever is left of the grace period
+	proxyHandler.DrainTunnels(ctx)
+
 	log.Println("Server exited")
 }
 
-func healthHandler(breaker *circuitbreaker.Breaker) http.HandlerFunc {
+// newServer creates a server for handler on addr with HTTP/2 enabled. With
+// tlsConfig, HTTP/2 is offered during the TLS handshake.
+func newServer(cfg *config.Config, addr string, handler http.Handler, tlsConfig *tls.Config) *http.Server {
+	server := &http.Server{
+		Addr:         addr,
+		Handler:      handler,
+		TLSConfig:    tlsConfig,
+		ReadTimeout:  time.Duration(cfg.Timeouts.ReadSeconds) * time.Second,
+		WriteTimeout: time.Duration(cfg.Timeouts.WriteSeconds) * time.Second,
+		IdleTimeout:  120 * time.Second,
+	}
+	if err := http2.ConfigureServer(server, &http2.Server{MaxConcurrentStreams: cfg.HTTP2.MaxConcurrentStreams}); err != nil {
+		log.Fatalf("Failed to configure HTTP/2: %v", err)
+	}
+	return server
+}
+
+// watchCertificates loads cfg's certificates into m, reloads them whenever
+// their files change, and applies TLS changes from config reloads
+func watchCertificates(cfg *config.Config, m *certs.Manager) error {
+	pairs, policy, err := cfg.TLS.Compile()
+	if err != nil {
+		return err
+	}
+	if err := m.Configure(pairs, policy); err != nil {
+		return err
+	}
+
+	watcher, err := config.WatchFiles(m.Files(), func() { m.Reload() })
+	if err != nil {
+		return err
+	}
+
+	applied := cfg
+	config.OnReload(func(cfg *config.Config, version int64) {
+		if !config.Diff(applied, cfg).TLS || !cfg.TLS.Enabled {
+			return
+		}
+		pairs, policy, err := cfg.TLS.Compile()
+		if err == nil {
+			err = m.Configure(pairs, policy)
+		}
+		if err == nil {
+			err = watcher.SetFiles(m.Files())
+		}
+		if err != nil {
+			log.Printf("TLS settings of config version %d not applied: %v", version, err)
+			return
+		}
+		applied = cfg
+	})
+	return nil
+}
+
+// httpsRedirect answers plain HTTP requests for the hosts in
+// tls.redirect_hosts with a permanent redirect to the HTTPS listener on
+// tlsAddr, and passes everything else to next
+func httpsRedirect(next http.Handler, tlsAddr string) http.Handler {
+	_, port, _ := net.SplitHostPort(tlsAddr)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !config.GetConfig().TLS.Redirects(r.Host) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
+
+func healthHandler(p *proxy.ProxyHandler) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
+
+		// The gateway is only degraded when every known upstream is
+		// tripped; individual open circuits are reported per upstream
+		states := p.Breakers().States()
+		upstreams := make(map[string]string, len(states))
+		open := 0
+		for key, state := range states {
+			upstreams[key] = state.String()
+			if state == circuitbreaker.StateOpen {
+				open++
+			}
+		}
+		status := "healthy"
+		switch {
+		case open > 0 && open == len(states):
+			status = "degraded"
+		case open > 0:
+			status = "partial"
+		}
+
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":         status,
+			"upstreams":      upstreams,
+			"config_version": p.ConfigVersion(),
+			"timestamp":      time.Now().Unix(),
+		})
+	}
+}
+
+// upstreamHealthHandler reports the active health check results of every
+// probed target
+func upstreamHealthHandler(p *proxy.ProxyHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		checker := p.HealthChecker()
+		total, unhealthy := checker.Counts()
 		status := "healthy"
-		if breaker.GetState() == circuitbreaker.StateOpen {
+		switch {
+		case unhealthy > 0 && unhealthy == total:
 			status = "degraded"
+		case unhealthy > 0:
+			status = "partial"
 		}
 
+		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]interface{}{
 			"status":    status,
+			"upstreams": checker.Stats(),
 			"timestamp": time.Now().Unix(),
 		})
 	}
 }
 
-func metricsHandler(collector *metrics.Collector, breaker *circuitbreaker.Breaker) http.HandlerFunc {
+func metricsHandler(collector *metrics.Collector, p *proxy.ProxyHandler, certManager *certs.Manager) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		stats := collector.GetStats()
-		breakerStats := breaker.Stats()
+		breakerStats := p.Breakers().Stats()
 
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]interface{}{
-			"metrics": stats,
+			"metrics":         stats,
 			"circuit_breaker": breakerStats,
+			"cache":           p.Cache().Stats(),
+			"upstreams":       p.UpstreamStats(),
+			"retry_budget":    p.RetryBudget().Stats(),
+			"hedge_budget":    p.HedgeBudget().Stats(),
+			"tunnels":         p.TunnelStats(),
+			"tls":             certManager.Stats(),
+			"jwt":             p.JWTStats(),
+			"api_keys":        p.APIKeyStats(),
+			"config":          config.Stats(),
+			"config_version":  p.ConfigVersion(),
+		})
+	}
+}
+
+// routeDebugHandler reports which route a request would match and why. The
+// probed request is built from the "method", "path" and "host" query
+// parameters; headers and client address are taken from the debug request.
+// Unless debug_routes is set, requests are passed on to the proxy.
+func routeDebugHandler(p *proxy.ProxyHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !config.GetConfig().DebugRoutes {
+			p.ServeHTTP(w, r)
+			return
+		}
+		q := r.URL.Query()
+		target, err := url.ParseRequestURI(q.Get("path"))
+		if err != nil {
+			http.Error(w, "path query parameter must be an absolute request path", http.StatusBadRequest)
+			return
+		}
+
+		probe := r.Clone(r.Context())
+		probe.URL = target
+		probe.RequestURI = target.RequestURI()
+		probe.Method = http.MethodGet
+		if m := q.Get("method"); m != "" {
+			probe.Method = m
+		}
+		if h := q.Get("host"); h != "" {
+			probe.Host = h
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		match := p.Explain(probe)
+		if match == nil {
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"matched": false,
+			})
+			return
+		}
+
+		params := make(map[string]string, len(match.Params))
+		for _, param := range match.Params {
+			params[param.Key] = param.Value
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"matched": true,
+			"route":   match.Index,
+			"pattern": match.Pattern,
+			"params":  params,
+			"reasons": match.Reasons,
+		})
+	}
+}
+
+// cachePurgeHandler drops cached responses by URL, path prefix or
+// surrogate key, or with soft=true marks them stale. Unless purging is
+// enabled, requests are passed on to the proxy.
+func cachePurgeHandler(p *proxy.ProxyHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cfg := config.GetConfig().Cache
+		if !cfg.PurgeEnabled {
+			p.ServeHTTP(w, r)
+			return
+		}
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		scheme, token, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+		if !strings.EqualFold(scheme, "Bearer") || subtle.ConstantTimeCompare([]byte(token), []byte(cfg.PurgeToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "Invalid purge token", http.StatusUnauthorized)
+			return
+		}
+
+		q := r.URL.Query()
+		selectors := 0
+		for _, name := range []string{"url", "prefix", "tag"} {
+			if q.Has(name) {
+				selectors++
+			}
+		}
+		if selectors != 1 {
+			http.Error(w, "exactly one of the url, prefix or tag query parameters is required", http.StatusBadRequest)
+			return
+		}
+
+		c := p.Cache()
+		soft := q.Get("soft") == "true"
+		host := q.Get("host")
+		purged := 0
+		switch {
+		case q.Has("url"):
+			target, err := url.Parse(q.Get("url"))
+			if err != nil || !strings.HasPrefix(target.Path, "/") {
+				http.Error(w, "url must be an absolute URL or request path", http.StatusBadRequest)
+				return
+			}
+			if target.Host != "" {
+				host = target.Host
+			}
+			uri := target.Path
+			if target.RawQuery != "" {
+				uri += "?" + target.RawQuery
+			}
+			purged = c.PurgeURL(host, uri, soft)
+		case q.Has("prefix"):
+			purged = c.PurgePrefix(host, q.Get("prefix"), soft)
+		default:
+			for _, tag := range q["tag"] {
+				purged += c.PurgeTag(tag, soft)
+			}
+		}
+
+		log.Printf("Cache purge %s: %d responses (soft=%v)", r.URL.RawQuery, purged, soft)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"purged": purged,
+			"soft":   soft,
 		})
 	}
 }
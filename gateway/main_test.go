@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"gateway/config"
+)
+
+func TestHTTPSRedirect(t *testing.T) {
+	cfg := config.Default()
+	cfg.TLS.Enabled = true
+	cfg.TLS.RedirectHosts = []string{"shop.example", "*.api.example"}
+	config.SetConfig(cfg)
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	tests := []struct {
+		name     string
+		tlsAddr  string
+		host     string
+		uri      string
+		location string // empty when the request is served
+	}{
+		{"redirected", ":443", "shop.example", "/cart?id=1", "https://shop.example/cart?id=1"},
+		{"http port dropped", ":443", "shop.example:8080", "/", "https://shop.example/"},
+		{"case insensitive", ":443", "Shop.Example", "/", "https://Shop.Example/"},
+		{"https port kept", ":8443", "shop.example:8080", "/a", "https://shop.example:8443/a"},
+		{"wildcard", "0.0.0.0:443", "eu.api.example", "/", "https://eu.api.example/"},
+		{"wildcard needs a subdomain", ":443", "api.example", "/", ""},
+		{"other host", ":443", "other.example", "/", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tt.uri, nil)
+			r.Host = tt.host
+			w := httptest.NewRecorder()
+			httpsRedirect(next, tt.tlsAddr).ServeHTTP(w, r)
+
+			if tt.location == "" {
+				if w.Code != http.StatusTeapot {
+					t.Errorf("status = %d, want the request served", w.Code)
+				}
+				return
+			}
+			if w.Code != http.StatusPermanentRedirect || w.Header().Get("Location") != tt.location {
+				t.Errorf("got %d to %q, want %d to %q", w.Code, w.Header().Get("Location"), http.StatusPermanentRedirect, tt.location)
+			}
+		})
+	}
+
+	// Without TLS nothing is redirected
+	cfg = config.Default()
+	cfg.TLS.RedirectHosts = []string{"*"}
+	config.SetConfig(cfg)
+	w := httptest.NewRecorder()
+	httpsRedirect(next, ":443").ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusTeapot {
+		t.Errorf("TLS disabled: status = %d, want the request served", w.Code)
+	}
+}
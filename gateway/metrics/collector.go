@@ -1,6 +1,7 @@
 package metrics
 
 import (
+	"sort"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -15,6 +16,15 @@ type Collector struct {
 	cacheMisses     atomic.Int64
 	rateLimitHits   atomic.Int64
 	
+	// Circuit breaker transitions by target state
+	breakerOpened   atomic.Int64
+	breakerHalfOpen atomic.Int64
+	breakerClosed   atomic.Int64
+	
+	// Hedged requests sent, and how many of them answered first
+	hedges          atomic.Int64
+	hedgeWins       atomic.Int64
+	
 	// Latency metrics (in microseconds)
 	latencySum      atomic.Int64
 	latencyCount    atomic.Int64
@@ -37,6 +47,8 @@ type Collector struct {
 	// Histograms
 	mu                sync.RWMutex
 	latencyHistogram  []int64 // Buckets: 0-1ms, 1-5ms, 5-10ms, 10-50ms, 50-100ms, 100ms+
+	grpcStatus        map[string]int64 // gRPC calls by status code name
+	apiKeys           map[string]*KeyMetrics // by API key ID
 	
 	// Route-specific metrics
 	routeMetrics     map[string]*RouteMetrics
@@ -49,13 +61,43 @@ type RouteMetrics struct {
 	Errors      int64
 	AvgLatency  float64
 	LatencySum  int64
+	
+	// Ring of recent upstream attempt latencies for percentiles, and the
+	// cached result
+	recent      []int64
+	next        int
+	percentiles map[float64]cachedPercentile
 }
 
+// KeyMetrics tracks the requests made with one API key
+type KeyMetrics struct {
+	Requests    int64
+	RateLimited int64
+	Status4xx   int64
+	Status5xx   int64
+}
+
+type cachedPercentile struct {
+	value    time.Duration
+	computed time.Time
+}
+
+const (
+	// recentLatencies is how many recent latencies per route feed percentiles
+	recentLatencies = 512
+	// minPercentileSamples is the fewest samples a percentile is derived from
+	minPercentileSamples = 20
+	// percentileTTL is how long a computed percentile is reused
+	percentileTTL = time.Second
+)
+
 // NewCollector creates a new metrics collector
 func NewCollector() *Collector {
 	return &Collector{
 		startTime:         time.Now(),
 		latencyHistogram:  make([]int64, 6),
+		grpcStatus:        make(map[string]int64),
+		apiKeys:           make(map[string]*KeyMetrics),
 		routeMetrics:      make(map[string]*RouteMetrics),
 	}
 }
@@ -109,6 +151,87 @@ func (c *Collector) RecordCacheEvent(isHit bool) {
 	}
 }
 
+// RecordHedge records a hedged request being sent
+func (c *Collector) RecordHedge() {
+	c.hedges.Add(1)
+}
+
+// RecordHedgeWin records a hedged request answering before the original
+func (c *Collector) RecordHedgeWin() {
+	c.hedgeWins.Add(1)
+}
+
+// LatencyPercentile returns the q-th percentile (0-100) of the route's
+// recent upstream attempt latencies, or false until enough attempts have
+// been seen
+func (c *Collector) LatencyPercentile(route string, q float64) (time.Duration, bool) {
+	c.routeMetricsMu.Lock()
+	defer c.routeMetricsMu.Unlock()
+	
+	rm, exists := c.routeMetrics[route]
+	if !exists || len(rm.recent) < minPercentileSamples {
+		return 0, false
+	}
+	if p, ok := rm.percentiles[q]; ok && time.Since(p.computed) < percentileTTL {
+		return p.value, true
+	}
+	
+	sorted := append([]int64(nil), rm.recent...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	i := int(q / 100 * float64(len(sorted)-1))
+	value := time.Duration(sorted[i]) * time.Microsecond
+	
+	if rm.percentiles == nil {
+		rm.percentiles = make(map[float64]cachedPercentile)
+	}
+	rm.percentiles[q] = cachedPercentile{value: value, computed: time.Now()}
+	return value, true
+}
+
+// RecordGRPCStatus records a gRPC call ending with the named status code
+func (c *Collector) RecordGRPCStatus(code string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	
+	c.grpcStatus[code]++
+}
+
+// RecordAPIKey records a request made with an API key. limited marks
+// requests the gateway's rate limiter rejected, as opposed to 429s from
+// the upstream.
+func (c *Collector) RecordAPIKey(id string, statusCode int, limited bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	
+	km, exists := c.apiKeys[id]
+	if !exists {
+		km = &KeyMetrics{}
+		c.apiKeys[id] = km
+	}
+	km.Requests++
+	if limited {
+		km.RateLimited++
+	}
+	switch {
+	case statusCode >= 400 && statusCode < 500:
+		km.Status4xx++
+	case statusCode >= 500:
+		km.Status5xx++
+	}
+}
+
+// RecordBreakerTransition records a circuit breaker entering state to
+func (c *Collector) RecordBreakerTransition(to string) {
+	switch to {
+	case "open":
+		c.breakerOpened.Add(1)
+	case "half-open":
+		c.breakerHalfOpen.Add(1)
+	case "closed":
+		c.breakerClosed.Add(1)
+	}
+}
+
 func (c *Collector) updateLatencyMetrics(latencyMicros int64) {
 	c.latencySum.Add(latencyMicros)
 	c.latencyCount.Add(1)
@@ -163,6 +286,28 @@ func (c *Collector) updateRouteMetrics(route string, latencyMicros int64) {
 	rm.AvgLatency = float64(rm.LatencySum) / float64(rm.Requests)
 }
 
+// RecordUpstreamLatency records how long one upstream attempt of the route
+// took to answer. Only these feed LatencyPercentile, so cache hits and
+// requests the gateway rejects don't skew it.
+func (c *Collector) RecordUpstreamLatency(route string, latency time.Duration) {
+	c.routeMetricsMu.Lock()
+	defer c.routeMetricsMu.Unlock()
+	
+	rm, exists := c.routeMetrics[route]
+	if !exists {
+		rm = &RouteMetrics{}
+		c.routeMetrics[route] = rm
+	}
+	
+	latencyMicros := latency.Microseconds()
+	if len(rm.recent) < recentLatencies {
+		rm.recent = append(rm.recent, latencyMicros)
+	} else {
+		rm.recent[rm.next] = latencyMicros
+		rm.next = (rm.next + 1) % recentLatencies
+	}
+}
+
 // GetStats returns current statistics
 func (c *Collector) GetStats() map[string]interface{} {
 	c.mu.RLock()
@@ -185,6 +330,21 @@ func (c *Collector) GetStats() map[string]interface{} {
 	
 	uptime := time.Since(c.startTime).Seconds()
 	
+	grpcStatus := make(map[string]int64, len(c.grpcStatus))
+	for code, n := range c.grpcStatus {
+		grpcStatus[code] = n
+	}
+	
+	apiKeys := make(map[string]interface{}, len(c.apiKeys))
+	for id, km := range c.apiKeys {
+		apiKeys[id] = map[string]int64{
+			"requests":     km.Requests,
+			"rate_limited": km.RateLimited,
+			"4xx":          km.Status4xx,
+			"5xx":          km.Status5xx,
+		}
+	}
+	
 	return map[string]interface{}{
 		"uptime_seconds": uptime,
 		"total_requests": total,
@@ -211,6 +371,17 @@ func (c *Collector) GetStats() map[string]interface{} {
 			"4xx": c.status4xx.Load(),
 			"5xx": c.status5xx.Load(),
 		},
+		"breaker_transitions": map[string]int64{
+			"open":      c.breakerOpened.Load(),
+			"half-open": c.breakerHalfOpen.Load(),
+			"closed":    c.breakerClosed.Load(),
+		},
+		"hedges": map[string]int64{
+			"sent": c.hedges.Load(),
+			"won":  c.hedgeWins.Load(),
+		},
+		"grpc_status": grpcStatus,
+		"api_keys":    apiKeys,
 		"current_rps": c.currentRPS.Load(),
 		"peak_rps":    c.peakRPS.Load(),
 	}
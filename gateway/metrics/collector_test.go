@@ -0,0 +1,30 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLatencyPercentileUsesUpstreamAttempts(t *testing.T) {
+	c := NewCollector()
+
+	// Fast answers the gateway gives itself don't count
+	for i := 0; i < 100; i++ {
+		c.RecordRequest("/api", time.Microsecond, 200, true)
+		c.RecordRequest("/api", time.Microsecond, 429, false)
+	}
+	if _, ok := c.LatencyPercentile("/api", 95); ok {
+		t.Fatal("percentile derived from requests that never reached the upstream")
+	}
+
+	for i := 1; i <= minPercentileSamples; i++ {
+		c.RecordUpstreamLatency("/api", time.Duration(i)*time.Millisecond)
+	}
+	got, ok := c.LatencyPercentile("/api", 50)
+	if !ok {
+		t.Fatal("no percentile after enough upstream attempts")
+	}
+	if want := 10 * time.Millisecond; got != want {
+		t.Errorf("p50 = %v, want %v", got, want)
+	}
+}
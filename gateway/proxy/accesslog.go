@@ -0,0 +1,93 @@
+package proxy
+
+import (
+	"bufio"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"gateway/apikey"
+)
+
+// responseLog records what was sent to the client, and who it was, for
+// per-key metrics and the access log
+type responseLog struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+
+	route   string
+	client  string      // the identity the request is rate limited by
+	key     *apikey.Key // nil unless the request presented a valid API key
+	limited bool        // rejected by the gateway's rate limiter
+}
+
+func (l *responseLog) WriteHeader(code int) {
+	// Informational responses precede the final one
+	if l.status == 0 && code >= 200 {
+		l.status = code
+	}
+	l.ResponseWriter.WriteHeader(code)
+}
+
+func (l *responseLog) Write(p []byte) (int, error) {
+	if l.status == 0 {
+		l.status = http.StatusOK
+	}
+	n, err := l.ResponseWriter.Write(p)
+	l.bytes += int64(n)
+	return n, err
+}
+
+// Flush sends buffered data to the client, for streamed responses
+func (l *responseLog) Flush() {
+	http.NewResponseController(l.ResponseWriter).Flush()
+}
+
+// Hijack takes over the connection, for protocol upgrades. The tunnel's
+// own bytes are not counted.
+func (l *responseLog) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := http.NewResponseController(l.ResponseWriter).Hijack()
+	if err == nil && l.status == 0 {
+		l.status = http.StatusSwitchingProtocols
+	}
+	return conn, brw, err
+}
+
+// Unwrap lets http.ResponseController reach the server's writer
+func (l *responseLog) Unwrap() http.ResponseWriter {
+	return l.ResponseWriter
+}
+
+// logRequest records a finished request against its API key and writes
+// the access log line. The query string is left out since it may carry
+// credentials.
+func (p *ProxyHandler) logRequest(s *handlerState, l *responseLog, r *http.Request, start time.Time) {
+	status := l.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	if l.key != nil {
+		p.collector.RecordAPIKey(l.key.ID, status, l.limited)
+	}
+	if !s.cfg.AccessLog {
+		return
+	}
+
+	client := l.client
+	if client == "" {
+		client = p.getClientKey(s, r)
+	}
+	route := l.route
+	if route == "" {
+		route = "-"
+	}
+	if l.key != nil {
+		log.Printf("access client=%q owner=%q plan=%q route=%s method=%s path=%q status=%d bytes=%d duration=%s",
+			client, l.key.Owner, l.key.Plan, route, r.Method, r.URL.Path, status, l.bytes, time.Since(start))
+		return
+	}
+	log.Printf("access client=%q route=%s method=%s path=%q status=%d bytes=%d duration=%s",
+		client, route, r.Method, r.URL.Path, status, l.bytes, time.Since(start))
+}
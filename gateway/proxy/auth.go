@@ -0,0 +1,129 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"gateway/apikey"
+	"gateway/config"
+	"gateway/jwt"
+)
+
+// authenticate verifies the bearer token of a request to a route that
+// requires one and returns the request carrying its claims. Requests that
+// fail are answered with 401 or 403 and nil is returned.
+func (p *ProxyHandler) authenticate(w http.ResponseWriter, r *http.Request, s *handlerState,
+	route *route, start time.Time) *http.Request {
+
+	token, ok := bearerToken(r)
+	if !ok {
+		// RFC 6750: a request without credentials gets no error code
+		w.Header().Set("WWW-Authenticate", `Bearer realm="gateway"`)
+		http.Error(w, "Missing bearer token", http.StatusUnauthorized)
+		p.collector.RecordRequest(route.Path, time.Since(start), http.StatusUnauthorized, false)
+		return nil
+	}
+
+	claims, err := s.jwt.Verify(r.Context(), token)
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="gateway", error="invalid_token", error_description=%q`, err.Error()))
+		http.Error(w, "Invalid bearer token", http.StatusUnauthorized)
+		p.collector.RecordRequest(route.Path, time.Since(start), http.StatusUnauthorized, false)
+		return nil
+	}
+
+	if err := route.auth.Check(claims); err != nil {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="gateway", error="insufficient_scope", scope=%q`, strings.Join(route.auth.Scopes, " ")))
+		http.Error(w, err.Error(), http.StatusForbidden)
+		p.collector.RecordRequest(route.Path, time.Since(start), http.StatusForbidden, false)
+		return nil
+	}
+
+	forwardClaims(r, &s.cfg.JWT, claims)
+	return r.WithContext(jwt.WithClaims(r.Context(), claims))
+}
+
+// authenticateKey looks up the API key of a request to a route that
+// requires one and returns the request carrying it. The key is removed
+// from the request so it never reaches the backend. Requests without a
+// valid key allowed on the route are answered with 401 or 403 and nil is
+// returned.
+func (p *ProxyHandler) authenticateKey(w http.ResponseWriter, r *http.Request, s *handlerState,
+	route *route, start time.Time) *http.Request {
+
+	cfg := &s.cfg.APIKeys
+	raw, r := takeAPIKey(r, cfg)
+	if raw == "" {
+		http.Error(w, "Missing API key", http.StatusUnauthorized)
+		p.collector.RecordRequest(route.Path, time.Since(start), http.StatusUnauthorized, false)
+		return nil
+	}
+
+	key, err := cfg.Store().Lookup(raw, time.Now())
+	if err != nil {
+		http.Error(w, "Invalid API key: "+err.Error(), http.StatusUnauthorized)
+		p.collector.RecordRequest(route.Path, time.Since(start), http.StatusUnauthorized, false)
+		return nil
+	}
+
+	if !key.Allows(route.Path) {
+		http.Error(w, "API key not allowed on this route", http.StatusForbidden)
+		p.collector.RecordRequest(route.Path, time.Since(start), http.StatusForbidden, false)
+		return nil
+	}
+	return r.WithContext(apikey.WithKey(r.Context(), key))
+}
+
+// takeAPIKey returns the key in r's header, or else its query parameter,
+// and r without either
+func takeAPIKey(r *http.Request, cfg *config.APIKeysConfig) (string, *http.Request) {
+	var raw string
+	if cfg.Header != "" {
+		raw = strings.TrimSpace(r.Header.Get(cfg.Header))
+		r.Header.Del(cfg.Header)
+	}
+
+	if cfg.QueryParam != "" {
+		query := r.URL.Query()
+		if _, ok := query[cfg.QueryParam]; ok {
+			if raw == "" {
+				raw = query.Get(cfg.QueryParam)
+			}
+			query.Del(cfg.QueryParam)
+			u := *r.URL
+			u.RawQuery = query.Encode()
+			r = r.WithContext(r.Context())
+			r.URL = &u
+		}
+	}
+	return raw, r
+}
+
+// bearerToken returns the token in r's Authorization header
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+// stripClaimHeaders removes the headers claims are forwarded in, so only
+// the gateway can set them
+func stripClaimHeaders(r *http.Request, cfg *config.JWTConfig) {
+	for _, header := range cfg.ForwardClaims {
+		r.Header.Del(header)
+	}
+}
+
+// forwardClaims copies the configured claims into request headers
+func forwardClaims(r *http.Request, cfg *config.JWTConfig, claims jwt.Claims) {
+	for claim, header := range cfg.ForwardClaims {
+		if v := claims.String(claim); v != "" {
+			r.Header.Set(header, v)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gateway/apikey"
+	"gateway/config"
+)
+
+func TestTakeAPIKey(t *testing.T) {
+	both := &config.APIKeysConfig{Header: "X-API-Key", QueryParam: "api_key"}
+	tests := []struct {
+		name    string
+		cfg     *config.APIKeysConfig
+		header  string
+		uri     string
+		want    string
+		wantURI string
+	}{
+		{"header", both, "k1", "/a?x=1", "k1", "/a?x=1"},
+		{"header trimmed", both, "  k1 ", "/a", "k1", "/a"},
+		{"query", both, "", "/a?api_key=k2&x=1", "k2", "/a?x=1"},
+		{"header wins, both removed", both, "k1", "/a?api_key=k2", "k1", "/a"},
+		{"empty query value", both, "", "/a?api_key=", "", "/a"},
+		{"header only", &config.APIKeysConfig{Header: "X-API-Key"}, "", "/a?api_key=k2", "", "/a?api_key=k2"},
+		{"query only", &config.APIKeysConfig{QueryParam: "api_key"}, "k1", "/a?api_key=k2", "k2", "/a"},
+		{"none", both, "", "/a?x=1", "", "/a?x=1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tt.uri, nil)
+			if tt.header != "" {
+				r.Header.Set("X-API-Key", tt.header)
+			}
+			raw, out := takeAPIKey(r, tt.cfg)
+			if raw != tt.want {
+				t.Errorf("key = %q, want %q", raw, tt.want)
+			}
+			if got := out.URL.RequestURI(); got != tt.wantURI {
+				t.Errorf("URI = %q, want %q", got, tt.wantURI)
+			}
+			if tt.cfg.Header != "" && out.Header.Get(tt.cfg.Header) != "" {
+				t.Error("key header left on the request")
+			}
+			if r.URL.RequestURI() != tt.uri {
+				t.Errorf("original request URI changed to %q", r.URL.RequestURI())
+			}
+		})
+	}
+}
+
+func TestAPIKeyAuth(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s %s", r.Header.Get("X-API-Key"), r.URL.RawQuery)
+	}))
+	defer backend.Close()
+
+	keys := filepath.Join(t.TempDir(), "keys.json")
+	os.WriteFile(keys, []byte(fmt.Sprintf(`{"keys": [
+		{"id": "all", "hash": %q},
+		{"id": "reports", "hash": %q, "routes": ["/reports"]},
+		{"id": "old", "hash": %q, "expires_at": "2000-01-01T00:00:00Z"}
+	]}`, apikey.Hash("all-key"), apikey.Hash("reports-key"), apikey.Hash("old-key"))), 0o600)
+	p := newTestProxy(t, fmt.Sprintf(`{
+		"rate_limit": {"enabled": false},
+		"api_keys": {"file": %q, "header": "X-API-Key", "query_param": "api_key"},
+		"routes": [
+			{"path": "/orders", "backend": %q, "methods": ["GET"], "api_key": true},
+			{"path": "/reports", "backend": %q, "methods": ["GET"], "api_key": true}
+		]
+	}`, keys, backend.URL, backend.URL))
+
+	tests := []struct {
+		name   string
+		uri    string
+		key    string // header
+		status int
+		body   string
+	}{
+		{"header key", "/orders?x=1", "all-key", 200, " x=1"},
+		{"query key", "/orders?api_key=all-key&x=1", "", 200, " x=1"},
+		{"missing", "/orders", "", 401, ""},
+		{"unknown", "/orders", "nope", 401, ""},
+		{"expired", "/orders", "old-key", 401, ""},
+		{"route not allowed", "/orders", "reports-key", 403, ""},
+		{"route allowed", "/reports", "reports-key", 200, " "},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tt.uri, nil)
+			if tt.key != "" {
+				r.Header.Set("X-API-Key", tt.key)
+			}
+			w := httptest.NewRecorder()
+			p.ServeHTTP(w, r)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if tt.status == 200 && w.Body.String() != tt.body {
+				t.Errorf("backend saw %q, want %q", w.Body.String(), tt.body)
+			}
+		})
+	}
+}
@@ -0,0 +1,172 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"gateway/apikey"
+	"gateway/cache"
+)
+
+// usesCache reports whether r may be answered from, and stored in, the
+// cache. Requests carrying credentials only use it on routes that allow
+// it, so personalized responses don't leak between clients.
+func (s *handlerState) usesCache(r *http.Request, route *route) bool {
+	if !route.EnableCache || !s.cfg.Cache.Enabled {
+		return false
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	return route.CacheAuthenticated || !authenticated(s, r)
+}
+
+// authenticated reports whether r carries credentials of any kind the
+// gateway knows about
+func authenticated(s *handlerState, r *http.Request) bool {
+	return credentials(s, r) != ""
+}
+
+// credentials returns the Authorization header, API key and client
+// certificate identity of r, quoted together, or "" when it has none
+func credentials(s *handlerState, r *http.Request) string {
+	var key, cert string
+	if k := apikey.FromContext(r.Context()); k != nil {
+		key = k.ID
+	}
+	if auth := s.cfg.TLS.ClientAuth; auth != nil {
+		cert = r.Header.Get(auth.IdentityHeader)
+	}
+	authorization := r.Header.Get("Authorization")
+	if authorization == "" && key == "" && cert == "" {
+		return ""
+	}
+	return strconv.Quote(authorization) + strconv.Quote(key) + strconv.Quote(cert)
+}
+
+// writeCached answers r with a stored response that is age seconds old.
+// status is reported in X-Cache: HIT, STALE or REVALIDATED. The client's
+// preconditions and ranges are evaluated against a stored 200 response, so
+// it may get a 304, 206, 412 or 416 instead.
+func writeCached(w http.ResponseWriter, r *http.Request, resp *cache.Response, age int64, status string) {
+	for k, v := range resp.Headers {
+		for _, val := range v {
+			w.Header().Add(k, val)
+		}
+	}
+	stripSurrogateKeys(w.Header())
+	if w.Header().Get("ETag") == "" && resp.ETag != "" {
+		w.Header().Set("ETag", resp.ETag)
+	}
+	w.Header().Set("Age", strconv.FormatInt(age, 10))
+	w.Header().Set("X-Cache", status)
+
+	if resp.StatusCode != http.StatusOK {
+		w.WriteHeader(resp.StatusCode)
+		if r.Method != http.MethodHead {
+			w.Write(resp.Body)
+		}
+		return
+	}
+
+	// ServeContent sets the length of what it sends, and must not guess a
+	// content type the upstream didn't send
+	w.Header().Del("Content-Length")
+	if _, ok := resp.Headers["Content-Type"]; !ok {
+		w.Header()["Content-Type"] = nil
+	}
+	modified, _ := http.ParseTime(w.Header().Get("Last-Modified"))
+	http.ServeContent(w, r, "", modified, bytes.NewReader(resp.Body))
+}
+
+// stripSurrogateKeys removes the headers backends tag responses with for
+// purging, which are meant for the gateway rather than clients
+func stripSurrogateKeys(h http.Header) {
+	h.Del("Surrogate-Key")
+	h.Del("Cache-Tag")
+}
+
+// serveStale answers r with stale in place of a failed revalidation, if
+// its stale-if-error window allows
+func (p *ProxyHandler) serveStale(w http.ResponseWriter, r *http.Request, route *route, stale *cache.Response, start time.Time) bool {
+	now := time.Now().Unix()
+	if stale == nil || !stale.UsableOnError(now) {
+		return false
+	}
+	writeCached(w, r, stale, stale.Age(now), "STALE")
+	p.collector.RecordRequest(route.Path, time.Since(start), stale.StatusCode, true)
+	return true
+}
+
+// cachePolicy returns the defaults for responses stored under s
+func (s *handlerState) cachePolicy() cache.Policy {
+	c := &s.cfg.Cache
+	return cache.Policy{
+		TTL:                  time.Duration(c.TTLSeconds) * time.Second,
+		Grace:                time.Duration(c.GraceSeconds) * time.Second,
+		StaleWhileRevalidate: time.Duration(c.StaleWhileRevalidateSeconds) * time.Second,
+		StaleIfError:         time.Duration(c.StaleIfErrorSeconds) * time.Second,
+	}
+}
+
+// storeResponse caches a buffered upstream response to r if its headers
+// allow it
+func (s *handlerState) storeResponse(r *http.Request, resp *Response) {
+	s.cache.Store(r, &cache.Response{
+		StatusCode: resp.StatusCode,
+		Headers:    resp.Headers,
+		Body:       resp.Body,
+	}, s.cachePolicy(), authenticated(s, r))
+}
+
+// conditional returns a GET request revalidating stored, the response
+// to r
+func conditional(r *http.Request, stored *cache.Response) *http.Request {
+	c := r.Clone(r.Context())
+	c.Method = http.MethodGet
+	stored.SetConditions(c.Header)
+	return c
+}
+
+// revalidate refreshes stored, the response to r, in the background while
+// it is served stale. A response has one revalidation in flight at most.
+func (p *ProxyHandler) revalidate(s *handlerState, r *http.Request, route *route, stored *cache.Response) {
+	if _, busy := p.revalidating.LoadOrStore(stored, struct{}{}); busy {
+		return
+	}
+	// The client may be gone before the upstream answers
+	req := conditional(r.WithContext(context.WithoutCancel(r.Context())), stored)
+
+	go func() {
+		defer p.revalidating.Delete(stored)
+
+		resp, err := p.forwardRequest(s, req, route)
+		if err != nil {
+			log.Printf("Revalidating %s failed: %v", req.URL.Path, err)
+			return
+		}
+		switch {
+		case resp.StatusCode == http.StatusNotModified:
+			resp.Body.Close()
+			s.cache.Refresh(req, stored, resp.Header, s.cachePolicy(), authenticated(s, req))
+			return
+		case resp.StatusCode >= 500:
+			// A failed revalidation leaves the stored response to be
+			// served until its stale window closes
+			resp.Body.Close()
+			return
+		}
+		buffered, ok, err := bufferResponse(resp, s.cfg.Cache.MaxObjectBytes)
+		if !ok {
+			if err == nil {
+				resp.Body.Close()
+			}
+			return
+		}
+		s.storeResponse(req, buffered)
+	}()
+}
@@ -0,0 +1,72 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"gateway/cache"
+)
+
+func TestWriteCached(t *testing.T) {
+	modified := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	ok := &cache.Response{
+		StatusCode: http.StatusOK,
+		Headers: http.Header{
+			"Content-Type":  {"text/plain"},
+			"Last-Modified": {modified.Format(http.TimeFormat)},
+			"Surrogate-Key": {"a b"},
+		},
+		Body: []byte("hello"),
+		ETag: `"v1"`,
+	}
+	missing := &cache.Response{StatusCode: http.StatusNotFound, Body: []byte("gone"), ETag: `"v1"`}
+
+	tests := []struct {
+		name   string
+		method string
+		header http.Header
+		resp   *cache.Response
+		status int
+		body   string
+	}{
+		{"hit", "GET", nil, ok, 200, "hello"},
+		{"head", "HEAD", nil, ok, 200, ""},
+		{"if-none-match", "GET", http.Header{"If-None-Match": {`"v0", "v1"`}}, ok, 304, ""},
+		{"if-none-match any", "GET", http.Header{"If-None-Match": {"*"}}, ok, 304, ""},
+		{"if-none-match changed", "GET", http.Header{"If-None-Match": {`"v0"`}}, ok, 200, "hello"},
+		{"if-modified-since", "GET", http.Header{"If-Modified-Since": {modified.Format(http.TimeFormat)}}, ok, 304, ""},
+		{"modified since", "GET", http.Header{"If-Modified-Since": {modified.Add(-time.Hour).Format(http.TimeFormat)}}, ok, 200, "hello"},
+		{"if-match failed", "GET", http.Header{"If-Match": {`"v0"`}}, ok, 412, ""},
+		{"range", "GET", http.Header{"Range": {"bytes=1-3"}}, ok, 206, "ell"},
+		{"if-range changed", "GET", http.Header{"Range": {"bytes=1-3"}, "If-Range": {`"v0"`}}, ok, 200, "hello"},
+		{"unsatisfiable range", "GET", http.Header{"Range": {"bytes=10-20"}}, ok, 416, ""},
+		{"other status as is", "GET", http.Header{"If-None-Match": {`"v1"`}, "Range": {"bytes=0-1"}}, missing, 404, "gone"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/x", nil)
+			for k, v := range tt.header {
+				r.Header[k] = v
+			}
+			w := httptest.NewRecorder()
+			writeCached(w, r, tt.resp, 7, "HIT")
+
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d", w.Code, tt.status)
+			}
+			// The 416 body is net/http's own message
+			if tt.status != 416 && w.Body.String() != tt.body {
+				t.Errorf("body = %q, want %q", w.Body.String(), tt.body)
+			}
+			h := w.Header()
+			if h.Get("Age") != "7" || h.Get("X-Cache") != "HIT" || h.Get("ETag") != `"v1"` {
+				t.Errorf("Age %q, X-Cache %q, ETag %q", h.Get("Age"), h.Get("X-Cache"), h.Get("ETag"))
+			}
+			if h.Get("Surrogate-Key") != "" {
+				t.Error("surrogate keys sent to the client")
+			}
+		})
+	}
+}
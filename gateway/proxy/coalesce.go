@@ -1,6 +1,9 @@
 package proxy
 
 import (
+	"net/http"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
@@ -26,13 +29,44 @@ type Response struct {
 	Headers    map[string][]string
 	Body       []byte
 	Err        error
+
+	// Streamed responses were too large to share: only the request that
+	// fetched them holds the body, and the others must fetch their own
+	Streamed bool
 }
 
-// NewCoalescer creates a new request coalescer
+// NewCoalescer creates a new request coalescer. ttl bounds how long a
+// request waits for the in-flight response it joined.
 func NewCoalescer(ttl time.Duration) *Coalescer {
 	return &Coalescer{ttl: ttl}
 }
 
+// coalesceHeaders are the request headers that can change the response to
+// an otherwise identical request: content negotiation, which a Vary
+// response names, and the client's conditions and ranges
+var coalesceHeaders = []string{
+	"Accept", "Accept-Encoding", "Accept-Language", "Accept-Charset", "Cookie",
+	"If-None-Match", "If-Modified-Since", "Range", "If-Range",
+}
+
+// coalesceKey returns the key identical requests share a response under.
+// credentials identify the client, since requests with different ones
+// may get different responses too.
+func coalesceKey(r *http.Request, credentials string) string {
+	var b strings.Builder
+	b.WriteString(r.Method)
+	b.WriteByte(' ')
+	b.WriteString(r.Host)
+	b.WriteString(r.URL.RequestURI())
+	b.WriteByte(' ')
+	b.WriteString(credentials)
+	for _, name := range coalesceHeaders {
+		b.WriteByte(' ')
+		b.WriteString(strconv.Quote(strings.Join(r.Header.Values(name), ",")))
+	}
+	return b.String()
+}
+
 // Do coalesces requests with the same key
 func (c *Coalescer) Do(key string, fn func() (*Response, error)) (*Response, error) {
 	group, _ := c.groups.LoadOrStore(key, &CoalesceGroup{
@@ -59,6 +93,10 @@ func (c *Coalescer) Do(key string, fn func() (*Response, error)) (*Response, err
 		// Execute the function
 		resp, err := fn()
 
+		// Later requests start a new group: a response is only shared with
+		// requests that arrived while it was in flight
+		c.groups.Delete(key)
+
 		// Notify all waiters
 		g.mu.Lock()
 		g.done = true
@@ -76,11 +114,6 @@ func (c *Coalescer) Do(key string, fn func() (*Response, error)) (*Response, err
 		}
 		g.mu.Unlock()
 
-		// Clean up after TTL
-		time.AfterFunc(c.ttl, func() {
-			c.groups.Delete(key)
-		})
-
 		return resp, err
 	}
 
@@ -93,7 +126,7 @@ func (c *Coalescer) Do(key string, fn func() (*Response, error)) (*Response, err
 	select {
 	case resp := <-waitChan:
 		return resp, resp.Err
-	case <-time.After(60 * time.Second):
+	case <-time.After(c.ttl):
 		// Timeout
 		return nil, &CoalesceTimeoutError{}
 	}
@@ -0,0 +1,133 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"gateway/cache"
+	"gateway/circuitbreaker"
+	"gateway/config"
+	"gateway/healthcheck"
+	"gateway/metrics"
+	"gateway/ratelimit"
+)
+
+// newTestHandler returns a handler proxying every path to backend
+func newTestHandler(t *testing.T, backend string) *ProxyHandler {
+	return newTestProxy(t, fmt.Sprintf(`{
+		"rate_limit": {"enabled": false},
+		"routes": [{"path": "/*", "backend": %q, "methods": ["GET"], "timeout_seconds": 5}]
+	}`, backend))
+}
+
+// newTestProxy returns a handler for the JSON config doc
+func newTestProxy(t *testing.T, doc string) *ProxyHandler {
+	t.Helper()
+	cfg, err := config.Parse([]byte(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := NewProxyHandler(cfg,
+		ratelimit.NewLimiter(cfg.RateLimit.NumShards, cfg.RateLimit.DefaultRate, cfg.RateLimit.BurstSize),
+		circuitbreaker.NewRegistry(cfg.CircuitBreaker.MaxBreakers),
+		healthcheck.NewChecker(),
+		cache.NewCache(cfg.Cache.MaxEntries, cfg.Cache.MaxSize),
+		NewCoalescer(time.Minute),
+		metrics.NewCollector())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestCoalesceKey(t *testing.T) {
+	get := func(h http.Header) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "http://example.com/a?b=c", nil)
+		for k, v := range h {
+			r.Header[k] = v
+		}
+		return r
+	}
+	base := coalesceKey(get(nil), "")
+
+	tests := []struct {
+		name   string
+		r      *http.Request
+		creds  string
+		shared bool
+	}{
+		{"identical", get(nil), "", true},
+		{"unrelated header", get(http.Header{"User-Agent": {"x"}}), "", true},
+		{"credentials", get(nil), `"Bearer x"`, false},
+		{"accept", get(http.Header{"Accept": {"text/html"}}), "", false},
+		{"accept-encoding", get(http.Header{"Accept-Encoding": {"gzip"}}), "", false},
+		{"accept-language", get(http.Header{"Accept-Language": {"de"}}), "", false},
+		{"cookie", get(http.Header{"Cookie": {"a=b"}}), "", false},
+		{"range", get(http.Header{"Range": {"bytes=0-1"}}), "", false},
+		{"if-none-match", get(http.Header{"If-None-Match": {`"x"`}}), "", false},
+		{"other query", httptest.NewRequest(http.MethodGet, "http://example.com/a?b=d", nil), "", false},
+		{"other host", httptest.NewRequest(http.MethodGet, "http://example.org/a?b=c", nil), "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := coalesceKey(tt.r, tt.creds) == base; got != tt.shared {
+				t.Errorf("shares key = %v, want %v", got, tt.shared)
+			}
+		})
+	}
+}
+
+// Concurrent requests that negotiate different encodings must each get
+// their own upstream response
+func TestCoalesceAcceptEncoding(t *testing.T) {
+	var mu sync.Mutex
+	arrived := 0
+	both := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		if arrived++; arrived == 2 {
+			close(both)
+		}
+		mu.Unlock()
+		// Hold the first response until the second request arrives, so
+		// they overlap if it is coalesced
+		select {
+		case <-both:
+		case <-time.After(2 * time.Second):
+		}
+		w.Header().Set("Vary", "Accept-Encoding")
+		io.WriteString(w, "encoding="+r.Header.Get("Accept-Encoding"))
+	}))
+	defer backend.Close()
+
+	p := newTestHandler(t, backend.URL)
+	encodings := []string{"gzip", "identity"}
+	bodies := make([]string, len(encodings))
+	var wg sync.WaitGroup
+	for i, enc := range encodings {
+		wg.Add(1)
+		go func(i int, enc string) {
+			defer wg.Done()
+			r := httptest.NewRequest(http.MethodGet, "/resource", nil)
+			r.Header.Set("Accept-Encoding", enc)
+			w := httptest.NewRecorder()
+			p.ServeHTTP(w, r)
+			bodies[i] = w.Body.String()
+		}(i, enc)
+	}
+	wg.Wait()
+
+	for i, enc := range encodings {
+		if want := "encoding=" + enc; bodies[i] != want {
+			t.Errorf("Accept-Encoding %s: got body %q, want %q", enc, bodies[i], want)
+		}
+	}
+	if arrived != 2 {
+		t.Errorf("upstream received %d requests, want 2", arrived)
+	}
+}
@@ -0,0 +1,96 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"gateway/circuitbreaker"
+	"gateway/router"
+)
+
+// grpcUnknown is the status assumed when a gRPC call ends without one
+const grpcUnknown = 2
+
+// grpcCodes names the gRPC status codes and maps each to the HTTP status
+// recorded in metrics, following google.rpc.Code
+var grpcCodes = []struct {
+	name   string
+	status int
+}{
+	{"OK", http.StatusOK},
+	{"CANCELLED", 499},
+	{"UNKNOWN", http.StatusInternalServerError},
+	{"INVALID_ARGUMENT", http.StatusBadRequest},
+	{"DEADLINE_EXCEEDED", http.StatusGatewayTimeout},
+	{"NOT_FOUND", http.StatusNotFound},
+	{"ALREADY_EXISTS", http.StatusConflict},
+	{"PERMISSION_DENIED", http.StatusForbidden},
+	{"RESOURCE_EXHAUSTED", http.StatusTooManyRequests},
+	{"FAILED_PRECONDITION", http.StatusBadRequest},
+	{"ABORTED", http.StatusConflict},
+	{"OUT_OF_RANGE", http.StatusBadRequest},
+	{"UNIMPLEMENTED", http.StatusNotImplemented},
+	{"INTERNAL", http.StatusInternalServerError},
+	{"UNAVAILABLE", http.StatusServiceUnavailable},
+	{"DATA_LOSS", http.StatusInternalServerError},
+	{"UNAUTHENTICATED", http.StatusUnauthorized},
+}
+
+// grpcStatus returns the status code of a gRPC response whose body has
+// been read to the end. It comes from the trailers, or from the headers
+// for trailers-only responses; a call that ended without one is UNKNOWN.
+func grpcStatus(resp *http.Response) int {
+	v := resp.Trailer.Get("Grpc-Status")
+	if v == "" {
+		v = resp.Header.Get("Grpc-Status")
+	}
+	code, err := strconv.Atoi(v)
+	if err != nil || code < 0 || code >= len(grpcCodes) {
+		return grpcUnknown
+	}
+	return code
+}
+
+// grpcBody classifies a gRPC call for the circuit breaker once its status
+// is known, which is only when the body has been read to the end. A body
+// closed early was abandoned by the client.
+type grpcBody struct {
+	io.ReadCloser
+	once     sync.Once
+	classify func(err error) circuitbreaker.Outcome
+	done     circuitbreaker.Done
+}
+
+func (b *grpcBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil {
+		streamErr := err
+		if err == io.EOF {
+			streamErr = nil
+		}
+		b.once.Do(func() { b.done(b.classify(streamErr)) })
+	}
+	return n, err
+}
+
+func (b *grpcBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(func() { b.done(circuitbreaker.Outcome{Canceled: true}) })
+	return err
+}
+
+// recordResponse records a relayed response in the metrics. gRPC calls are
+// recorded by their gRPC status, mapped to the equivalent HTTP status,
+// rather than the HTTP 200 that carries them.
+func (p *ProxyHandler) recordResponse(route *route, resp *http.Response, latency time.Duration) {
+	status := resp.StatusCode
+	if router.IsGRPC(resp.Header) && status == http.StatusOK {
+		code := grpcStatus(resp)
+		p.collector.RecordGRPCStatus(grpcCodes[code].name)
+		status = grpcCodes[code].status
+	}
+	p.collector.RecordRequest(route.Path, latency, status, false)
+}
@@ -0,0 +1,220 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gateway/circuitbreaker"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+func TestGRPCStatus(t *testing.T) {
+	tests := []struct {
+		name     string
+		header   string
+		trailer  string
+		want     int
+		wantName string
+	}{
+		{"trailer", "", "0", 0, "OK"},
+		{"trailers-only", "14", "", 14, "UNAVAILABLE"},
+		{"trailer wins", "0", "13", 13, "INTERNAL"},
+		{"missing", "", "", grpcUnknown, "UNKNOWN"},
+		{"not a number", "", "ok", grpcUnknown, "UNKNOWN"},
+		{"out of range", "", "17", grpcUnknown, "UNKNOWN"},
+		{"negative", "", "-1", grpcUnknown, "UNKNOWN"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{Header: http.Header{}, Trailer: http.Header{}}
+			if tt.header != "" {
+				resp.Header.Set("Grpc-Status", tt.header)
+			}
+			if tt.trailer != "" {
+				resp.Trailer.Set("Grpc-Status", tt.trailer)
+			}
+			got := grpcStatus(resp)
+			if got != tt.want || grpcCodes[got].name != tt.wantName {
+				t.Errorf("grpcStatus() = %d (%s), want %d (%s)", got, grpcCodes[got].name, tt.want, tt.wantName)
+			}
+		})
+	}
+}
+
+// stubBody returns data, then err
+type stubBody struct {
+	data string
+	err  error
+}
+
+func (b *stubBody) Read(p []byte) (int, error) {
+	if b.data == "" {
+		return 0, b.err
+	}
+	n := copy(p, b.data)
+	b.data = b.data[n:]
+	return n, nil
+}
+
+func (b *stubBody) Close() error { return nil }
+
+func TestGRPCBodyClassification(t *testing.T) {
+	reset := errors.New("stream reset")
+	classifier := circuitbreaker.NewClassifier(nil, []int{14}, 0)
+	tests := []struct {
+		name    string
+		status  string
+		err     error
+		readAll bool
+		want    circuitbreaker.Outcome
+	}{
+		{"ok", "0", io.EOF, true, circuitbreaker.Outcome{}},
+		{"failure code", "14", io.EOF, true, circuitbreaker.Outcome{Failure: true}},
+		{"other code", "5", io.EOF, true, circuitbreaker.Outcome{}},
+		{"broken stream", "", reset, true, circuitbreaker.Outcome{Failure: true}},
+		{"abandoned", "0", io.EOF, false, circuitbreaker.Outcome{Canceled: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{Header: http.Header{}, Trailer: http.Header{}}
+			var outcomes []circuitbreaker.Outcome
+			body := &grpcBody{
+				ReadCloser: &stubBody{data: "message", err: tt.err},
+				classify: func(err error) circuitbreaker.Outcome {
+					// Trailers are only known once the body is read
+					resp.Trailer.Set("Grpc-Status", tt.status)
+					return classifier.ClassifyGRPC(context.Background(), grpcStatus(resp), err, 0)
+				},
+				done: func(o circuitbreaker.Outcome) { outcomes = append(outcomes, o) },
+			}
+			if tt.readAll {
+				io.ReadAll(body)
+			} else {
+				body.Read(make([]byte, 1))
+			}
+			body.Close()
+
+			if len(outcomes) != 1 {
+				t.Fatalf("reported %d outcomes, want 1", len(outcomes))
+			}
+			if outcomes[0] != tt.want {
+				t.Errorf("outcome = %+v, want %+v", outcomes[0], tt.want)
+			}
+		})
+	}
+}
+
+// h2cClient speaks HTTP/2 without TLS
+func h2cClient() *http2.Transport {
+	return &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		},
+	}
+}
+
+// A bidirectional call streams both ways for longer than the route and
+// server timeouts, and its trailers reach the client
+func TestGRPCRoundTrip(t *testing.T) {
+	backend := httptest.NewServer(h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/grpc")
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+
+		// Echo every message as it arrives
+		messages := 0
+		buf := make([]byte, 64)
+		for {
+			n, err := r.Body.Read(buf)
+			if n > 0 {
+				messages++
+				w.Write(buf[:n])
+				w.(http.Flusher).Flush()
+			}
+			if err != nil {
+				break
+			}
+		}
+		w.Header().Set(http.TrailerPrefix+"Grpc-Status", "0")
+		w.Header().Set(http.TrailerPrefix+"Grpc-Message", fmt.Sprintf("echoed %d", messages))
+	}), &http2.Server{}))
+	defer backend.Close()
+
+	p := newTestProxy(t, fmt.Sprintf(`{
+		"rate_limit": {"enabled": false},
+		"upstreams": [{"name": "echo", "protocol": "h2c", "targets": [{"url": %q}]}],
+		"routes": [
+			{"path": "/*", "upstream": "echo", "methods": ["POST"], "timeout_seconds": 1,
+			 "predicates": [{"type": "grpc", "values": ["test.Echo"]}]}
+		]
+	}`, backend.URL))
+	gateway := httptest.NewUnstartedServer(h2c.NewHandler(p, &http2.Server{}))
+	gateway.Config.ReadTimeout = time.Second
+	gateway.Config.WriteTimeout = time.Second
+	gateway.Start()
+	defer gateway.Close()
+
+	const messages = 6
+	pr, pw := io.Pipe()
+	go func() {
+		for i := 0; i < messages; i++ {
+			fmt.Fprintf(pw, "message %d;", i)
+			time.Sleep(300 * time.Millisecond)
+		}
+		pw.Close()
+	}()
+
+	req, err := http.NewRequest(http.MethodPost, gateway.URL+"/test.Echo/Chat", pr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+	resp, err := h2cClient().RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("stream broke after %q: %v", body, err)
+	}
+
+	if n := strings.Count(string(body), "message"); n != messages {
+		t.Errorf("got %d messages back, want %d", n, messages)
+	}
+	if got := resp.Trailer.Get("Grpc-Status"); got != "0" {
+		t.Errorf("Grpc-Status trailer = %q, want 0", got)
+	}
+	if got, want := resp.Trailer.Get("Grpc-Message"), fmt.Sprintf("echoed %d", messages); got != want {
+		t.Errorf("Grpc-Message trailer = %q, want %q", got, want)
+	}
+	if got := p.collector.GetStats()["grpc_status"].(map[string]int64)["OK"]; got != 1 {
+		t.Errorf("recorded %d OK calls, want 1", got)
+	}
+
+	// Other services don't match the route
+	req, _ = http.NewRequest(http.MethodPost, gateway.URL+"/test.Other/Chat", strings.NewReader("x"))
+	req.Header.Set("Content-Type", "application/grpc")
+	resp, err = h2cClient().RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("other service: status %d, want %d", resp.StatusCode, http.StatusNotFound)
+	}
+}
@@ -1,177 +1,687 @@
 package proxy
 
 import (
+	"bytes"
 	"context"
+	"crypto/tls"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
-	"net/url"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"gateway/apikey"
+	"gateway/balancer"
 	"gateway/cache"
+	"gateway/certs"
 	"gateway/circuitbreaker"
 	"gateway/config"
+	"gateway/healthcheck"
+	"gateway/jwt"
 	"gateway/metrics"
 	"gateway/ratelimit"
+	"gateway/retry"
+	"gateway/router"
+
+	"golang.org/x/net/http2"
 )
 
 // ProxyHandler handles HTTP requests
 type ProxyHandler struct {
-	client      *http.Client
-	limiter    *ratelimit.Limiter
-	breaker    *circuitbreaker.Breaker
-	cache      *cache.Cache
-	coalescer  *Coalescer
-	collector  *metrics.Collector
-	cfg        *config.Config
-	cfgVersion int64
+	coalescer *Coalescer
+	collector *metrics.Collector
+	tunnels   *tunnelSet
+	state     atomic.Pointer[handlerState]
+	reloadMu  sync.Mutex
+
+	revalidating sync.Map // stored responses being revalidated in the background
 }
 
 // NewProxyHandler creates a new proxy handler
-func NewProxyHandler(cfg *config.Config, limiter *ratelimit.Limiter, 
-	breaker *circuitbreaker.Breaker, cache *cache.Cache, 
-	coalescer *Coalescer, collector *metrics.Collector) *ProxyHandler {
-	
-	transport := &http.Transport{
-		MaxIdleConns:        cfg.ConnectionPool.MaxIdle,
-		MaxIdleConnsPerHost: cfg.ConnectionPool.MaxIdle,
-		IdleConnTimeout:     time.Duration(cfg.ConnectionPool.IdleTimeout) * time.Second,
-	}
-
-	return &ProxyHandler{
-		client: &http.Client{
-			Transport: transport,
-			Timeout:   time.Duration(cfg.Timeouts.TotalSeconds) * time.Second,
-		},
-		limiter:   limiter,
-		breaker:   breaker,
-		cache:     cache,
+func NewProxyHandler(cfg *config.Config, limiter *ratelimit.Limiter,
+	breakers *circuitbreaker.Registry, checker *healthcheck.Checker, cache *cache.Cache,
+	coalescer *Coalescer, collector *metrics.Collector) (*ProxyHandler, error) {
+
+	pools, err := compilePools(cfg)
+	if err != nil {
+		return nil, err
+	}
+	table, compiled, err := compileRoutes(cfg, pools)
+	if err != nil {
+		return nil, err
+	}
+	trusted, err := trustedProxies(cfg)
+	if err != nil {
+		return nil, err
+	}
+	validator, err := newValidator(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &ProxyHandler{
 		coalescer: coalescer,
 		collector: collector,
-		cfg:       cfg,
+		tunnels:   newTunnelSet(),
+	}
+	tlsClients := newTLSClients(cfg, pools)
+	checker.Sync(pools, healthChecks(cfg, tlsClients))
+	if validator != nil {
+		validator.Start()
+	}
+	p.state.Store(&handlerState{
+		cfg:        cfg,
+		cfgVersion: config.Version(),
+		routes:     table,
+		compiled:   compiled,
+		pools:      pools,
+		clients:    newClients(cfg),
+		tlsClients: tlsClients,
+		jwt:        validator,
+		limiter:    limiter,
+		trusted:    trusted,
+		breakers:   breakers,
+		checker:    checker,
+		cache:      cache,
+		retryBudget: retry.NewBudget(
+			cfg.RetryBudget.Percent,
+			cfg.RetryBudget.MinPerSecond,
+			cfg.RetryBudget.WindowSeconds,
+		),
+		hedgeBudget: retry.NewBudget(cfg.HedgeBudget.Percent, 0, cfg.HedgeBudget.WindowSeconds),
+	})
+	return p, nil
+}
+
+// newValidator creates the JWT validator, or returns nil when no keys are
+// configured. The caller starts it once the rest of the state is built.
+func newValidator(cfg *config.Config) (*jwt.Validator, error) {
+	if !cfg.JWT.Enabled() {
+		return nil, nil
+	}
+	v, err := cfg.JWT.Compile()
+	if err != nil {
+		return nil, fmt.Errorf("jwt: %w", err)
+	}
+	return v, nil
+}
+
+// trustedProxies parses the trusted proxy ranges
+func trustedProxies(cfg *config.Config) ([]*net.IPNet, error) {
+	nets, err := cfg.RateLimit.CompileTrustedProxies()
+	if err != nil {
+		return nil, fmt.Errorf("rate_limit: %w", err)
+	}
+	return nets, nil
+}
+
+// h2PingInterval is how long an upstream HTTP/2 connection may go without
+// frames before it is health-checked with a ping
+const h2PingInterval = 30 * time.Second
+
+// newClients creates an upstream client for every protocol an upstream
+// may speak
+func newClients(cfg *config.Config) map[string]*http.Client {
+	clients := make(map[string]*http.Client, 3)
+	for _, protocol := range []string{config.ProtocolHTTP1, config.ProtocolH2C, config.ProtocolH2} {
+		clients[protocol] = &http.Client{Transport: newTransport(cfg, protocol, nil)}
+	}
+	return clients
+}
+
+// newTLSClients creates a client for every pool with its own TLS settings,
+// keyed by pool name. Other pools share the clients from newClients.
+func newTLSClients(cfg *config.Config, pools map[string]*balancer.Pool) map[string]*http.Client {
+	clients := make(map[string]*http.Client)
+	for name, pool := range pools {
+		if pool.TLS != nil {
+			clients[name] = &http.Client{Transport: newTransport(cfg, pool.Protocol, pool.TLS)}
+		}
+	}
+	return clients
+}
+
+// newTransport creates the transport for an upstream protocol. tlsConfig
+// replaces the default TLS settings for https targets.
+func newTransport(cfg *config.Config, protocol string, tlsConfig *tls.Config) http.RoundTripper {
+	switch protocol {
+	case config.ProtocolH2C:
+		// h2c has no TLS handshake to negotiate HTTP/2; the transport
+		// dials plain TCP and assumes the upstream speaks it
+		return &http2.Transport{
+			AllowHTTP:       true,
+			ReadIdleTimeout: h2PingInterval,
+			DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, addr)
+			},
+		}
+	case config.ProtocolH2:
+		// HTTP/2 connections are shared by many streams and live long,
+		// so they are pinged when quiet to detect upstreams that went away
+		return &http2.Transport{TLSClientConfig: tlsConfig, ReadIdleTimeout: h2PingInterval}
+	default:
+		return &http.Transport{
+			MaxIdleConns:        cfg.ConnectionPool.MaxIdle,
+			MaxIdleConnsPerHost: cfg.ConnectionPool.MaxIdle,
+			IdleConnTimeout:     time.Duration(cfg.ConnectionPool.IdleTimeout) * time.Second,
+			TLSClientConfig:     tlsConfig,
+			// A custom TLS config otherwise turns off HTTP/2 negotiation
+			ForceAttemptHTTP2: tlsConfig != nil,
+		}
 	}
 }
 
 // ServeHTTP handles HTTP requests
 func (p *ProxyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	start := time.Now()
-	
-	// Update configuration if needed
-	cfg := config.GetConfig()
-	if cfg != nil && cfg != p.cfg {
-		p.cfg = cfg
-	}
 
+	// Pin one state snapshot for the whole request so a concurrent reload
+	// can't mix old and new components
+	s := p.state.Load()
+
+	l := &responseLog{ResponseWriter: w}
+	p.serve(l, r, s, start)
+	p.logRequest(s, l, r, start)
+}
+
+func (p *ProxyHandler) serve(w *responseLog, r *http.Request, s *handlerState, start time.Time) {
 	// Find matching route
-	route := p.findRoute(r.URL.Path, r.Method)
+	route, match := s.findRoute(r)
 	if route == nil {
 		http.Error(w, "Route not found", http.StatusNotFound)
 		p.collector.RecordRequest(r.URL.Path, time.Since(start), http.StatusNotFound, false)
 		return
 	}
+	w.route = route.Path
+
+	// Expose the match (and its path parameters) to later stages
+	r = r.WithContext(router.WithMatch(r.Context(), match))
+
+	if auth := s.cfg.TLS.ClientAuth; auth != nil {
+		setClientIdentity(r, auth)
+	}
+
+	// Authentication
+	stripClaimHeaders(r, &s.cfg.JWT)
+	if route.auth != nil {
+		if r = p.authenticate(w, r, s, route, start); r == nil {
+			return
+		}
+	}
+	if route.APIKey {
+		if r = p.authenticateKey(w, r, s, route, start); r == nil {
+			return
+		}
+		w.key = apikey.FromContext(r.Context())
+	}
+	w.client = p.getClientKey(s, r)
 
 	// Rate limiting
-	if p.cfg.RateLimit.Enabled {
-		allowed, remaining, resetTime := p.limiter.Allow(p.getClientKey(r), route.RateLimit)
+	if s.cfg.RateLimit.Enabled {
+		limit := route.RateLimit
+		if w.key != nil && w.key.RateLimit > 0 {
+			limit = w.key.RateLimit
+		}
+		key, err := rateLimitKey(s, r, route, w.client)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			p.collector.RecordRequest(route.Path, time.Since(start), http.StatusBadRequest, false)
+			return
+		}
+		allowed, remaining, resetTime := s.limiter.Allow(key, limit)
 		if !allowed {
-			w.Header().Set("X-RateLimit-Limit", fmt.Sprintf("%d", route.RateLimit))
+			w.Header().Set("X-RateLimit-Limit", fmt.Sprintf("%d", limit))
 			w.Header().Set("X-RateLimit-Remaining", fmt.Sprintf("%d", remaining))
 			w.Header().Set("X-RateLimit-Reset", fmt.Sprintf("%d", resetTime))
 			w.Header().Set("Retry-After", fmt.Sprintf("%d", resetTime-time.Now().Unix()))
 			http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
+			w.limited = true
 			p.collector.RecordRateLimit()
 			return
 		}
 	}
 
-	// Check cache for GET requests
-	fromCache := false
-	if r.Method == http.MethodGet && route.EnableCache && p.cfg.Cache.Enabled {
-		key := cache.Hash(r.Method, r.URL.Path, r.URL.RawQuery, nil)
-		if cachedResp, ok := p.cache.Get(key); ok {
-			// Serve from cache
-			for k, v := range cachedResp.Headers {
-				for _, val := range v {
-					w.Header().Add(k, val)
-				}
-			}
-			w.WriteHeader(cachedResp.StatusCode)
-			w.Write(cachedResp.Body)
-			p.collector.RecordRequest(route.Path, time.Since(start), cachedResp.StatusCode, true)
+	// Protocol upgrades are tunneled on routes that allow them; elsewhere
+	// the Upgrade header is dropped and the request proxied normally
+	if route.Upgrade != nil && isUpgrade(r) {
+		p.serveUpgrade(w, r, s, route, start)
+		return
+	}
+
+	// Answer from the cache when a fresh enough response is stored, and
+	// revalidate an expired one rather than fetching it anew
+	var stale *cache.Response
+	if s.usesCache(r, route) {
+		cached, age, freshness := s.cache.Lookup(r)
+		switch freshness {
+		case cache.Fresh:
+			writeCached(w, r, cached, age, "HIT")
+			p.collector.RecordRequest(route.Path, time.Since(start), cached.StatusCode, true)
 			return
+		case cache.Stale:
+			p.revalidate(s, r, route, cached)
+			writeCached(w, r, cached, age, "STALE")
+			p.collector.RecordRequest(route.Path, time.Since(start), cached.StatusCode, true)
+			return
+		case cache.Expired:
+			stale = cached
 		}
+		if cache.OnlyIfCached(r) {
+			http.Error(w, "Not cached", http.StatusGatewayTimeout)
+			p.collector.RecordRequest(route.Path, time.Since(start), http.StatusGatewayTimeout, false)
+			return
+		}
+		w.Header().Set("X-Cache", "MISS")
 	}
 
 	// Request coalescing for GET requests
 	if r.Method == http.MethodGet {
-		coalesceKey := fmt.Sprintf("%s:%s:%s", r.Method, r.URL.Path, r.URL.RawQuery)
-		
-		resp, err := p.coalescer.Do(coalesceKey, func() (*Response, error) {
-			return p.forwardRequest(r, route)
-		})
-		
+		p.serveCoalesced(w, r, s, route, start, stale)
+		return
+	}
+
+	// Non-GET requests: no coalescing, streamed straight through
+	extendDeadlines(w)
+	resp, err := p.forwardRequest(s, r, route)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		p.collector.RecordRequest(route.Path, time.Since(start), http.StatusBadGateway, false)
+		return
+	}
+	writeStream(w, resp, route.flushInterval())
+
+	p.recordResponse(route, resp, time.Since(start))
+}
+
+// serveCoalesced forwards a GET request, sharing the upstream response with
+// identical requests that arrive while it is in flight. Responses up to the
+// cache's object size limit are buffered so they can be shared and cached;
+// larger ones are streamed to the request that fetched them, and the
+// others fetch their own copy.
+//
+// When stale holds an expired response to r, it is revalidated instead,
+// and served again if the upstream fails within its stale-if-error window.
+func (p *ProxyHandler) serveCoalesced(w http.ResponseWriter, r *http.Request, s *handlerState, route *route, start time.Time, stale *cache.Response) {
+	fetch := r
+	if stale != nil {
+		fetch = conditional(r, stale)
+	}
+
+	var own *http.Response
+	fetched := false
+	resp, err := p.coalescer.Do(coalesceKey(fetch, credentials(s, r)), func() (*Response, error) {
+		fetched = true
+		upstream, err := p.forwardRequest(s, fetch, route)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadGateway)
-			p.collector.RecordRequest(route.Path, time.Since(start), http.StatusBadGateway, fromCache)
-			return
+			return nil, err
 		}
-
-		// Write response
-		for k, v := range resp.Headers {
-			for _, val := range v {
-				w.Header().Add(k, val)
-			}
+		buffered, ok, err := bufferResponse(upstream, s.cfg.Cache.MaxObjectBytes)
+		if err != nil {
+			return nil, err
 		}
-		w.WriteHeader(resp.StatusCode)
-		w.Write(resp.Body)
-
-		// Cache response if eligible
-		if route.EnableCache && p.cfg.Cache.Enabled && resp.StatusCode == http.StatusOK {
-			key := cache.Hash(r.Method, r.URL.Path, r.URL.RawQuery, nil)
-			p.cache.Set(key, &cache.Response{
-				StatusCode: resp.StatusCode,
-				Headers:    resp.Headers,
-				Body:       resp.Body,
-			}, p.cfg.Cache.TTLSeconds)
+		if !ok {
+			own = upstream
+			return &Response{StatusCode: upstream.StatusCode, Streamed: true}, nil
 		}
-
-		p.collector.RecordRequest(route.Path, time.Since(start), resp.StatusCode, fromCache)
-		return
+		return buffered, nil
+	})
+	if err == nil && resp.Streamed && own == nil {
+		own, err = p.forwardRequest(s, fetch, route)
 	}
 
-	// Non-GET requests: no coalescing
-	resp, err := p.forwardRequest(r, route)
 	if err != nil {
+		if p.serveStale(w, r, route, stale, start) {
+			return
+		}
 		http.Error(w, err.Error(), http.StatusBadGateway)
-		p.collector.RecordRequest(route.Path, time.Since(start), http.StatusBadGateway, fromCache)
+		p.collector.RecordRequest(route.Path, time.Since(start), http.StatusBadGateway, false)
 		return
 	}
 
+	if own != nil {
+		if own.StatusCode >= 500 && p.serveStale(w, r, route, stale, start) {
+			own.Body.Close()
+			return
+		}
+		writeStream(w, own, route.flushInterval())
+		p.recordResponse(route, own, time.Since(start))
+		return
+	}
+
+	if stale != nil {
+		switch {
+		case resp.StatusCode == http.StatusNotModified:
+			// Every request that revalidated the same response refreshes
+			// its own variant, which the validators show is unchanged
+			fresh := s.cache.Refresh(fetch, stale, resp.Headers, s.cachePolicy(), authenticated(s, r))
+			writeCached(w, r, fresh, fresh.Age(time.Now().Unix()), "REVALIDATED")
+			p.collector.RecordRequest(route.Path, time.Since(start), fresh.StatusCode, false)
+			return
+		case resp.StatusCode >= 500:
+			if p.serveStale(w, r, route, stale, start) {
+				return
+			}
+		}
+	}
+
 	// Write response
 	for k, v := range resp.Headers {
 		for _, val := range v {
 			w.Header().Add(k, val)
 		}
 	}
+	if s.usesCache(r, route) {
+		stripSurrogateKeys(w.Header())
+		// Give clients the tag the cache will validate their copy against
+		if resp.StatusCode == http.StatusOK && w.Header().Get("ETag") == "" {
+			w.Header().Set("ETag", cache.ETag(resp.Body))
+		}
+	}
 	w.WriteHeader(resp.StatusCode)
 	w.Write(resp.Body)
 
-	p.collector.RecordRequest(route.Path, time.Since(start), resp.StatusCode, fromCache)
+	// Only the request that fetched the response stores it, since its
+	// headers select the variant it is stored as
+	if fetched && s.usesCache(r, route) {
+		s.storeResponse(r, resp)
+	}
+
+	p.collector.RecordRequest(route.Path, time.Since(start), resp.StatusCode, false)
+}
+
+// forwardRequest sends r upstream, retrying failed attempts on other
+// targets as the route's retry policy and the gateway's retry budget allow.
+// The response body is unread; closing it releases the request's resources.
+func (p *ProxyHandler) forwardRequest(s *handlerState, r *http.Request, route *route) (*http.Response, error) {
+	// All attempts, including backoff, must get response headers within
+	// the route timeout; the body may then stream for as long as it lasts
+	ctx, stop, cancel := withHeaderTimeout(r.Context(), time.Duration(route.Timeout)*time.Second)
+
+	resp, err := p.forwardAttempts(ctx, s, r, route)
+	if !stop() {
+		if err == nil {
+			resp.Body.Close()
+		}
+		err = fmt.Errorf("no response within %ds: %w", route.Timeout, context.DeadlineExceeded)
+	}
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	onClose(resp, cancel)
+	return resp, nil
+}
+
+// withHeaderTimeout returns a context derived from parent that is canceled,
+// with context.DeadlineExceeded as its cause, unless stop is called within
+// timeout. It bounds the wait for response headers without limiting how
+// long the body may take. stop reports false once the timeout has passed;
+// cancel releases the context. A timeout of zero or less never passes.
+func withHeaderTimeout(parent context.Context, timeout time.Duration) (ctx context.Context, stop func() bool, cancel func()) {
+	ctx, cancelCause := context.WithCancelCause(parent)
+	if timeout <= 0 {
+		return ctx, func() bool { return true }, func() { cancelCause(nil) }
+	}
+	timer := time.AfterFunc(timeout, func() { cancelCause(context.DeadlineExceeded) })
+	return ctx, timer.Stop, func() {
+		timer.Stop()
+		cancelCause(nil)
+	}
+}
+
+func (p *ProxyHandler) forwardAttempts(ctx context.Context, s *handlerState, r *http.Request, route *route) (*http.Response, error) {
+	s.retryBudget.RecordRequest()
+	s.hedgeBudget.RecordRequest()
+	buf, replayable, err := replayableBody(r, route.retry)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		resp  *http.Response
+		tried []*balancer.Target
+	)
+	for attempt := 1; ; attempt++ {
+		target, pickErr := route.pool.PickOther(r, tried)
+		if pickErr != nil {
+			if attempt == 1 {
+				return nil, pickErr
+			}
+			return resp, err
+		}
+		tried = append(tried, target)
+
+		// The previous attempt's response is only kept in case this one
+		// couldn't be made
+		if resp != nil {
+			discard(resp)
+		}
+
+		if route.Hedge != nil && hedgeable(r) {
+			resp, err = p.hedge(ctx, s, r, route, target, &tried)
+		} else {
+			var body io.Reader = r.Body
+			if replayable {
+				body = bytes.NewReader(buf)
+			}
+			resp, err = p.attempt(ctx, s, r, route, target, body)
+		}
+
+		status := 0
+		if resp != nil {
+			status = resp.StatusCode
+		}
+		if !replayable || attempt >= route.retry.MaxAttempts ||
+			!route.retry.ShouldRetry(status, err) || !s.retryBudget.Acquire() {
+			return resp, err
+		}
+
+		select {
+		case <-time.After(route.retry.Backoff(attempt)):
+		case <-ctx.Done():
+			return resp, err
+		}
+	}
+}
+
+// hedgeable reports whether r is read-only and bodiless, so sending it
+// twice is harmless
+func hedgeable(r *http.Request) bool {
+	return (r.Method == http.MethodGet || r.Method == http.MethodHead) && r.ContentLength == 0
+}
+
+// hedge sends r to target and, whenever no response has arrived within the
+// route's hedge delay, to another target too, up to the route's hedge limit
+// and the gateway's hedge budget. The first successful response wins and
+// the other attempts are canceled; if all fail, the last error is returned.
+func (p *ProxyHandler) hedge(ctx context.Context, s *handlerState, r *http.Request,
+	route *route, target *balancer.Target, tried *[]*balancer.Target) (*http.Response, error) {
+
+	type result struct {
+		resp  *http.Response
+		err   error
+		index int
+	}
+	results := make(chan result, 1+route.Hedge.MaxHedges)
+	var cancels []context.CancelFunc
+	launch := func(t *balancer.Target) {
+		actx, cancel := context.WithCancel(ctx)
+		index := len(cancels)
+		cancels = append(cancels, cancel)
+		go func() {
+			resp, err := p.attempt(actx, s, r, route, t, nil)
+			results <- result{resp, err, index}
+		}()
+	}
+	launch(target)
+
+	// The timer is stopped as soon as the wait it times ends
+	var timer *time.Timer
+	var timeout <-chan time.Time
+	delay, ok := p.hedgeDelay(route)
+	if ok {
+		timer = time.NewTimer(delay)
+		timeout = timer.C
+	}
+
+	inflight, hedges := 1, 0
+	var last result
+	for inflight > 0 {
+		select {
+		case res := <-results:
+			inflight--
+			if res.err != nil {
+				last = res
+				continue
+			}
+			if timeout != nil {
+				timer.Stop()
+			}
+			if res.index > 0 {
+				p.collector.RecordHedgeWin()
+			}
+
+			// Cancel the losers and close whatever they still return
+			for i, cancel := range cancels {
+				if i != res.index {
+					cancel()
+				}
+			}
+			go func(n int) {
+				for ; n > 0; n-- {
+					if lost := <-results; lost.resp != nil {
+						lost.resp.Body.Close()
+					}
+				}
+			}(inflight)
+			return res.resp, nil
+
+		case <-timeout:
+			timeout = nil
+			if !s.hedgeBudget.Acquire() {
+				continue
+			}
+			t, err := route.pool.PickOther(r, *tried)
+			if err != nil {
+				continue
+			}
+			*tried = append(*tried, t)
+			inflight++
+			hedges++
+			p.collector.RecordHedge()
+			launch(t)
+
+			if hedges < route.Hedge.MaxHedges {
+				// Fired and drained, so it can be reused
+				timer.Reset(delay)
+				timeout = timer.C
+			}
+		}
+	}
+
+	if timeout != nil {
+		timer.Stop()
+	}
+	for _, cancel := range cancels {
+		cancel()
+	}
+	return nil, last.err
+}
+
+// hedgeDelay returns how long an attempt may take before it is hedged, and
+// false while the route has too little latency data to derive it
+func (p *ProxyHandler) hedgeDelay(route *route) (time.Duration, bool) {
+	h := route.Hedge
+	delay := time.Duration(h.DelayMs) * time.Millisecond
+	if h.DelayMs == 0 {
+		var ok bool
+		if delay, ok = p.collector.LatencyPercentile(route.Path, h.Percentile); !ok {
+			return 0, false
+		}
+	}
+	return max(delay, time.Duration(h.MinDelayMs)*time.Millisecond), true
 }
 
-func (p *ProxyHandler) forwardRequest(r *http.Request, route *config.RouteConfig) (*Response, error) {
-	backend, err := url.Parse(route.Backend)
+// replayableBody buffers r's body so it can be sent more than once. It
+// reports false, leaving the body to be streamed to a single attempt, when
+// the route may not retry r or the body exceeds the policy's size limit.
+func replayableBody(r *http.Request, policy *retry.Policy) ([]byte, bool, error) {
+	if !policy.Allows(r.Method) {
+		return nil, false, nil
+	}
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, true, nil
+	}
+
+	buf, err := io.ReadAll(io.LimitReader(r.Body, policy.MaxBodyBytes+1))
 	if err != nil {
-		return nil, fmt.Errorf("invalid backend URL: %w", err)
+		return nil, false, err
+	}
+	if int64(len(buf)) > policy.MaxBodyBytes {
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(buf), r.Body), r.Body}
+		return nil, false, nil
+	}
+	return buf, true, nil
+}
+
+// attempt sends one request to target and returns once the response
+// headers arrive. The target stays in flight until the body is closed.
+func (p *ProxyHandler) attempt(ctx context.Context, s *handlerState, r *http.Request,
+	route *route, target *balancer.Target, body io.Reader) (*http.Response, error) {
+
+	target.Acquire()
+	// Each attempt gets its headers within timeouts.total
+	ctx, stop, cancel := withHeaderTimeout(ctx, time.Duration(s.cfg.Timeouts.TotalSeconds)*time.Second)
+	release := func() {
+		cancel()
+		target.Release()
 	}
 
-	// Create proxy request
-	target := backend.ResolveReference(r.URL)
-	req, err := http.NewRequestWithContext(r.Context(), r.Method, target.String(), r.Body)
+	req, err := newUpstreamRequest(ctx, r, route, target, body)
 	if err != nil {
+		release()
 		return nil, err
 	}
 
+	sent := time.Now()
+	resp, err := s.send(ctx, route, target, req, s.client(route.pool).Do)
+	if !stop() && err == nil {
+		resp.Body.Close()
+		err = context.DeadlineExceeded
+	}
+	if err != nil {
+		release()
+		return nil, err
+	}
+	p.collector.RecordUpstreamLatency(route.Path, time.Since(sent))
+	onClose(resp, release)
+	return resp, nil
+}
+
+// newUpstreamRequest builds the request for target from the client's
+// request r, without hop-by-hop headers
+func newUpstreamRequest(ctx context.Context, r *http.Request, route *route,
+	target *balancer.Target, body io.Reader) (*http.Request, error) {
+
+	upstream := target.URL.ResolveReference(route.upstreamURL(r))
+	req, err := http.NewRequestWithContext(ctx, r.Method, upstream.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if body == r.Body {
+		req.ContentLength = r.ContentLength
+	}
+	// Trailer values are filled in as the client's body is read
+	if len(r.Trailer) > 0 {
+		req.Trailer = r.Trailer
+	}
+
 	// Copy headers
 	for k, v := range r.Header {
 		req.Header[k] = v
@@ -184,60 +694,126 @@ func (p *ProxyHandler) forwardRequest(r *http.Request, route *config.RouteConfig
 	req.Header.Del("Proxy-Authorization")
 	req.Header.Del("Upgrade")
 
-	// Circuit breaker check
-	ctx, cancel := context.WithTimeout(r.Context(), time.Duration(route.Timeout)*time.Second)
-	defer cancel()
+	// TE is hop-by-hop too, but gRPC relies on "TE: trailers" reaching
+	// the upstream
+	if te, ok := req.Header["Te"]; ok && (len(te) != 1 || te[0] != "trailers") {
+		req.Header.Del("Te")
+	}
+	return req, nil
+}
+
+// send passes req to do, guarded by target's circuit breaker when breakers
+// are enabled. Upstream status codes and latency are classified too, so a
+// backend answering 503 quickly still trips it.
+func (s *handlerState) send(ctx context.Context, route *route, target *balancer.Target,
+	req *http.Request, do func(*http.Request) (*http.Response, error)) (*http.Response, error) {
 
-	var resp *http.Response
-	if p.cfg.CircuitBreaker.Enabled {
-		err = p.breaker.Execute(ctx, func() error {
-			var err error
-			resp, err = p.client.Do(req)
-			return err
-		})
-	} else {
-		resp, err = p.client.Do(req)
+	if !s.cfg.CircuitBreaker.Enabled {
+		return do(req)
 	}
 
-	if err != nil {
-		return nil, err
+	breaker := s.breakers.Get(route.breakerKey(target), route.breakerSettings)
+	done, rejected := breaker.Allow()
+	if rejected != nil {
+		return nil, fmt.Errorf("%w: %w", retry.ErrNotSent, rejected)
 	}
-	defer resp.Body.Close()
 
-	// Read response body
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
+	callStart := time.Now()
+	resp, err := do(req)
+	if err == nil && resp.StatusCode == http.StatusOK && router.IsGRPC(resp.Header) {
+		// A gRPC call's status arrives in the trailers, after the body
+		elapsed := time.Since(callStart)
+		resp.Body = &grpcBody{
+			ReadCloser: resp.Body,
+			classify: func(err error) circuitbreaker.Outcome {
+				return route.classifier.ClassifyGRPC(ctx, grpcStatus(resp), err, elapsed)
+			},
+			done: done,
+		}
+		return resp, nil
 	}
 
-	// Convert headers
-	headers := make(map[string][]string)
-	for k, v := range resp.Header {
-		headers[k] = v
+	status := 0
+	if resp != nil {
+		status = resp.StatusCode
 	}
+	done(route.classifier.Classify(ctx, status, err, time.Since(callStart)))
+	return resp, err
+}
 
-	return &Response{
-		StatusCode: resp.StatusCode,
-		Headers:    headers,
-		Body:       body,
-	}, nil
+// client returns the client for requests to pool
+func (s *handlerState) client(pool *balancer.Pool) *http.Client {
+	if c := s.tlsClients[pool.Name]; c != nil {
+		return c
+	}
+	return s.clients[pool.Protocol]
 }
 
-func (p *ProxyHandler) findRoute(path, method string) *config.RouteConfig {
-	for _, route := range p.cfg.Routes {
-		if path == route.Path {
-			// Check method
-			for _, m := range route.Methods {
-				if m == method {
-					return &route
-				}
+func (s *handlerState) findRoute(r *http.Request) (*route, *router.Match) {
+	m := s.routes.Match(r)
+	if m == nil {
+		return nil, nil
+	}
+	return s.compiled[m.Index], m
+}
+
+// Explain reports which route r would match and why, or nil if none does
+func (p *ProxyHandler) Explain(r *http.Request) *router.Match {
+	_, m := p.state.Load().findRoute(r)
+	return m
+}
+
+// setClientIdentity forwards the identity of the client's verified
+// certificate in the configured header. Any value the client sent itself is
+// removed so backends can trust the header.
+func setClientIdentity(r *http.Request, auth *config.ClientAuthConfig) {
+	r.Header.Del(auth.IdentityHeader)
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return
+	}
+	if id := certs.Identity(r.TLS.VerifiedChains[0][0], auth.Identity); id != "" {
+		r.Header.Set(auth.IdentityHeader, id)
+	}
+}
+
+func (p *ProxyHandler) getClientKey(s *handlerState, r *http.Request) string {
+	// Authenticated clients are limited by who they are rather than where
+	// they connect from
+	if key := apikey.FromContext(r.Context()); key != nil {
+		return "apikey:" + key.ID
+	}
+	if claim := s.cfg.JWT.RateLimitClaim; claim != "" {
+		if claims := jwt.FromContext(r.Context()); claims != nil {
+			if v := claims.String(claim); v != "" {
+				return "jwt:" + v
 			}
 		}
 	}
-	return nil
+	if auth := s.cfg.TLS.ClientAuth; auth != nil && auth.RateLimitByIdentity {
+		if id := r.Header.Get(auth.IdentityHeader); id != "" {
+			return "cert:" + id
+		}
+	}
+
+	// Use IP address for key, without the port so every connection from
+	// a client shares its bucket
+	return ratelimit.ClientIP(r)
 }
 
-func (p *ProxyHandler) getClientKey(r *http.Request) string {
-	// Use IP address for key
-	return r.RemoteAddr
+// rateLimitKey returns the bucket r is counted against: the route's own
+// key when it has one, otherwise the client's identity. Requests lacking
+// a part of the route's key are counted by client identity too, unless
+// the route rejects them, in which case the error says what is missing.
+func rateLimitKey(s *handlerState, r *http.Request, route *route, client string) (string, error) {
+	if route.rateKey == nil {
+		return client, nil
+	}
+	key, err := route.rateKey.Key(r, s.trusted)
+	if err != nil {
+		if route.RateLimitKeyMissing == config.RateLimitKeyReject {
+			return "", err
+		}
+		return client, nil
+	}
+	return key, nil
 }
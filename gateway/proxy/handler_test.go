@@ -0,0 +1,72 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHedge(t *testing.T) {
+	tests := []struct {
+		name     string
+		delayMs  int
+		budget   float64 // hedge_budget percent
+		want     string
+		sent     int64
+		won      int64
+		canceled bool // the first attempt was canceled
+	}{
+		{"second target wins", 50, 100, "second", 1, 1, true},
+		{"budget exhausted", 50, 0, "first", 0, 0, false},
+		{"first answers in time", 1000, 100, "first", 0, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The first request to arrive, at either target, is slow
+			var arrivals atomic.Int64
+			var canceled atomic.Bool
+			handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if arrivals.Add(1) > 1 {
+					io.WriteString(w, "second")
+					return
+				}
+				select {
+				case <-r.Context().Done():
+					canceled.Store(true)
+				case <-time.After(300 * time.Millisecond):
+					io.WriteString(w, "first")
+				}
+			})
+			a, b := httptest.NewServer(handler), httptest.NewServer(handler)
+			defer a.Close()
+			defer b.Close()
+
+			p := newTestProxy(t, fmt.Sprintf(`{
+				"rate_limit": {"enabled": false},
+				"hedge_budget": {"percent": %v, "window_seconds": 10},
+				"upstreams": [{"name": "u", "targets": [{"url": %q}, {"url": %q}]}],
+				"routes": [{"path": "/*", "upstream": "u", "methods": ["GET"], "hedge": {"delay_ms": %d}}]
+			}`, tt.budget, a.URL, b.URL, tt.delayMs))
+
+			w := httptest.NewRecorder()
+			p.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/x", nil))
+			if w.Code != http.StatusOK || w.Body.String() != tt.want {
+				t.Fatalf("status %d, body %q, want %q", w.Code, w.Body.String(), tt.want)
+			}
+			hedges := p.collector.GetStats()["hedges"].(map[string]int64)
+			if hedges["sent"] != tt.sent || hedges["won"] != tt.won {
+				t.Errorf("hedges = %v, want %d sent and %d won", hedges, tt.sent, tt.won)
+			}
+			if tt.canceled && !eventually(canceled.Load) {
+				t.Error("losing attempt not canceled")
+			}
+			if !tt.canceled && canceled.Load() {
+				t.Error("answered attempt canceled")
+			}
+		})
+	}
+}
@@ -0,0 +1,247 @@
+package proxy
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+
+	"gateway/balancer"
+	"gateway/cache"
+	"gateway/circuitbreaker"
+	"gateway/config"
+	"gateway/healthcheck"
+	"gateway/jwt"
+	"gateway/ratelimit"
+	"gateway/retry"
+	"gateway/router"
+)
+
+// handlerState is everything derived from one configuration version. It is
+// immutable once published; reloads build a new one and swap the pointer.
+type handlerState struct {
+	cfg        *config.Config
+	cfgVersion int64
+	routes     *router.Table
+	compiled   []*route // indexed like cfg.Routes
+	pools      map[string]*balancer.Pool
+	clients    map[string]*http.Client // by upstream protocol
+	tlsClients map[string]*http.Client // by pool name, for pools with their own TLS settings
+	limiter    *ratelimit.Limiter
+	trusted    []*net.IPNet // proxies whose X-Forwarded-For is believed
+	breakers   *circuitbreaker.Registry
+	checker    *healthcheck.Checker
+	cache      *cache.Cache
+	jwt        *jwt.Validator // nil without JWT keys
+
+	retryBudget *retry.Budget
+	hedgeBudget *retry.Budget
+}
+
+// Reload applies a new configuration version. Only components whose
+// config section changed are rebuilt or reconfigured; the rest are carried
+// over so their state (open circuits, client buckets, cached responses)
+// survives the reload. Stale versions are ignored, and a version that fails
+// to compile is logged and leaves the current state serving.
+func (p *ProxyHandler) Reload(cfg *config.Config, version int64) {
+	p.reloadMu.Lock()
+	defer p.reloadMu.Unlock()
+
+	old := p.state.Load()
+	if version <= old.cfgVersion {
+		return
+	}
+
+	changes := config.Diff(old.cfg, cfg)
+	next := &handlerState{
+		cfg:        cfg,
+		cfgVersion: version,
+		routes:     old.routes,
+		compiled:   old.compiled,
+		pools:      old.pools,
+		clients:    old.clients,
+		tlsClients: old.tlsClients,
+		limiter:    old.limiter,
+		trusted:    old.trusted,
+		breakers:   old.breakers,
+		checker:    old.checker,
+		cache:      old.cache,
+		jwt:        old.jwt,
+
+		retryBudget: old.retryBudget,
+		hedgeBudget: old.hedgeBudget,
+	}
+
+	// Everything that can fail is compiled before any shared component is
+	// touched. Files the config refers to may have changed since it was
+	// validated.
+	reject := func(err error) {
+		log.Printf("Config version %d not applied, still serving version %d: %v", version, old.cfgVersion, err)
+	}
+	var err error
+	recompiled := changes.Routes || changes.Upstreams || changes.CircuitBreaker
+	if recompiled {
+		if next.pools, err = compilePools(cfg); err != nil {
+			reject(err)
+			return
+		}
+		if next.routes, next.compiled, err = compileRoutes(cfg, next.pools); err != nil {
+			reject(err)
+			return
+		}
+	}
+	if changes.RateLimit {
+		if next.trusted, err = trustedProxies(cfg); err != nil {
+			reject(err)
+			return
+		}
+	}
+	if changes.JWT {
+		if next.jwt, err = newValidator(cfg); err != nil {
+			reject(err)
+			return
+		}
+	}
+
+	rebuildClients := changes.ConnectionPool
+	if rebuildClients {
+		next.clients = newClients(cfg)
+	}
+	// Upstream TLS settings only change with the upstreams, so other
+	// recompiles keep the existing connections
+	rebuildTLSClients := rebuildClients || changes.Upstreams
+	if rebuildTLSClients {
+		next.tlsClients = newTLSClients(cfg, next.pools)
+	}
+
+	// Syncing before the swap carries known target health over to the
+	// new pools, so a down target doesn't get traffic after a reload
+	if recompiled || rebuildTLSClients || changes.HealthChecks {
+		next.checker.Sync(next.pools, healthChecks(cfg, next.tlsClients))
+	}
+
+	if changes.RateLimitShape {
+		next.limiter = ratelimit.NewLimiter(
+			cfg.RateLimit.NumShards,
+			cfg.RateLimit.DefaultRate,
+			cfg.RateLimit.BurstSize,
+		)
+	} else if changes.RateLimit {
+		next.limiter.Reconfigure(cfg.RateLimit.DefaultRate, cfg.RateLimit.BurstSize)
+	}
+
+	// Breakers pick up new thresholds lazily from the recompiled routes
+	if changes.CircuitBreaker {
+		next.breakers.Resize(cfg.CircuitBreaker.MaxBreakers)
+	}
+
+	if changes.RetryBudget {
+		next.retryBudget.Reconfigure(
+			cfg.RetryBudget.Percent,
+			cfg.RetryBudget.MinPerSecond,
+			cfg.RetryBudget.WindowSeconds,
+		)
+	}
+
+	if changes.HedgeBudget {
+		next.hedgeBudget.Reconfigure(cfg.HedgeBudget.Percent, 0, cfg.HedgeBudget.WindowSeconds)
+	}
+
+	if changes.CacheSize {
+		next.cache.Resize(cfg.Cache.MaxEntries, cfg.Cache.MaxSize)
+	}
+
+	if changes.JWT && next.jwt != nil {
+		next.jwt.Start()
+	}
+
+	p.state.Store(next)
+
+	if changes.JWT && old.jwt != nil {
+		old.jwt.Stop()
+	}
+
+	if rebuildClients {
+		for _, client := range old.clients {
+			client.CloseIdleConnections()
+		}
+	}
+	if rebuildTLSClients {
+		for _, client := range old.tlsClients {
+			client.CloseIdleConnections()
+		}
+	}
+	if changes.Listener {
+		log.Printf("Config version %d changes listener addresses; restart required to apply", version)
+	}
+}
+
+// ConfigVersion returns the configuration version currently serving traffic
+func (p *ProxyHandler) ConfigVersion() int64 {
+	return p.state.Load().cfgVersion
+}
+
+// Breakers returns the circuit breaker registry
+func (p *ProxyHandler) Breakers() *circuitbreaker.Registry {
+	return p.state.Load().breakers
+}
+
+// HealthChecker returns the active upstream health checker
+func (p *ProxyHandler) HealthChecker() *healthcheck.Checker {
+	return p.state.Load().checker
+}
+
+// UpstreamStats returns per-pool target statistics
+func (p *ProxyHandler) UpstreamStats() map[string]interface{} {
+	s := p.state.Load()
+	stats := make(map[string]interface{}, len(s.pools))
+	for name, pool := range s.pools {
+		stats[name] = pool.Stats()
+	}
+	return stats
+}
+
+// RetryBudget returns the gateway-wide retry budget
+func (p *ProxyHandler) RetryBudget() *retry.Budget {
+	return p.state.Load().retryBudget
+}
+
+// HedgeBudget returns the gateway-wide hedge budget
+func (p *ProxyHandler) HedgeBudget() *retry.Budget {
+	return p.state.Load().hedgeBudget
+}
+
+// TunnelStats returns statistics on upgraded connections
+func (p *ProxyHandler) TunnelStats() map[string]interface{} {
+	return p.tunnels.Stats()
+}
+
+// DrainTunnels stops accepting protocol upgrades and waits for open
+// tunnels to close, closing any left when ctx is done. http.Server's
+// Shutdown does neither for hijacked connections.
+func (p *ProxyHandler) DrainTunnels(ctx context.Context) {
+	p.tunnels.Drain(ctx)
+}
+
+// JWTStats returns token verification statistics, or nil without JWT keys
+func (p *ProxyHandler) JWTStats() map[string]interface{} {
+	v := p.state.Load().jwt
+	if v == nil {
+		return nil
+	}
+	return v.Stats()
+}
+
+// APIKeyStats returns the size of the API key store, or nil without one
+func (p *ProxyHandler) APIKeyStats() map[string]interface{} {
+	store := p.state.Load().cfg.APIKeys.Store()
+	if store == nil {
+		return nil
+	}
+	return store.Stats()
+}
+
+// Cache returns the active response cache
+func (p *ProxyHandler) Cache() *cache.Cache {
+	return p.state.Load().cache
+}
@@ -0,0 +1,182 @@
+package proxy
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"gateway/config"
+)
+
+// reloadDoc is a config with one route to backend plus the extra top-level
+// fields, which start with a comma
+func reloadDoc(backend, extra string) string {
+	return fmt.Sprintf(`{
+		"rate_limit": {"enabled": false},
+		"routes": [{"path": "/*", "backend": %q, "methods": ["GET"]}]%s
+	}`, backend, extra)
+}
+
+func mustParse(t *testing.T, doc string) *config.Config {
+	t.Helper()
+	cfg, err := config.Parse([]byte(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+func TestReloadRebuildsChangedSections(t *testing.T) {
+	const backend = "http://127.0.0.1:1"
+	tests := []struct {
+		name    string
+		change  func(c *config.Config)
+		routes  bool // route table rebuilt
+		clients bool
+		limiter bool
+	}{
+		{"nothing", func(c *config.Config) {}, false, false, false},
+		{"route", func(c *config.Config) { c.Routes[0].Backend = "http://127.0.0.1:2" }, true, false, false},
+		{"breaker", func(c *config.Config) { c.CircuitBreaker.FailureThreshold++ }, true, false, false},
+		{"connection pool", func(c *config.Config) { c.ConnectionPool.MaxIdle++ }, false, true, false},
+		{"rate", func(c *config.Config) { c.RateLimit.DefaultRate++ }, false, false, false},
+		{"shards", func(c *config.Config) { c.RateLimit.NumShards++ }, false, false, true},
+		{"timeouts", func(c *config.Config) { c.Timeouts.TotalSeconds++ }, false, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestProxy(t, reloadDoc(backend, ""))
+			old := p.state.Load()
+
+			cfg := mustParse(t, reloadDoc(backend, ""))
+			tt.change(cfg)
+			p.Reload(cfg, old.cfgVersion+1)
+			next := p.state.Load()
+			if next.cfgVersion != old.cfgVersion+1 {
+				t.Fatalf("version = %d, want %d", next.cfgVersion, old.cfgVersion+1)
+			}
+			if got := next.routes != old.routes; got != tt.routes {
+				t.Errorf("routes rebuilt = %v, want %v", got, tt.routes)
+			}
+			if got := next.clients[config.ProtocolHTTP1] != old.clients[config.ProtocolHTTP1]; got != tt.clients {
+				t.Errorf("clients rebuilt = %v, want %v", got, tt.clients)
+			}
+			if got := next.limiter != old.limiter; got != tt.limiter {
+				t.Errorf("limiter rebuilt = %v, want %v", got, tt.limiter)
+			}
+			// State that outlives every reload
+			if next.breakers != old.breakers || next.cache != old.cache || next.retryBudget != old.retryBudget {
+				t.Error("breakers, cache or retry budget replaced")
+			}
+		})
+	}
+}
+
+// writePublicKey writes a PEM public key to a temporary file
+func writePublicKey(t *testing.T) string {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "key.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReloadKeepsLastGoodState(t *testing.T) {
+	const backend = "http://127.0.0.1:1"
+	p := newTestProxy(t, reloadDoc(backend, ""))
+	old := p.state.Load()
+
+	// Stale versions are ignored
+	p.Reload(mustParse(t, reloadDoc(backend, `, "timeouts": {"total": 7}`)), old.cfgVersion)
+	if p.state.Load() != old {
+		t.Fatal("stale version applied")
+	}
+
+	// The key file goes away between validation and the reload
+	key := writePublicKey(t)
+	broken := mustParse(t, reloadDoc("http://127.0.0.1:2",
+		fmt.Sprintf(`, "jwt": {"keys": [{"alg": "ES256", "public_key_file": %q}]}`, key)))
+	if err := os.Remove(key); err != nil {
+		t.Fatal(err)
+	}
+	p.Reload(broken, old.cfgVersion+1)
+	if p.state.Load() != old {
+		t.Fatal("config that failed to compile replaced the serving state")
+	}
+
+	// The next good version still applies
+	p.Reload(mustParse(t, reloadDoc("http://127.0.0.1:2", "")), old.cfgVersion+2)
+	if got := p.ConfigVersion(); got != old.cfgVersion+2 {
+		t.Errorf("version = %d, want %d", got, old.cfgVersion+2)
+	}
+}
+
+// Requests in flight during reloads see either the old or the new state,
+// never a mix
+func TestReloadSwapsAtomically(t *testing.T) {
+	backend := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, name)
+		}))
+	}
+	a, b := backend("a"), backend("b")
+	defer a.Close()
+	defer b.Close()
+
+	p := newTestProxy(t, reloadDoc(a.URL, ""))
+	configs := []*config.Config{mustParse(t, reloadDoc(a.URL, "")), mustParse(t, reloadDoc(b.URL, ""))}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				w := httptest.NewRecorder()
+				p.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/x", nil))
+				if body := w.Body.String(); w.Code != http.StatusOK || (body != "a" && body != "b") {
+					t.Errorf("status %d, body %q during reload", w.Code, body)
+					return
+				}
+			}
+		}()
+	}
+
+	version := p.ConfigVersion()
+	for i := 1; i <= 50; i++ {
+		p.Reload(configs[i%2], version+int64(i))
+	}
+	close(done)
+	wg.Wait()
+
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/x", nil))
+	if w.Body.String() != "a" {
+		t.Errorf("after the last reload got %q, want a", w.Body.String())
+	}
+}
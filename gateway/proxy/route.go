@@ -0,0 +1,318 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"gateway/balancer"
+	"gateway/circuitbreaker"
+	"gateway/config"
+	"gateway/healthcheck"
+	"gateway/jwt"
+	"gateway/ratelimit"
+	"gateway/retry"
+	"gateway/rewrite"
+	"gateway/router"
+)
+
+// route is a RouteConfig together with everything compiled from it for
+// one configuration version
+type route struct {
+	*config.RouteConfig
+	rewriter *rewrite.Rewriter
+	pool     *balancer.Pool
+
+	name            string // methods, path and predicates; unique within a table
+	breakerScope    string
+	breakerSettings circuitbreaker.Settings
+	classifier      *circuitbreaker.Classifier
+
+	retry *retry.Policy // nil when the route makes a single attempt
+
+	auth *jwt.Requirement // nil when the route needs no token
+
+	rateKey *ratelimit.KeyFunc // nil when keyed by client identity
+}
+
+// compilePools builds a pool for every named upstream plus an implicit
+// single-target pool for each distinct route backend, named by its URL.
+// Validation compiled them once already, but files they refer to (CA
+// bundles, client certs) may have changed since.
+func compilePools(cfg *config.Config) (map[string]*balancer.Pool, error) {
+	pools := make(map[string]*balancer.Pool, len(cfg.Upstreams))
+	for i := range cfg.Upstreams {
+		pool, err := cfg.Upstreams[i].Compile()
+		if err != nil {
+			return nil, fmt.Errorf("upstream %s: %w", cfg.Upstreams[i].Name, err)
+		}
+		pools[pool.Name] = pool
+	}
+
+	for _, rc := range cfg.Routes {
+		if rc.Backend == "" || pools[rc.Backend] != nil {
+			continue
+		}
+		implicit := config.UpstreamConfig{
+			Name:    rc.Backend,
+			Targets: []config.TargetConfig{{URL: rc.Backend}},
+		}
+		pool, err := implicit.Compile()
+		if err != nil {
+			return nil, fmt.Errorf("backend %s: %w", rc.Backend, err)
+		}
+		pools[pool.Name] = pool
+	}
+	return pools, nil
+}
+
+// retryPolicy compiles a route's retry config
+func retryPolicy(rc *config.RetryConfig) *retry.Policy {
+	p := &retry.Policy{
+		MaxAttempts:   rc.MaxAttempts,
+		On:            make(map[string]bool, len(rc.RetryOn)),
+		StatusCodes:   make(map[int]bool, len(rc.StatusCodes)),
+		BaseBackoff:   time.Duration(rc.BaseBackoffMs) * time.Millisecond,
+		MaxBackoff:    time.Duration(rc.MaxBackoffMs) * time.Millisecond,
+		NonIdempotent: rc.RetryNonIdempotent,
+		MaxBodyBytes:  rc.MaxBodyBytes,
+	}
+	for _, on := range rc.RetryOn {
+		p.On[on] = true
+	}
+	for _, code := range rc.StatusCodes {
+		p.StatusCodes[code] = true
+	}
+	return p
+}
+
+// healthChecks returns the probe settings of every pool that is actively
+// checked: upstreams with a health_check block and the pools of routes
+// with health_check enabled. Pools in tlsClients are probed with their
+// own client's transport.
+func healthChecks(cfg *config.Config, tlsClients map[string]*http.Client) map[string]healthcheck.Settings {
+	checks := make(map[string]healthcheck.Settings)
+	h2c := make(map[string]bool)
+	for _, u := range cfg.Upstreams {
+		h2c[u.Name] = u.Protocol == config.ProtocolH2C
+		if u.HealthCheck != nil {
+			checks[u.Name] = healthCheckSettings(&cfg.HealthChecks, u.HealthCheck, cfg.CircuitBreaker.HealthDecay)
+		}
+	}
+
+	for _, rc := range cfg.Routes {
+		if !rc.HealthCheck {
+			continue
+		}
+		name := rc.Upstream
+		if rc.Backend != "" {
+			name = rc.Backend
+		}
+		if _, ok := checks[name]; !ok {
+			checks[name] = healthCheckSettings(&cfg.HealthChecks, nil, cfg.CircuitBreaker.HealthDecay)
+		}
+	}
+
+	// Probes speak the upstream's protocol
+	for name, s := range checks {
+		s.H2C = h2c[name]
+		if c := tlsClients[name]; c != nil {
+			s.Transport = c.Transport
+		}
+		checks[name] = s
+	}
+	return checks
+}
+
+// healthCheckSettings merges an upstream override onto the global health
+// check config
+func healthCheckSettings(global, override *config.HealthCheckConfig, decay float64) healthcheck.Settings {
+	h := *global
+	if o := override; o != nil {
+		if o.Path != "" {
+			h.Path = o.Path
+		}
+		if o.IntervalSeconds > 0 {
+			h.IntervalSeconds = o.IntervalSeconds
+		}
+		if o.TimeoutSeconds > 0 {
+			h.TimeoutSeconds = o.TimeoutSeconds
+		}
+		if o.ExpectedStatusMin > 0 {
+			h.ExpectedStatusMin = o.ExpectedStatusMin
+		}
+		if o.ExpectedStatusMax > 0 {
+			h.ExpectedStatusMax = o.ExpectedStatusMax
+		}
+		if o.ExpectedBody != "" {
+			h.ExpectedBody = o.ExpectedBody
+		}
+		if o.HealthyThreshold > 0 {
+			h.HealthyThreshold = o.HealthyThreshold
+		}
+		if o.UnhealthyThreshold > 0 {
+			h.UnhealthyThreshold = o.UnhealthyThreshold
+		}
+	}
+
+	return healthcheck.Settings{
+		Path:               h.Path,
+		Interval:           time.Duration(h.IntervalSeconds) * time.Second,
+		Timeout:            time.Duration(h.TimeoutSeconds) * time.Second,
+		StatusMin:          h.ExpectedStatusMin,
+		StatusMax:          h.ExpectedStatusMax,
+		Body:               h.ExpectedBody,
+		HealthyThreshold:   h.HealthyThreshold,
+		UnhealthyThreshold: h.UnhealthyThreshold,
+		HealthDecay:        decay,
+	}
+}
+
+// compileRoutes builds the route table and per-route state for cfg
+func compileRoutes(cfg *config.Config, pools map[string]*balancer.Pool) (*router.Table, []*route, error) {
+	table := router.New()
+	compiled := make([]*route, len(cfg.Routes))
+	classifier := circuitbreaker.NewClassifier(
+		cfg.CircuitBreaker.FailureStatusCodes,
+		cfg.CircuitBreaker.FailureGRPCCodes,
+		time.Duration(cfg.CircuitBreaker.SlowCallThresholdMs)*time.Millisecond,
+	)
+	for i := range cfg.Routes {
+		rc := &cfg.Routes[i]
+		preds, err := rc.CompilePredicates()
+		if err == nil {
+			err = table.Add(rc.Path, rc.Methods, preds, i)
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("route table: %w", err)
+		}
+
+		rt := &route{
+			RouteConfig:     rc,
+			name:            routeName(rc, preds),
+			pool:            pools[rc.Upstream],
+			breakerScope:    cfg.CircuitBreaker.Scope,
+			breakerSettings: breakerSettings(&cfg.CircuitBreaker, rc.CircuitBreaker),
+			classifier:      classifier,
+		}
+		if rc.Backend != "" {
+			rt.pool = pools[rc.Backend]
+		}
+		if rc.Retry != nil {
+			rt.retry = retryPolicy(rc.Retry)
+		}
+		if rc.JWT != nil {
+			rt.auth = rc.JWT.Compile()
+		}
+		if rt.rateKey, err = rc.CompileRateLimitKey(); err != nil {
+			return nil, nil, fmt.Errorf("route %s: %w", rc.Path, err)
+		}
+		if rc.Rewrite != nil {
+			if rt.rewriter, err = rc.Rewrite.Compile(); err != nil {
+				return nil, nil, fmt.Errorf("route %s rewrite: %w", rc.Path, err)
+			}
+		}
+		compiled[i] = rt
+	}
+	return table, compiled, nil
+}
+
+// routeName identifies a route by what it matches rather than its position,
+// so routes sharing a path are told apart and a route keeps its name when
+// a reload reorders the list
+func routeName(rc *config.RouteConfig, preds []router.Predicate) string {
+	name := strings.Join(rc.Methods, ",") + " " + rc.Path
+	if len(preds) == 0 {
+		return name
+	}
+	conds := make([]string, len(preds))
+	for i, p := range preds {
+		conds[i] = p.String()
+	}
+	sort.Strings(conds)
+	return name + " if " + strings.Join(conds, " && ")
+}
+
+// breakerSettings merges a route override onto the global breaker config
+func breakerSettings(global *config.CircuitConfig, override *config.CircuitOverride) circuitbreaker.Settings {
+	s := circuitbreaker.Settings{
+		FailureThreshold: global.FailureThreshold,
+		SuccessThreshold: global.SuccessThreshold,
+		TimeoutSeconds:   global.TimeoutSeconds,
+		HealthDecay:      global.HealthDecay,
+
+		FailureRateThreshold:  global.FailureRateThreshold,
+		SlowCallRateThreshold: global.SlowCallRateThreshold,
+		WindowSeconds:         global.WindowSeconds,
+		MinimumCalls:          global.MinimumCalls,
+
+		HalfOpenMaxCalls:  global.HalfOpenMaxCalls,
+		BackoffMultiplier: global.BackoffMultiplier,
+		MaxTimeoutSeconds: global.MaxTimeoutSeconds,
+	}
+	if override != nil {
+		if override.FailureThreshold > 0 {
+			s.FailureThreshold = override.FailureThreshold
+		}
+		if override.SuccessThreshold > 0 {
+			s.SuccessThreshold = override.SuccessThreshold
+		}
+		if override.TimeoutSeconds > 0 {
+			s.TimeoutSeconds = override.TimeoutSeconds
+		}
+	}
+	return s
+}
+
+// flushInterval is how long streamed response data may wait before being
+// flushed to the client
+func (rt *route) flushInterval() time.Duration {
+	return time.Duration(rt.FlushIntervalMs) * time.Millisecond
+}
+
+// breakerKey names the breaker guarding requests from this route to
+// target. With target scope, routes share a target's breaker unless they
+// override its thresholds, in which case they get their own.
+func (rt *route) breakerKey(target *balancer.Target) string {
+	if rt.breakerScope == config.BreakerScopeRoute {
+		return "route " + rt.name
+	}
+	if rt.CircuitBreaker != nil {
+		return target.String() + " via " + rt.name
+	}
+	return target.String()
+}
+
+// upstreamURL returns the path and query to request from the backend,
+// applying the route's rewrite rules to the public path. The rules are
+// applied to the escaped path so encoded characters such as %2F reach the
+// backend as sent.
+func (rt *route) upstreamURL(r *http.Request) *url.URL {
+	if rt.rewriter == nil {
+		return r.URL
+	}
+
+	var params router.Params
+	if m := router.FromContext(r.Context()); m != nil {
+		params = m.Params
+	}
+	escaped := rt.rewriter.Rewrite(r.URL.EscapedPath(), func(name string) string {
+		return (&url.URL{Path: params.Get(name)}).EscapedPath()
+	})
+	path, err := url.PathUnescape(escaped)
+	if err != nil {
+		// A replacement produced an invalid escape; use the decoded path
+		return &url.URL{
+			Path:     rt.rewriter.Rewrite(r.URL.Path, params.Get),
+			RawQuery: r.URL.RawQuery,
+		}
+	}
+	return &url.URL{
+		Path:     path,
+		RawPath:  escaped,
+		RawQuery: r.URL.RawQuery,
+	}
+}
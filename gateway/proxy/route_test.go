@@ -0,0 +1,98 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBreakerKey(t *testing.T) {
+	const routes = `
+		{"path": "/api/*", "upstream": "u", "methods": ["GET"]},
+		{"path": "/api/*", "upstream": "u", "methods": ["POST"]},
+		{"path": "/api/*", "upstream": "u", "methods": ["GET"],
+		 "predicates": [{"type": "host", "values": ["b.example", "a.example"]}, {"type": "header", "name": "x-beta"}]},
+		{"path": "/reports", "upstream": "u", "methods": ["GET"], "circuit_breaker": {"failure_threshold": 20}}`
+	tests := []struct {
+		scope string
+		want  []string // by route index
+	}{
+		{"target", []string{
+			"http://a:1",
+			"http://a:1",
+			"http://a:1",
+			"http://a:1 via GET /reports",
+		}},
+		{"route", []string{
+			"route GET /api/*",
+			"route POST /api/*",
+			"route GET /api/* if header X-Beta present && host in [a.example,b.example]",
+			"route GET /reports",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.scope, func(t *testing.T) {
+			cfg := mustParse(t, `{
+				"circuit_breaker": {"scope": "`+tt.scope+`"},
+				"upstreams": [{"name": "u", "targets": [{"url": "http://a:1"}]}],
+				"routes": [`+routes+`]
+			}`)
+			pools, err := compilePools(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, compiled, err := compileRoutes(cfg, pools)
+			if err != nil {
+				t.Fatal(err)
+			}
+			target := pools["u"].Targets[0]
+			for i, rt := range compiled {
+				if got := rt.breakerKey(target); got != tt.want[i] {
+					t.Errorf("route %d: breakerKey() = %q, want %q", i, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestUpstreamURL(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.RequestURI)
+	}))
+	defer backend.Close()
+
+	tests := []struct {
+		name    string
+		path    string
+		rewrite string
+		uri     string
+		want    string
+	}{
+		{"no rewrite", "/files/*", "", "/files/a%2Fb?x=1", "/files/a%2Fb?x=1"},
+		{"strip prefix", "/files/*", `{"strip_prefix": "/files"}`, "/files/a%2Fb?x=1", "/a%2Fb?x=1"},
+		{"add prefix", "/files/*", `{"strip_prefix": "/files", "add_prefix": "/v2"}`, "/files/a%2Fb/c", "/v2/a%2Fb/c"},
+		{"regex", "/files/*", `{"regex": "^/files/(.*)$", "replacement": "/blobs/$1"}`, "/files/a%2Fb", "/blobs/a%2Fb"},
+		{"template", "/users/{id}", `{"template": "/v2/users/{id}"}`, "/users/a%20b", "/v2/users/a%20b"},
+		{"plain path", "/files/*", `{"strip_prefix": "/files"}`, "/files/a/b", "/a/b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rewrite := ""
+			if tt.rewrite != "" {
+				rewrite = `, "rewrite": ` + tt.rewrite
+			}
+			p := newTestProxy(t, fmt.Sprintf(`{
+				"rate_limit": {"enabled": false},
+				"routes": [{"path": %q, "backend": %q, "methods": ["GET"]%s}]
+			}`, tt.path, backend.URL, rewrite))
+
+			w := httptest.NewRecorder()
+			p.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.uri, nil))
+			if w.Code != http.StatusOK || w.Body.String() != tt.want {
+				t.Errorf("status %d, backend saw %q, want %q", w.Code, w.Body.String(), tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,196 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"net/http"
+	"sync"
+	"time"
+
+	"gateway/router"
+)
+
+// closeHook runs fn once after the wrapped body is closed
+type closeHook struct {
+	io.ReadCloser
+	once sync.Once
+	fn   func()
+}
+
+func (b *closeHook) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.fn)
+	return err
+}
+
+// onClose arranges for fn to run when resp's body is closed. Resources that
+// must outlive the round trip, such as the request context and the
+// target's in-flight slot, are released this way.
+func onClose(resp *http.Response, fn func()) {
+	resp.Body = &closeHook{ReadCloser: resp.Body, fn: fn}
+}
+
+// discard drains a little of resp's body so its connection can be reused,
+// then closes it
+func discard(resp *http.Response) {
+	io.CopyN(io.Discard, resp.Body, 4<<10)
+	resp.Body.Close()
+}
+
+// bufferResponse reads resp's whole body and closes it if the body is at
+// most limit bytes long. Larger bodies, and server-sent events and gRPC
+// messages, which may never end, are left to stream and reported false;
+// whatever was read of them is streamed first.
+func bufferResponse(resp *http.Response, limit int64) (*Response, bool, error) {
+	if resp.ContentLength > limit || isStream(resp.Header) {
+		return nil, false, nil
+	}
+
+	buf, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		resp.Body.Close()
+		return nil, false, err
+	}
+	if int64(len(buf)) > limit {
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(buf), resp.Body), resp.Body}
+		return nil, false, nil
+	}
+	resp.Body.Close()
+
+	return &Response{
+		StatusCode: resp.StatusCode,
+		Headers:    resp.Header,
+		Body:       buf,
+	}, true, nil
+}
+
+// isStream reports whether h belongs to a server-sent event stream or a
+// gRPC call, whose messages are relayed as soon as they arrive
+func isStream(h http.Header) bool {
+	ct, _, _ := mime.ParseMediaType(h.Get("Content-Type"))
+	return ct == "text/event-stream" || router.IsGRPC(h)
+}
+
+// writeStream copies resp's headers and status to w, then streams its body
+// as it arrives, followed by its trailers, and closes it. Server-sent
+// events and gRPC messages are flushed immediately; other bodies are
+// flushed at most every interval, or after every chunk when interval is
+// zero.
+func writeStream(w http.ResponseWriter, resp *http.Response, interval time.Duration) error {
+	defer resp.Body.Close()
+	extendDeadlines(w)
+
+	for k, v := range resp.Header {
+		for _, val := range v {
+			w.Header().Add(k, val)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+
+	// Event streams and gRPC calls may go quiet for a long time, so the
+	// client gets their headers without waiting for the first message
+	if isStream(resp.Header) {
+		interval = 0
+		http.NewResponseController(w).Flush()
+	}
+
+	fw := newFlushWriter(w, interval)
+	defer fw.stop()
+
+	buf := make([]byte, 32<<10)
+	for {
+		n, err := resp.Body.Read(buf)
+		if n > 0 {
+			if _, werr := fw.Write(buf[:n]); werr != nil {
+				return werr
+			}
+		}
+		if err == io.EOF {
+			// Trailers are only complete once the body has been read
+			for k, v := range resp.Trailer {
+				for _, val := range v {
+					w.Header().Add(http.TrailerPrefix+k, val)
+				}
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// extendDeadlines lifts the server's read and write timeouts, which are
+// meant for ordinary requests, off a request whose body or response streams
+// for as long as the client and upstream keep it going
+func extendDeadlines(w http.ResponseWriter) {
+	rc := http.NewResponseController(w)
+	rc.SetReadDeadline(time.Time{})
+	rc.SetWriteDeadline(time.Time{})
+}
+
+// flushWriter flushes writes to the client no later than interval after
+// they were made, so a slow trickle of data isn't held in buffers
+type flushWriter struct {
+	mu       sync.Mutex
+	w        http.ResponseWriter
+	flusher  http.Flusher
+	interval time.Duration
+	timer    *time.Timer
+	pending  bool
+}
+
+func newFlushWriter(w http.ResponseWriter, interval time.Duration) *flushWriter {
+	flusher, _ := w.(http.Flusher)
+	return &flushWriter{w: w, flusher: flusher, interval: interval}
+}
+
+func (fw *flushWriter) Write(p []byte) (int, error) {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+
+	n, err := fw.w.Write(p)
+	if err != nil || fw.flusher == nil {
+		return n, err
+	}
+
+	if fw.interval <= 0 {
+		fw.flusher.Flush()
+		return n, nil
+	}
+	if !fw.pending {
+		fw.pending = true
+		if fw.timer == nil {
+			fw.timer = time.AfterFunc(fw.interval, fw.delayedFlush)
+		} else {
+			fw.timer.Reset(fw.interval)
+		}
+	}
+	return n, nil
+}
+
+func (fw *flushWriter) delayedFlush() {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+
+	if fw.pending {
+		fw.flusher.Flush()
+		fw.pending = false
+	}
+}
+
+// stop cancels any scheduled flush. The server flushes what remains when
+// the handler returns.
+func (fw *flushWriter) stop() {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+
+	fw.pending = false
+	if fw.timer != nil {
+		fw.timer.Stop()
+	}
+}
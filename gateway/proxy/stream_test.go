@@ -0,0 +1,137 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Responses without a Content-Length are cached like any other as long as
+// they fit, and stream intact when they don't
+func TestBufferChunkedResponse(t *testing.T) {
+	var fetches atomic.Int64
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		size := 100
+		if r.URL.Path == "/large" {
+			size = 3000
+		}
+		// Flushing before the end makes the response chunked
+		for i := 0; i < size/100; i++ {
+			fmt.Fprint(w, strings.Repeat("x", 100))
+			w.(http.Flusher).Flush()
+		}
+	}))
+	defer backend.Close()
+
+	p := newTestProxy(t, fmt.Sprintf(`{
+		"rate_limit": {"enabled": false},
+		"cache": {"enabled": true, "max_object_bytes": 1000},
+		"routes": [{"path": "/*", "backend": %q, "methods": ["GET"], "timeout_seconds": 5, "enable_cache": true}]
+	}`, backend.URL))
+
+	tests := []struct {
+		path    string
+		xcache  string
+		size    int
+		fetches int64
+	}{
+		{"/small", "MISS", 100, 1},
+		{"/small", "HIT", 100, 1},
+		{"/small", "HIT", 100, 1},
+		{"/large", "MISS", 3000, 2},
+		{"/large", "MISS", 3000, 3},
+	}
+	for i, tt := range tests {
+		w := httptest.NewRecorder()
+		p.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if got := w.Header().Get("X-Cache"); got != tt.xcache {
+			t.Errorf("request %d for %s: X-Cache %s, want %s", i, tt.path, got, tt.xcache)
+		}
+		if w.Body.Len() != tt.size {
+			t.Errorf("request %d for %s: %d bytes, want %d", i, tt.path, w.Body.Len(), tt.size)
+		}
+		if n := fetches.Load(); n != tt.fetches {
+			t.Errorf("request %d for %s: upstream fetched %d times, want %d", i, tt.path, n, tt.fetches)
+		}
+	}
+}
+
+// Streams run past the route timeout, timeouts.total and the server's
+// write timeout, which only bound the wait for response headers
+func TestStreamPastTimeouts(t *testing.T) {
+	const events = 6
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.Header().Set("Content-Type", "text/event-stream")
+		for i := 0; i < events; i++ {
+			fmt.Fprintf(w, "data: %d\n\n", i)
+			w.(http.Flusher).Flush()
+			time.Sleep(300 * time.Millisecond)
+		}
+	}))
+	defer backend.Close()
+
+	p := newTestProxy(t, fmt.Sprintf(`{
+		"rate_limit": {"enabled": false},
+		"timeouts": {"total": 1},
+		"routes": [{"path": "/*", "backend": %q, "methods": ["GET", "POST"], "timeout_seconds": 1}]
+	}`, backend.URL))
+	gateway := httptest.NewUnstartedServer(p)
+	gateway.Config.ReadTimeout = time.Second
+	gateway.Config.WriteTimeout = time.Second
+	gateway.Start()
+	defer gateway.Close()
+
+	for _, method := range []string{http.MethodGet, http.MethodPost} {
+		t.Run(method, func(t *testing.T) {
+			req, err := http.NewRequest(method, gateway.URL+"/events", strings.NewReader("x"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("stream broke after %q: %v", body, err)
+			}
+			if n := strings.Count(string(body), "data: "); n != events {
+				t.Errorf("got %d events, want %d", n, events)
+			}
+		})
+	}
+}
+
+// A response whose headers don't arrive within the route timeout fails
+func TestHeaderTimeout(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer backend.Close()
+
+	p := newTestProxy(t, fmt.Sprintf(`{
+		"rate_limit": {"enabled": false},
+		"routes": [{"path": "/*", "backend": %q, "methods": ["GET"], "timeout_seconds": 1}]
+	}`, backend.URL))
+	start := time.Now()
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/slow", nil))
+	if w.Code != http.StatusBadGateway {
+		t.Errorf("status %d, want %d", w.Code, http.StatusBadGateway)
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("gave up after %v, want about 1s", elapsed)
+	}
+}
@@ -0,0 +1,387 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gateway/balancer"
+	"gateway/config"
+)
+
+// Reasons a tunnel was closed, reported in tunnel statistics
+const (
+	closedByClient   = "client"
+	closedByUpstream = "upstream"
+	closedIdle       = "idle"
+	closedLifetime   = "lifetime"
+	closedShutdown   = "shutdown"
+)
+
+var errTunnelRefused = errors.New("upgraded connection refused")
+
+// isUpgrade reports whether r asks to switch protocols, as a WebSocket
+// handshake does
+func isUpgrade(r *http.Request) bool {
+	if r.Header.Get("Upgrade") == "" {
+		return false
+	}
+	for _, v := range r.Header["Connection"] {
+		for _, token := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// serveUpgrade forwards a protocol upgrade to one target. When the target
+// switches protocols, the client connection is hijacked and bytes are
+// piped both ways until either side closes, the tunnel idles out or
+// outlives the route's limit, or the gateway shuts down. Any other answer
+// is relayed as a normal response.
+func (p *ProxyHandler) serveUpgrade(w http.ResponseWriter, r *http.Request, s *handlerState, route *route, start time.Time) {
+	// Only HTTP/1 connections can be taken over
+	if r.ProtoMajor != 1 {
+		http.Error(w, "Upgrade requires HTTP/1.1", http.StatusHTTPVersionNotSupported)
+		p.collector.RecordRequest(route.Path, time.Since(start), http.StatusHTTPVersionNotSupported, false)
+		return
+	}
+
+	t, err := p.tunnels.reserve(route.Path, route.Upgrade.MaxConnections)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		p.collector.RecordRequest(route.Path, time.Since(start), http.StatusServiceUnavailable, false)
+		return
+	}
+	defer p.tunnels.remove(t)
+
+	target, err := route.pool.Pick(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		p.collector.RecordRequest(route.Path, time.Since(start), http.StatusBadGateway, false)
+		return
+	}
+
+	resp, release, err := p.handshake(s, r, route, target)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		p.collector.RecordRequest(route.Path, time.Since(start), http.StatusBadGateway, false)
+		return
+	}
+	defer release()
+
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		writeStream(w, resp, route.flushInterval())
+		p.recordResponse(route, resp, time.Since(start))
+		return
+	}
+
+	upstream, ok := resp.Body.(io.ReadWriteCloser)
+	if !ok || !strings.EqualFold(resp.Header.Get("Upgrade"), r.Header.Get("Upgrade")) {
+		resp.Body.Close()
+		http.Error(w, "upstream switched to an unexpected protocol", http.StatusBadGateway)
+		p.collector.RecordRequest(route.Path, time.Since(start), http.StatusBadGateway, false)
+		return
+	}
+
+	conn, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		upstream.Close()
+		return
+	}
+
+	// Server read and write timeouts are meant for requests, not tunnels
+	conn.SetDeadline(time.Time{})
+
+	fmt.Fprintf(brw, "HTTP/1.1 %s\r\n", resp.Status)
+	resp.Header.Write(brw)
+	brw.WriteString("\r\n")
+	if err := brw.Flush(); err != nil {
+		conn.Close()
+		upstream.Close()
+		return
+	}
+	p.collector.RecordRequest(route.Path, time.Since(start), resp.StatusCode, false)
+
+	t.run(conn, brw.Reader, upstream,
+		time.Duration(route.Upgrade.IdleTimeoutSeconds)*time.Second,
+		time.Duration(route.Upgrade.MaxLifetimeSeconds)*time.Second)
+}
+
+// handshake sends the upgrade request to target, over the HTTP/1.1
+// transport directly since upgrades don't exist in HTTP/2. The route
+// timeout bounds only the wait for the response headers. release must be
+// called once the response or tunnel is done with.
+func (p *ProxyHandler) handshake(s *handlerState, r *http.Request, route *route,
+	target *balancer.Target) (*http.Response, func(), error) {
+
+	target.Acquire()
+	ctx, stop, cancel := withHeaderTimeout(r.Context(), time.Duration(route.Timeout)*time.Second)
+	release := func() {
+		cancel()
+		target.Release()
+	}
+
+	req, err := newUpstreamRequest(ctx, r, route, target, r.Body)
+	if err != nil {
+		release()
+		return nil, nil, err
+	}
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", r.Header.Get("Upgrade"))
+
+	transport := s.clients[config.ProtocolHTTP1].Transport
+	if c := s.tlsClients[route.pool.Name]; c != nil && route.pool.Protocol == config.ProtocolHTTP1 {
+		transport = c.Transport
+	}
+	resp, err := s.send(ctx, route, target, req, transport.RoundTrip)
+	if !stop() && err == nil {
+		resp.Body.Close()
+		err = context.DeadlineExceeded
+	}
+	if err != nil {
+		release()
+		return nil, nil, err
+	}
+	return resp, release, nil
+}
+
+// tunnel is one upgraded connection
+type tunnel struct {
+	route      string
+	client     net.Conn
+	upstream   io.ReadWriteCloser
+	lastActive atomic.Int64 // unix nanoseconds
+	bytesIn    atomic.Int64 // client to upstream
+	bytesOut   atomic.Int64 // upstream to client
+
+	mu     sync.Mutex
+	reason string
+	closed bool
+}
+
+// run pipes client and upstream together and returns once both directions
+// are done
+func (t *tunnel) run(client net.Conn, clientReader io.Reader, upstream io.ReadWriteCloser, idle, lifetime time.Duration) {
+	t.mu.Lock()
+	t.client, t.upstream = client, upstream
+	closed := t.closed
+	t.mu.Unlock()
+	if closed {
+		// Shutdown got here first
+		client.Close()
+		upstream.Close()
+		return
+	}
+
+	t.touch()
+	ended := make(chan string, 2)
+	go t.copy(upstream, clientReader, &t.bytesIn, ended, closedByClient)
+	go t.copy(client, upstream, &t.bytesOut, ended, closedByUpstream)
+
+	tick := min(idle, time.Second)
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+
+	var deadline <-chan time.Time
+	if lifetime > 0 {
+		timer := time.NewTimer(lifetime)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
+	for {
+		select {
+		case side := <-ended:
+			t.close(side)
+			<-ended
+			return
+		case <-ticker.C:
+			if time.Since(time.Unix(0, t.lastActive.Load())) >= idle {
+				t.close(closedIdle)
+			}
+		case <-deadline:
+			t.close(closedLifetime)
+		}
+	}
+}
+
+// copy moves bytes from src to dst, marking the tunnel active as they flow,
+// and reports side on ended when src is exhausted or either end fails
+func (t *tunnel) copy(dst io.Writer, src io.Reader, counter *atomic.Int64, ended chan<- string, side string) {
+	buf := make([]byte, 32<<10)
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			t.touch()
+			counter.Add(int64(n))
+			if _, werr := dst.Write(buf[:n]); werr != nil {
+				break
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+	ended <- side
+}
+
+func (t *tunnel) touch() {
+	t.lastActive.Store(time.Now().UnixNano())
+}
+
+// close closes both ends of the tunnel, recording why. Only the first
+// reason counts.
+func (t *tunnel) close(reason string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closed {
+		return
+	}
+	t.closed = true
+	t.reason = reason
+	if t.client != nil {
+		t.client.Close()
+		t.upstream.Close()
+	}
+}
+
+// tunnelSet tracks upgraded connections so they can be capped per route,
+// counted, and closed on shutdown. Hijacked connections are invisible to
+// http.Server, which neither waits for nor closes them.
+type tunnelSet struct {
+	mu       sync.Mutex
+	open     map[*tunnel]struct{}
+	perRoute map[string]int
+	draining bool
+
+	opened   int64
+	rejected int64
+	closed   map[string]int64
+	bytesIn  int64
+	bytesOut int64
+}
+
+func newTunnelSet() *tunnelSet {
+	return &tunnelSet{
+		open:     make(map[*tunnel]struct{}),
+		perRoute: make(map[string]int),
+		closed:   make(map[string]int64),
+	}
+}
+
+// reserve claims a connection slot on route, refusing once the route has
+// max open tunnels or the gateway is draining
+func (ts *tunnelSet) reserve(route string, max int) (*tunnel, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if ts.draining {
+		ts.rejected++
+		return nil, fmt.Errorf("%w: gateway is shutting down", errTunnelRefused)
+	}
+	if ts.perRoute[route] >= max {
+		ts.rejected++
+		return nil, fmt.Errorf("%w: route has %d open connections", errTunnelRefused, max)
+	}
+
+	t := &tunnel{route: route}
+	ts.open[t] = struct{}{}
+	ts.perRoute[route]++
+	return t, nil
+}
+
+// remove frees t's slot. Tunnels that never switched protocols are not
+// counted as opened.
+func (ts *tunnelSet) remove(t *tunnel) {
+	t.mu.Lock()
+	reason, upgraded := t.reason, t.client != nil
+	t.mu.Unlock()
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	delete(ts.open, t)
+	if ts.perRoute[t.route]--; ts.perRoute[t.route] == 0 {
+		delete(ts.perRoute, t.route)
+	}
+	if upgraded {
+		ts.opened++
+		ts.closed[reason]++
+		ts.bytesIn += t.bytesIn.Load()
+		ts.bytesOut += t.bytesOut.Load()
+	}
+}
+
+// Drain refuses new tunnels and waits for open ones to finish. Those still
+// open when ctx is done are closed.
+func (ts *tunnelSet) Drain(ctx context.Context) {
+	ts.mu.Lock()
+	ts.draining = true
+	ts.mu.Unlock()
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		ts.mu.Lock()
+		n := len(ts.open)
+		ts.mu.Unlock()
+		if n == 0 {
+			return
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			ts.mu.Lock()
+			for t := range ts.open {
+				t.close(closedShutdown)
+			}
+			ts.mu.Unlock()
+			return
+		}
+	}
+}
+
+// Stats returns tunnel statistics
+func (ts *tunnelSet) Stats() map[string]interface{} {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	perRoute := make(map[string]int, len(ts.perRoute))
+	for route, n := range ts.perRoute {
+		perRoute[route] = n
+	}
+	closed := make(map[string]int64, len(ts.closed))
+	for reason, n := range ts.closed {
+		closed[reason] = n
+	}
+
+	// Open tunnels contribute the bytes they have moved so far
+	bytesIn, bytesOut := ts.bytesIn, ts.bytesOut
+	for t := range ts.open {
+		bytesIn += t.bytesIn.Load()
+		bytesOut += t.bytesOut.Load()
+	}
+
+	return map[string]interface{}{
+		"open":      len(ts.open),
+		"per_route": perRoute,
+		"opened":    ts.opened,
+		"rejected":  ts.rejected,
+		"closed":    closed,
+		"bytes_in":  bytesIn,
+		"bytes_out": bytesOut,
+		"draining":  ts.draining,
+	}
+}
@@ -0,0 +1,287 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// upgradeBackend switches /ws/* requests to the requested protocol and
+// echoes everything back. /ws/refuse answers normally, /ws/other switches
+// to another protocol and /ws/bye says bye and hangs up.
+func upgradeBackend() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/ws/refuse" {
+			http.Error(w, "no upgrade here", http.StatusForbidden)
+			return
+		}
+		protocol := r.Header.Get("Upgrade")
+		if r.URL.Path == "/ws/other" {
+			protocol = "other"
+		}
+		conn, brw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		fmt.Fprintf(brw, "HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: %s\r\n\r\n", protocol)
+		brw.Flush()
+		if r.URL.Path == "/ws/bye" {
+			conn.Write([]byte("bye"))
+			return
+		}
+		io.Copy(conn, brw)
+	}))
+}
+
+// newUpgradeGateway serves a proxy with one upgradable route to backend
+func newUpgradeGateway(t *testing.T, backend string, upgrade string) (*ProxyHandler, *httptest.Server) {
+	t.Helper()
+	p := newTestProxy(t, fmt.Sprintf(`{
+		"rate_limit": {"enabled": false},
+		"routes": [{"path": "/ws/*", "backend": %q, "methods": ["GET"], "timeout_seconds": 1, "upgrade": %s}]
+	}`, backend, upgrade))
+	gateway := httptest.NewServer(p)
+	t.Cleanup(gateway.Close)
+	return p, gateway
+}
+
+// dialUpgrade sends an upgrade request for path and reads the answer
+func dialUpgrade(t *testing.T, gateway *httptest.Server, path string) (net.Conn, *bufio.Reader, *http.Response) {
+	t.Helper()
+	conn, err := net.Dial("tcp", gateway.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	fmt.Fprintf(conn, "GET %s HTTP/1.1\r\nHost: gateway\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n", path)
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn, br, resp
+}
+
+// eventually reports whether cond holds within a few seconds
+func eventually(cond func() bool) bool {
+	for deadline := time.Now().Add(3 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if cond() {
+			return true
+		}
+	}
+	return false
+}
+
+// closedBy returns how many tunnels closed for reason
+func closedBy(p *ProxyHandler, reason string) int64 {
+	return p.TunnelStats()["closed"].(map[string]int64)[reason]
+}
+
+func TestUpgradeHandshake(t *testing.T) {
+	backend := upgradeBackend()
+	defer backend.Close()
+	_, gateway := newUpgradeGateway(t, backend.URL, `{"idle_timeout_seconds": 10, "max_connections": 5}`)
+
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{"/ws/echo", http.StatusSwitchingProtocols},
+		{"/ws/refuse", http.StatusForbidden},
+		{"/ws/other", http.StatusBadGateway},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			_, _, resp := dialUpgrade(t, gateway, tt.path)
+			if resp.StatusCode != tt.status {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.status)
+			}
+			if tt.status == http.StatusSwitchingProtocols && resp.Header.Get("Upgrade") != "echo" {
+				t.Errorf("Upgrade = %q, want echo", resp.Header.Get("Upgrade"))
+			}
+		})
+	}
+}
+
+func TestTunnelCopy(t *testing.T) {
+	backend := upgradeBackend()
+	defer backend.Close()
+	p, gateway := newUpgradeGateway(t, backend.URL, `{"idle_timeout_seconds": 10, "max_connections": 5}`)
+
+	// Both directions carry data until the client hangs up
+	conn, br, _ := dialUpgrade(t, gateway, "/ws/echo")
+	buf := make([]byte, 5)
+	for _, msg := range []string{"one..", "two..", "three"} {
+		conn.Write([]byte(msg))
+		if _, err := io.ReadFull(br, buf); err != nil || string(buf) != msg {
+			t.Fatalf("echo of %q = %q, %v", msg, buf, err)
+		}
+	}
+	conn.Close()
+	if !eventually(func() bool { return closedBy(p, closedByClient) == 1 }) {
+		t.Fatalf("tunnel not closed by the client: %v", p.TunnelStats())
+	}
+	if stats := p.TunnelStats(); stats["bytes_in"] != int64(15) || stats["bytes_out"] != int64(15) || stats["open"] != 0 {
+		t.Errorf("stats = %v, want 15 bytes each way and none open", stats)
+	}
+
+	// The upstream hanging up reaches the client
+	_, br, _ = dialUpgrade(t, gateway, "/ws/bye")
+	if got, err := io.ReadAll(br); string(got) != "bye" {
+		t.Errorf("read %q, %v, want bye", got, err)
+	}
+	if !eventually(func() bool { return closedBy(p, closedByUpstream) == 1 }) {
+		t.Errorf("tunnel not closed by the upstream: %v", p.TunnelStats())
+	}
+}
+
+func TestTunnelTimeouts(t *testing.T) {
+	backend := upgradeBackend()
+	// Outlives the parallel subtests
+	t.Cleanup(backend.Close)
+
+	tests := []struct {
+		name     string
+		upgrade  string
+		chatty   bool // client sends every 200ms
+		reason   string
+		min, max time.Duration // since the handshake, which the timers start before
+	}{
+		{"idle", `{"idle_timeout_seconds": 1, "max_connections": 5}`, false, closedIdle, 900 * time.Millisecond, 2500 * time.Millisecond},
+		{"active connection stays", `{"idle_timeout_seconds": 1, "max_lifetime_seconds": 2, "max_connections": 5}`, true, closedLifetime, 1900 * time.Millisecond, 3 * time.Second},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			p, gateway := newUpgradeGateway(t, backend.URL, tt.upgrade)
+			conn, br, resp := dialUpgrade(t, gateway, "/ws/echo")
+			if resp.StatusCode != http.StatusSwitchingProtocols {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusSwitchingProtocols)
+			}
+			start := time.Now()
+
+			if tt.chatty {
+				go func() {
+					for {
+						if _, err := conn.Write([]byte("x")); err != nil {
+							return
+						}
+						time.Sleep(200 * time.Millisecond)
+					}
+				}()
+			}
+			io.Copy(io.Discard, br)
+			if elapsed := time.Since(start); elapsed < tt.min || elapsed > tt.max {
+				t.Errorf("closed after %v, want between %v and %v", elapsed, tt.min, tt.max)
+			}
+			if !eventually(func() bool { return closedBy(p, tt.reason) == 1 }) {
+				t.Errorf("not closed as %s: %v", tt.reason, p.TunnelStats())
+			}
+		})
+	}
+}
+
+func TestTunnelSetReserve(t *testing.T) {
+	ts := newTunnelSet()
+	tests := []struct {
+		op    string // reserve, remove or drain
+		route string
+		ok    bool
+	}{
+		{"reserve", "/a", true},
+		{"reserve", "/a", true},
+		{"reserve", "/a", false},
+		{"reserve", "/b", true},
+		{"remove", "/a", true},
+		{"reserve", "/a", true},
+		{"drain", "", true},
+		{"reserve", "/c", false},
+	}
+	held := map[string][]*tunnel{}
+	for i, tt := range tests {
+		switch tt.op {
+		case "reserve":
+			tun, err := ts.reserve(tt.route, 2)
+			if (err == nil) != tt.ok || (err != nil && !errors.Is(err, errTunnelRefused)) {
+				t.Fatalf("step %d: reserve(%s) error = %v, want ok %v", i, tt.route, err, tt.ok)
+			}
+			if err == nil {
+				held[tt.route] = append(held[tt.route], tun)
+			}
+		case "remove":
+			ts.remove(held[tt.route][0])
+			held[tt.route] = held[tt.route][1:]
+		case "drain":
+			// Reserved tunnels that never ran are closed once ctx is done
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			ts.Drain(ctx)
+			cancel()
+		}
+	}
+	stats := ts.Stats()
+	if stats["rejected"] != int64(2) || stats["open"] != 3 || stats["opened"] != int64(0) {
+		t.Errorf("stats = %v, want 2 rejected, 3 open, none opened", stats)
+	}
+}
+
+func TestDrainTunnels(t *testing.T) {
+	backend := upgradeBackend()
+	defer backend.Close()
+	p, gateway := newUpgradeGateway(t, backend.URL, `{"idle_timeout_seconds": 10, "max_connections": 5}`)
+
+	// Nothing open, nothing to wait for
+	idle, _ := newUpgradeGateway(t, backend.URL, `{"idle_timeout_seconds": 10, "max_connections": 5}`)
+	start := time.Now()
+	idle.DrainTunnels(context.Background())
+	if time.Since(start) > 50*time.Millisecond {
+		t.Error("draining without tunnels waited")
+	}
+
+	_, br, _ := dialUpgrade(t, gateway, "/ws/echo")
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	p.DrainTunnels(ctx)
+	if elapsed := time.Since(start); elapsed < 300*time.Millisecond {
+		t.Errorf("drain returned after %v, before its deadline", elapsed)
+	}
+
+	io.Copy(io.Discard, br)
+	if !eventually(func() bool { return closedBy(p, closedShutdown) == 1 }) {
+		t.Errorf("open tunnel not closed on shutdown: %v", p.TunnelStats())
+	}
+	if _, _, resp := dialUpgrade(t, gateway, "/ws/echo"); resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("upgrade while draining: status %d, want %d", resp.StatusCode, http.StatusServiceUnavailable)
+	}
+}
+
+func TestIsUpgrade(t *testing.T) {
+	tests := []struct {
+		connection []string
+		upgrade    string
+		want       bool
+	}{
+		{[]string{"Upgrade"}, "websocket", true},
+		{[]string{"keep-alive, upgrade"}, "websocket", true},
+		{[]string{"keep-alive", "Upgrade"}, "websocket", true},
+		{[]string{"keep-alive"}, "websocket", false},
+		{[]string{"Upgrade"}, "", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header["Connection"] = tt.connection
+		r.Header.Set("Upgrade", tt.upgrade)
+		if got := isUpgrade(r); got != tt.want {
+			t.Errorf("isUpgrade(Connection %q, Upgrade %q) = %v, want %v", tt.connection, tt.upgrade, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package ratelimit
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"gateway/apikey"
+	"gateway/jwt"
+	"gateway/router"
+)
+
+// Key part types. A route's rate limit key is built from one or more
+// parts, each naming something about the request.
+const (
+	PartIP        = "ip"        // client address without the port
+	PartForwarded = "forwarded" // client address behind trusted proxies, from X-Forwarded-For
+	PartHeader    = "header"    // request header value
+	PartClaim     = "claim"     // JWT claim
+	PartAPIKey    = "api_key"   // ID of the request's API key
+	PartParam     = "param"     // route path parameter
+	PartGlobal    = "global"    // the same for every request
+)
+
+// ErrMissingPart is returned for requests lacking a part of a rate limit
+// key
+var ErrMissingPart = errors.New("request lacks rate limit key part")
+
+// KeyPart is one component of a rate limit key
+type KeyPart struct {
+	typ  string
+	name string
+}
+
+// NewKeyPart creates a key part. Name is the header, claim path or path
+// parameter for those types and must be empty for the others.
+func NewKeyPart(typ, name string) (KeyPart, error) {
+	switch typ {
+	case PartHeader, PartClaim, PartParam:
+		if name == "" {
+			return KeyPart{}, fmt.Errorf("%s key part needs a name", typ)
+		}
+	case PartIP, PartForwarded, PartAPIKey, PartGlobal:
+		if name != "" {
+			return KeyPart{}, fmt.Errorf("%s key part takes no name", typ)
+		}
+	default:
+		return KeyPart{}, fmt.Errorf("unknown key part type %q", typ)
+	}
+	if typ == PartHeader {
+		name = http.CanonicalHeaderKey(name)
+	}
+	return KeyPart{typ: typ, name: name}, nil
+}
+
+// value returns the part's value for r, or false when r has none
+func (p KeyPart) value(r *http.Request, trusted []*net.IPNet) (string, bool) {
+	switch p.typ {
+	case PartIP:
+		return ClientIP(r), true
+	case PartForwarded:
+		return ForwardedIP(r, trusted), true
+	case PartHeader:
+		v := r.Header.Get(p.name)
+		return v, v != ""
+	case PartClaim:
+		v := jwt.FromContext(r.Context()).String(p.name)
+		return v, v != ""
+	case PartAPIKey:
+		if k := apikey.FromContext(r.Context()); k != nil {
+			return k.ID, true
+		}
+	case PartParam:
+		if m := router.FromContext(r.Context()); m != nil {
+			v := m.Params.Get(p.name)
+			return v, v != ""
+		}
+	case PartGlobal:
+		return "", true
+	}
+	return "", false
+}
+
+func (p KeyPart) String() string {
+	if p.name == "" {
+		return p.typ
+	}
+	return p.typ + ":" + p.name
+}
+
+// KeyFunc builds a route's rate limit keys. Keys are scoped to the route,
+// so its requests get buckets of their own.
+type KeyFunc struct {
+	route string
+	parts []KeyPart
+}
+
+// NewKeyFunc creates the key function of route from parts, which are
+// combined when there are several
+func NewKeyFunc(route string, parts []KeyPart) *KeyFunc {
+	return &KeyFunc{route: route, parts: parts}
+}
+
+// Key returns r's rate limit key, or an error wrapping ErrMissingPart
+// that names the first part r lacks. trusted lists the proxies whose
+// X-Forwarded-For entries are believed.
+func (f *KeyFunc) Key(r *http.Request, trusted []*net.IPNet) (string, error) {
+	var b strings.Builder
+	b.WriteString("route:")
+	b.WriteString(f.route)
+	for _, p := range f.parts {
+		v, ok := p.value(r, trusted)
+		if !ok {
+			return "", fmt.Errorf("%w: %s", ErrMissingPart, p)
+		}
+		// Values are quoted so a separator inside one can't make two
+		// different requests share a key
+		b.WriteByte('|')
+		b.WriteString(p.String())
+		b.WriteByte('=')
+		b.WriteString(strconv.Quote(v))
+	}
+	return b.String(), nil
+}
+
+// ClientIP returns the address of r's peer without the port
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// ForwardedIP returns the client address of r as reported by trusted
+// proxies. X-Forwarded-For is read from the right, the entry appended by
+// the nearest proxy, and each entry is believed only while the hop that
+// reported it is trusted, so clients can't pick their own address by
+// sending the header themselves.
+func ForwardedIP(r *http.Request, trusted []*net.IPNet) string {
+	ip := ClientIP(r)
+	var hops []string
+	for _, v := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(v, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0 && isTrusted(ip, trusted); i-- {
+		hop := strings.TrimSpace(hops[i])
+		if net.ParseIP(hop) == nil {
+			break
+		}
+		ip = hop
+	}
+	return ip
+}
+
+func isTrusted(addr string, trusted []*net.IPNet) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, n := range trusted {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
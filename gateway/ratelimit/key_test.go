@@ -0,0 +1,99 @@
+package ratelimit
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"gateway/apikey"
+	"gateway/jwt"
+	"gateway/router"
+)
+
+func TestKey(t *testing.T) {
+	_, trusted, _ := net.ParseCIDR("10.0.0.0/8")
+	part := func(typ, name string) KeyPart {
+		p, err := NewKeyPart(typ, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	request := func(remote string, h http.Header) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/t/acme", nil)
+		r.RemoteAddr = remote
+		for k, v := range h {
+			r.Header[k] = v
+		}
+		ctx := router.WithMatch(r.Context(), &router.Match{Params: router.Params{{Key: "tenant", Value: "acme"}}})
+		ctx = jwt.WithClaims(ctx, jwt.Claims{"sub": "alice", "org": map[string]interface{}{"id": "o1"}})
+		ctx = apikey.WithKey(ctx, &apikey.Key{ID: "k1"})
+		return r.WithContext(ctx)
+	}
+
+	tests := []struct {
+		name    string
+		parts   []KeyPart
+		r       *http.Request
+		want    string
+		missing bool
+	}{
+		{"ip strips the port", []KeyPart{part(PartIP, "")}, request("192.0.2.1:5000", nil), `route:/t|ip="192.0.2.1"`, false},
+		{"forwarded from a trusted proxy", []KeyPart{part(PartForwarded, "")},
+			request("10.0.0.1:1", http.Header{"X-Forwarded-For": {"198.51.100.7"}}), `route:/t|forwarded="198.51.100.7"`, false},
+		{"forwarded from an untrusted peer", []KeyPart{part(PartForwarded, "")},
+			request("192.0.2.1:1", http.Header{"X-Forwarded-For": {"198.51.100.7"}}), `route:/t|forwarded="192.0.2.1"`, false},
+		{"forwarded stops at a client-supplied hop", []KeyPart{part(PartForwarded, "")},
+			request("10.0.0.1:1", http.Header{"X-Forwarded-For": {"1.1.1.1, 198.51.100.7, 10.0.0.2"}}), `route:/t|forwarded="198.51.100.7"`, false},
+		{"header", []KeyPart{part(PartHeader, "x-tenant")}, request("192.0.2.1:1", http.Header{"X-Tenant": {"a"}}), `route:/t|header:X-Tenant="a"`, false},
+		{"missing header", []KeyPart{part(PartHeader, "x-tenant")}, request("192.0.2.1:1", nil), "", true},
+		{"nested claim", []KeyPart{part(PartClaim, "org.id")}, request("192.0.2.1:1", nil), `route:/t|claim:org.id="o1"`, false},
+		{"missing claim", []KeyPart{part(PartClaim, "tenant")}, request("192.0.2.1:1", nil), "", true},
+		{"api key", []KeyPart{part(PartAPIKey, "")}, request("192.0.2.1:1", nil), `route:/t|api_key="k1"`, false},
+		{"path param", []KeyPart{part(PartParam, "tenant")}, request("192.0.2.1:1", nil), `route:/t|param:tenant="acme"`, false},
+		{"global", []KeyPart{part(PartGlobal, "")}, request("192.0.2.1:1", nil), `route:/t|global=""`, false},
+		{"composite", []KeyPart{part(PartParam, "tenant"), part(PartIP, "")}, request("192.0.2.1:1", nil),
+			`route:/t|param:tenant="acme"|ip="192.0.2.1"`, false},
+		{"separators are quoted", []KeyPart{part(PartHeader, "a"), part(PartHeader, "b")},
+			request("192.0.2.1:1", http.Header{"A": {`x"|header:B="y`}, "B": {"z"}}), `route:/t|header:A="x\"|header:B=\"y"|header:B="z"`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewKeyFunc("/t", tt.parts).Key(tt.r, []*net.IPNet{trusted})
+			if tt.missing {
+				if !errors.Is(err, ErrMissingPart) {
+					t.Fatalf("Key() error = %v, want ErrMissingPart", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Key() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Key() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewKeyPart(t *testing.T) {
+	tests := []struct {
+		typ, name string
+		ok        bool
+	}{
+		{PartHeader, "X-A", true},
+		{PartHeader, "", false},
+		{PartClaim, "", false},
+		{PartParam, "", false},
+		{PartIP, "x", false},
+		{PartGlobal, "x", false},
+		{"cookie", "a", false},
+	}
+	for _, tt := range tests {
+		if _, err := NewKeyPart(tt.typ, tt.name); (err == nil) != tt.ok {
+			t.Errorf("NewKeyPart(%q, %q) error = %v, want ok %v", tt.typ, tt.name, err, tt.ok)
+		}
+	}
+}
@@ -42,13 +42,16 @@ func (l *Limiter) Allow(clientKey string, customRate int) (bool, int64, int64) {
 	shardIdx := l.getShardIdx(clientKey)
 	shard := l.shards[shardIdx]
 
-	if customRate > 0 {
-		shard.refillRate = int64(customRate)
-	}
-
 	shard.mu.Lock()
 	defer shard.mu.Unlock()
 
+	// A custom rate applies to this bucket only; the shard's rate is the
+	// default for the others
+	rate := shard.refillRate
+	if customRate > 0 {
+		rate = int64(customRate)
+	}
+
 	state, exists := shard.tokens[clientKey]
 	now := time.Now().Unix()
 
@@ -74,7 +77,7 @@ func (l *Limiter) Allow(clientKey string, customRate int) (bool, int64, int64) {
 	// Refill tokens based on time elapsed
 	timeElapsed := now - state.lastRefill
 	if timeElapsed > 0 {
-		tokensToAdd := float64(shard.refillRate) * float64(timeElapsed) / 60.0
+		tokensToAdd := float64(rate) * float64(timeElapsed) / 60.0
 		state.tokens = min(state.tokens+tokensToAdd, float64(shard.burstSize))
 		state.lastRefill = now
 	}
@@ -93,18 +96,29 @@ func (l *Limiter) Allow(clientKey string, customRate int) (bool, int64, int64) {
 	// Calculate reset time based on token deficit
 	remaining := int64(state.tokens)
 	deficit := 1.0 - state.tokens
-	secondsUntilReset := int64((deficit / float64(shard.refillRate)) * 60)
+	secondsUntilReset := int64((deficit / float64(rate)) * 60)
 	resetTime := now + secondsUntilReset
 
 	return false, remaining, resetTime
 }
 
+// Reconfigure updates the default rate and burst size of every shard in
+// place, keeping existing client buckets
+func (l *Limiter) Reconfigure(defaultRate, burstSize int) {
+	for _, shard := range l.shards {
+		shard.mu.Lock()
+		shard.refillRate = int64(defaultRate)
+		shard.burstSize = int64(burstSize)
+		shard.mu.Unlock()
+	}
+}
+
 func (l *Limiter) getShardIdx(key string) int {
 	hash := uint64(0)
 	for _, c := range key {
 		hash = hash*31 + uint64(c)
 	}
-	return int(hash) % l.numShards
+	return int(hash % uint64(l.numShards))
 }
 
 func min(a, b float64) float64 {
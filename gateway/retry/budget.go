@@ -0,0 +1,113 @@
+package retry
+
+import (
+	"sync"
+	"time"
+)
+
+// Budget caps retries (or other extra attempts, such as hedges)
+// gateway-wide at a percentage of recent requests, so they can't multiply
+// load on upstreams that are already struggling. An optional floor of
+// retries per second lets quiet gateways still retry.
+type Budget struct {
+	mu           sync.Mutex
+	percent      float64
+	minPerSecond int
+	buckets      []budgetBucket
+	rejected     int64
+}
+
+type budgetBucket struct {
+	second   int64
+	requests int64
+	retries  int64
+}
+
+// NewBudget creates a budget allowing retries up to percent of the requests
+// seen over the last windowSeconds, and at least minPerSecond retries per
+// second
+func NewBudget(percent float64, minPerSecond, windowSeconds int) *Budget {
+	b := &Budget{}
+	b.Reconfigure(percent, minPerSecond, windowSeconds)
+	return b
+}
+
+// Reconfigure changes the budget's limits. Changing the window size
+// discards the recorded history.
+func (b *Budget) Reconfigure(percent float64, minPerSecond, windowSeconds int) {
+	if windowSeconds <= 0 {
+		windowSeconds = 1
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.percent = percent
+	b.minPerSecond = minPerSecond
+	if len(b.buckets) != windowSeconds {
+		b.buckets = make([]budgetBucket, windowSeconds)
+	}
+}
+
+// RecordRequest counts an original (non-retry) request
+func (b *Budget) RecordRequest() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.current().requests++
+}
+
+// Acquire reserves one retry, reporting false if the budget is exhausted
+func (b *Budget) Acquire() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	requests, retries := b.totals()
+	allowed := int64(b.percent * float64(requests) / 100)
+	if floor := int64(b.minPerSecond * len(b.buckets)); allowed < floor {
+		allowed = floor
+	}
+	if retries >= allowed {
+		b.rejected++
+		return false
+	}
+	b.current().retries++
+	return true
+}
+
+// current returns the bucket for this second. Callers must hold b.mu.
+func (b *Budget) current() *budgetBucket {
+	now := time.Now().Unix()
+	bk := &b.buckets[now%int64(len(b.buckets))]
+	if bk.second != now {
+		*bk = budgetBucket{second: now}
+	}
+	return bk
+}
+
+// totals sums the buckets inside the window. Callers must hold b.mu.
+func (b *Budget) totals() (requests, retries int64) {
+	cutoff := time.Now().Unix() - int64(len(b.buckets))
+	for _, bk := range b.buckets {
+		if bk.second > cutoff {
+			requests += bk.requests
+			retries += bk.retries
+		}
+	}
+	return requests, retries
+}
+
+// Stats returns budget statistics
+func (b *Budget) Stats() map[string]interface{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	requests, retries := b.totals()
+	return map[string]interface{}{
+		"percent":        b.percent,
+		"window_seconds": len(b.buckets),
+		"requests":       requests,
+		"retries":        retries,
+		"rejected":       b.rejected,
+	}
+}
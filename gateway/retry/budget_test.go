@@ -0,0 +1,76 @@
+package retry
+
+import "testing"
+
+// acquireAll returns how many retries b grants before refusing one
+func acquireAll(b *Budget) int {
+	n := 0
+	for b.Acquire() {
+		n++
+		if n > 10000 {
+			break
+		}
+	}
+	return n
+}
+
+func TestBudgetExhaustion(t *testing.T) {
+	// Windows are long enough that no bucket expires mid-test
+	tests := []struct {
+		name     string
+		percent  float64
+		min      int
+		window   int
+		requests int
+		want     int
+	}{
+		{"percent of requests", 10, 0, 60, 100, 10},
+		{"rounds down", 10, 0, 60, 19, 1},
+		{"too few requests", 10, 0, 60, 5, 0},
+		{"floor without traffic", 0, 1, 10, 0, 10},
+		{"floor below percent", 50, 1, 10, 100, 50},
+		{"floor above percent", 20, 1, 10, 10, 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewBudget(tt.percent, tt.min, tt.window)
+			for i := 0; i < tt.requests; i++ {
+				b.RecordRequest()
+			}
+			if got := acquireAll(b); got != tt.want {
+				t.Fatalf("granted %d retries, want %d", got, tt.want)
+			}
+			if rejected := b.Stats()["rejected"].(int64); rejected != 1 {
+				t.Errorf("rejected = %d, want 1", rejected)
+			}
+
+			// More traffic frees up more budget
+			for i := 0; i < 100; i++ {
+				b.RecordRequest()
+			}
+			if tt.percent > 0 && !b.Acquire() {
+				t.Error("budget did not grow with new requests")
+			}
+		})
+	}
+}
+
+func TestBudgetReconfigure(t *testing.T) {
+	b := NewBudget(10, 0, 60)
+	for i := 0; i < 100; i++ {
+		b.RecordRequest()
+	}
+	acquireAll(b)
+
+	// Same window keeps the history, so the higher percent applies to it
+	b.Reconfigure(20, 0, 60)
+	if got := acquireAll(b); got != 10 {
+		t.Errorf("granted %d retries after raising the percent, want 10", got)
+	}
+
+	// A new window starts empty
+	b.Reconfigure(20, 0, 30)
+	if got := acquireAll(b); got != 0 {
+		t.Errorf("granted %d retries after resizing the window, want 0", got)
+	}
+}
@@ -0,0 +1,87 @@
+package retry
+
+import (
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+// Retry conditions accepted by Policy.On
+const (
+	OnConnectError = "connect_error" // the request never reached the upstream
+	OnReset        = "reset"         // the connection dropped before a response
+)
+
+// ErrNotSent marks errors where the upstream was skipped without sending
+// the request, such as an open circuit. They are retried as connect errors.
+var ErrNotSent = errors.New("request not sent")
+
+// Policy decides whether and when a failed upstream attempt is retried
+type Policy struct {
+	MaxAttempts   int // including the first attempt
+	On            map[string]bool
+	StatusCodes   map[int]bool
+	BaseBackoff   time.Duration
+	MaxBackoff    time.Duration
+	NonIdempotent bool  // also retry POST, PATCH and other unsafe methods
+	MaxBodyBytes  int64 // request bodies above this size are not retried
+}
+
+// idempotent methods per RFC 9110 section 9.2.2
+var idempotent = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodOptions: true,
+	http.MethodTrace:   true,
+	http.MethodPut:     true,
+	http.MethodDelete:  true,
+}
+
+// Allows reports whether requests with method may be retried at all
+func (p *Policy) Allows(method string) bool {
+	return p != nil && p.MaxAttempts > 1 && (p.NonIdempotent || idempotent[method])
+}
+
+// ShouldRetry reports whether an attempt that ended with status or err
+// matches one of the policy's conditions
+func (p *Policy) ShouldRetry(status int, err error) bool {
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrNotSent) || isConnectError(err):
+			return p.On[OnConnectError]
+		case isReset(err):
+			return p.On[OnReset]
+		}
+		return false
+	}
+	return p.StatusCodes[status]
+}
+
+// Backoff returns the delay before retry number n (starting at 1): a random
+// duration up to BaseBackoff*2^(n-1), capped at MaxBackoff ("full jitter")
+func (p *Policy) Backoff(n int) time.Duration {
+	limit := p.BaseBackoff << (n - 1)
+	if limit > p.MaxBackoff || limit <= 0 {
+		limit = p.MaxBackoff
+	}
+	if limit <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(limit) + 1))
+}
+
+func isConnectError(err error) bool {
+	var op *net.OpError
+	return errors.As(err, &op) && op.Op == "dial"
+}
+
+func isReset(err error) bool {
+	return errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF)
+}
@@ -0,0 +1,142 @@
+package rewrite
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Rules describes how a public request path maps onto the upstream path
+type Rules struct {
+	StripPrefix string // leading path segments removed if present
+	Regex       string // applied after StripPrefix
+	Replacement string // regexp replacement, may use $1 or ${name}
+	Template    string // replaces the whole path, e.g. "/v2/users/{id}"
+	AddPrefix   string // prepended last
+}
+
+// Rewriter applies compiled Rules to request paths
+type Rewriter struct {
+	stripPrefix string
+	re          *regexp.Regexp
+	replacement string
+	template    []templatePart
+	addPrefix   string
+}
+
+type templatePart struct {
+	literal string
+	param   string
+}
+
+// New compiles rules. Template replaces the path wholesale, so it cannot be
+// combined with StripPrefix or Regex.
+func New(rules Rules) (*Rewriter, error) {
+	rw := &Rewriter{
+		stripPrefix: rules.StripPrefix,
+		replacement: rules.Replacement,
+		addPrefix:   strings.TrimSuffix(rules.AddPrefix, "/"),
+	}
+
+	if rules.Template != "" && (rules.StripPrefix != "" || rules.Regex != "") {
+		return nil, fmt.Errorf("template cannot be combined with strip_prefix or regex")
+	}
+	if rules.Replacement != "" && rules.Regex == "" {
+		return nil, fmt.Errorf("replacement requires regex")
+	}
+	if rules.AddPrefix != "" && !strings.HasPrefix(rules.AddPrefix, "/") {
+		return nil, fmt.Errorf("add_prefix must start with '/'")
+	}
+
+	if rules.Regex != "" {
+		re, err := regexp.Compile(rules.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex: %w", err)
+		}
+		rw.re = re
+	}
+
+	if rules.Template != "" {
+		parts, err := parseTemplate(rules.Template)
+		if err != nil {
+			return nil, err
+		}
+		rw.template = parts
+	}
+
+	return rw, nil
+}
+
+// Params returns the parameter names referenced by the template
+func (rw *Rewriter) Params() []string {
+	var names []string
+	for _, part := range rw.template {
+		if part.param != "" {
+			names = append(names, part.param)
+		}
+	}
+	return names
+}
+
+// Rewrite maps path to the upstream path. param looks up matched route
+// parameters by name; the wildcard remainder is "*".
+func (rw *Rewriter) Rewrite(path string, param func(name string) string) string {
+	if rw.template != nil {
+		var b strings.Builder
+		for _, part := range rw.template {
+			if part.param != "" {
+				b.WriteString(param(part.param))
+			} else {
+				b.WriteString(part.literal)
+			}
+		}
+		path = b.String()
+	}
+
+	if rw.stripPrefix != "" {
+		// Only strip whole segments: "/api" strips "/api/x" but not "/apiary"
+		rest, ok := strings.CutPrefix(path, rw.stripPrefix)
+		if ok && (rest == "" || rest[0] == '/' || strings.HasSuffix(rw.stripPrefix, "/")) {
+			path = rest
+		}
+	}
+
+	if rw.re != nil {
+		path = rw.re.ReplaceAllString(path, rw.replacement)
+	}
+
+	path = rw.addPrefix + path
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return path
+}
+
+func parseTemplate(tmpl string) ([]templatePart, error) {
+	if !strings.HasPrefix(tmpl, "/") {
+		return nil, fmt.Errorf("template must start with '/'")
+	}
+
+	var parts []templatePart
+	for tmpl != "" {
+		open := strings.IndexByte(tmpl, '{')
+		if open < 0 {
+			parts = append(parts, templatePart{literal: tmpl})
+			break
+		}
+		if open > 0 {
+			parts = append(parts, templatePart{literal: tmpl[:open]})
+		}
+		end := strings.IndexByte(tmpl[open:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated parameter in template")
+		}
+		name := tmpl[open+1 : open+end]
+		if name == "" {
+			return nil, fmt.Errorf("empty parameter name in template")
+		}
+		parts = append(parts, templatePart{param: name})
+		tmpl = tmpl[open+end+1:]
+	}
+	return parts, nil
+}
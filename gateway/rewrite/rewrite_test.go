@@ -0,0 +1,81 @@
+package rewrite
+
+import "testing"
+
+func TestRewrite(t *testing.T) {
+	params := map[string]string{"id": "42", "*": "a/b"}
+	param := func(name string) string { return params[name] }
+
+	tests := []struct {
+		name  string
+		rules Rules
+		path  string
+		want  string
+	}{
+		{"no rules", Rules{}, "/x", "/x"},
+		{"strip prefix", Rules{StripPrefix: "/api"}, "/api/users", "/users"},
+		{"strip the whole path", Rules{StripPrefix: "/api"}, "/api", "/"},
+		{"strip only whole segments", Rules{StripPrefix: "/api"}, "/apiv2/users", "/apiv2/users"},
+		{"strip prefix with trailing slash", Rules{StripPrefix: "/api/"}, "/api/users", "/users"},
+		{"strip prefix not present", Rules{StripPrefix: "/api"}, "/other", "/other"},
+		{"add prefix", Rules{AddPrefix: "/v1"}, "/users", "/v1/users"},
+		{"add prefix trailing slash", Rules{AddPrefix: "/v1/"}, "/users", "/v1/users"},
+		{"strip then add", Rules{StripPrefix: "/api", AddPrefix: "/internal"}, "/api", "/internal"},
+		{"regex", Rules{Regex: `^/users/(\d+)$`, Replacement: "/u/$1"}, "/users/7", "/u/7"},
+		{"regex named group", Rules{Regex: `^/(?P<kind>\w+)/`, Replacement: "/${kind}s/"}, "/cat/1", "/cats/1"},
+		{"regex after strip", Rules{StripPrefix: "/api", Regex: `^/old`, Replacement: "/new"}, "/api/old/x", "/new/x"},
+		{"regex removing everything", Rules{Regex: `.*`}, "/x", "/"},
+		{"regex no match", Rules{Regex: `^/nope`, Replacement: "/yes"}, "/x", "/x"},
+		{"template", Rules{Template: "/v2/users/{id}"}, "/users/42", "/v2/users/42"},
+		{"template wildcard", Rules{Template: "/files/{*}"}, "/f/a/b", "/files/a/b"},
+		{"template missing param", Rules{Template: "/v2/{missing}/x"}, "/y", "/v2//x"},
+		{"template with add prefix", Rules{Template: "/u/{id}", AddPrefix: "/svc"}, "/users/42", "/svc/u/42"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rw, err := New(tt.rules)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := rw.Rewrite(tt.path, param); got != tt.want {
+				t.Errorf("Rewrite(%q) = %q, want %q", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules Rules
+		ok    bool
+	}{
+		{"template and strip prefix", Rules{Template: "/x", StripPrefix: "/a"}, false},
+		{"template and regex", Rules{Template: "/x", Regex: "a"}, false},
+		{"replacement without regex", Rules{Replacement: "/x"}, false},
+		{"relative add prefix", Rules{AddPrefix: "v1"}, false},
+		{"invalid regex", Rules{Regex: "("}, false},
+		{"relative template", Rules{Template: "x/{id}"}, false},
+		{"unterminated template param", Rules{Template: "/x/{id"}, false},
+		{"empty template param", Rules{Template: "/x/{}"}, false},
+		{"template and add prefix", Rules{Template: "/x/{id}", AddPrefix: "/v1"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New(tt.rules); (err == nil) != tt.ok {
+				t.Errorf("New() error = %v, want ok %v", err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestParams(t *testing.T) {
+	rw, err := New(Rules{Template: "/{a}/x/{b}{c}"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := rw.Params()
+	if len(got) != 3 || got[0] != "a" || got[1] != "b" || got[2] != "c" {
+		t.Errorf("Params() = %v, want [a b c]", got)
+	}
+}
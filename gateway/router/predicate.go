@@ -0,0 +1,237 @@
+package router
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Predicate is an extra condition a request must satisfy for a route to
+// match, beyond its path and method
+type Predicate interface {
+	Match(r *http.Request) bool
+	// String describes the predicate; it is used in match reasons and to
+	// detect routes that can never be told apart
+	String() string
+}
+
+// Predicate types accepted by NewPredicate
+const (
+	PredicateHost     = "host"
+	PredicateHeader   = "header"
+	PredicateQuery    = "query"
+	PredicateClientIP = "client_ip"
+	PredicateGRPC     = "grpc"
+)
+
+// NewPredicate builds a predicate of the given type. A request matches if
+// any of values matches:
+//
+//   - host: Host header without port; "*.example.com" matches any
+//     subdomain of example.com
+//   - header: value of header name; no values means the header is present
+//   - query: value of query parameter name; no values means it is present
+//   - client_ip: client address falls inside one of the CIDR ranges
+//   - grpc: gRPC request for one of the services ("pkg.Service") or
+//     methods ("pkg.Service/Method"); no values means any gRPC request
+func NewPredicate(typ, name string, values []string) (Predicate, error) {
+	switch typ {
+	case PredicateHost:
+		if len(values) == 0 {
+			return nil, fmt.Errorf("host predicate needs at least one value")
+		}
+		hosts := make([]string, len(values))
+		for i, v := range values {
+			v = strings.ToLower(v)
+			if strings.Contains(v, "*") && (!strings.HasPrefix(v, "*.") || strings.Count(v, "*") > 1) {
+				return nil, fmt.Errorf("invalid host pattern %q, wildcards must look like *.example.com", v)
+			}
+			hosts[i] = v
+		}
+		return hostPredicate(hosts), nil
+	case PredicateHeader, PredicateQuery:
+		if name == "" {
+			return nil, fmt.Errorf("%s predicate needs a name", typ)
+		}
+		if typ == PredicateHeader {
+			return &headerPredicate{name: http.CanonicalHeaderKey(name), values: values}, nil
+		}
+		return &queryPredicate{name: name, values: values}, nil
+	case PredicateClientIP:
+		if len(values) == 0 {
+			return nil, fmt.Errorf("client_ip predicate needs at least one CIDR")
+		}
+		nets := make([]*net.IPNet, len(values))
+		for i, v := range values {
+			_, n, err := net.ParseCIDR(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid CIDR %q", v)
+			}
+			nets[i] = n
+		}
+		return cidrPredicate(nets), nil
+	case PredicateGRPC:
+		for _, v := range values {
+			service, method, _ := strings.Cut(v, "/")
+			if service == "" || strings.Contains(method, "/") || strings.HasSuffix(v, "/") {
+				return nil, fmt.Errorf("invalid gRPC name %q, must look like pkg.Service or pkg.Service/Method", v)
+			}
+		}
+		return grpcPredicate(values), nil
+	default:
+		return nil, fmt.Errorf("unknown predicate type %q", typ)
+	}
+}
+
+type hostPredicate []string
+
+func (p hostPredicate) Match(r *http.Request) bool {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(host)
+
+	for _, want := range p {
+		if suffix, ok := strings.CutPrefix(want, "*"); ok {
+			// "*.example.com" matches "a.example.com" but not "example.com"
+			if strings.HasSuffix(host, suffix) && len(host) > len(suffix) {
+				return true
+			}
+		} else if host == want {
+			return true
+		}
+	}
+	return false
+}
+
+func (p hostPredicate) String() string {
+	return "host in " + formatValues(p)
+}
+
+type headerPredicate struct {
+	name   string
+	values []string
+}
+
+func (p *headerPredicate) Match(r *http.Request) bool {
+	got, present := r.Header[p.name]
+	return matchValues(got, present, p.values)
+}
+
+func (p *headerPredicate) String() string {
+	if len(p.values) == 0 {
+		return "header " + p.name + " present"
+	}
+	return "header " + p.name + " in " + formatValues(p.values)
+}
+
+type queryPredicate struct {
+	name   string
+	values []string
+}
+
+func (p *queryPredicate) Match(r *http.Request) bool {
+	got, present := r.URL.Query()[p.name]
+	return matchValues(got, present, p.values)
+}
+
+func (p *queryPredicate) String() string {
+	if len(p.values) == 0 {
+		return "query " + p.name + " present"
+	}
+	return "query " + p.name + " in " + formatValues(p.values)
+}
+
+type cidrPredicate []*net.IPNet
+
+func (p cidrPredicate) Match(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, n := range p {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p cidrPredicate) String() string {
+	cidrs := make([]string, len(p))
+	for i, n := range p {
+		cidrs[i] = n.String()
+	}
+	return "client_ip in " + formatValues(cidrs)
+}
+
+type grpcPredicate []string
+
+func (p grpcPredicate) Match(r *http.Request) bool {
+	if !IsGRPC(r.Header) {
+		return false
+	}
+	if len(p) == 0 {
+		return true
+	}
+
+	// gRPC paths are /pkg.Service/Method
+	name := strings.TrimPrefix(r.URL.Path, "/")
+	service, _, _ := strings.Cut(name, "/")
+	for _, want := range p {
+		if want == name || want == service {
+			return true
+		}
+	}
+	return false
+}
+
+func (p grpcPredicate) String() string {
+	if len(p) == 0 {
+		return "grpc"
+	}
+	return "grpc in " + formatValues(p)
+}
+
+// IsGRPC reports whether the content type in h is gRPC's
+func IsGRPC(h http.Header) bool {
+	ct := h.Get("Content-Type")
+	return ct == "application/grpc" || strings.HasPrefix(ct, "application/grpc+") || strings.HasPrefix(ct, "application/grpc;")
+}
+
+func matchValues(got []string, present bool, want []string) bool {
+	if len(want) == 0 {
+		return present
+	}
+	for _, g := range got {
+		for _, w := range want {
+			if g == w {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func formatValues(values []string) string {
+	sorted := append([]string(nil), values...)
+	sort.Strings(sorted)
+	return "[" + strings.Join(sorted, ",") + "]"
+}
+
+// signature identifies a predicate set independent of declaration order
+func signature(preds []Predicate) string {
+	parts := make([]string, len(preds))
+	for i, p := range preds {
+		parts[i] = p.String()
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, " && ")
+}
@@ -0,0 +1,92 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPredicateMatch(t *testing.T) {
+	host := mustPredicate(t, PredicateHost, "", "API.example.com", "*.tenant.io")
+	header := mustPredicate(t, PredicateHeader, "x-version", "2", "3")
+	present := mustPredicate(t, PredicateHeader, "X-Beta")
+	query := mustPredicate(t, PredicateQuery, "v", "2")
+	cidr := mustPredicate(t, PredicateClientIP, "", "10.0.0.0/8", "2001:db8::/32")
+	grpc := mustPredicate(t, PredicateGRPC, "", "pkg.Users", "pkg.Orders/Get")
+
+	tests := []struct {
+		name   string
+		pred   Predicate
+		target string
+		header http.Header
+		remote string
+		want   bool
+	}{
+		{"host", host, "http://api.example.com/", nil, "", true},
+		{"host with port", host, "http://api.example.com:8080/", nil, "", true},
+		{"host case", host, "http://Api.Example.COM/", nil, "", true},
+		{"other host", host, "http://www.example.com/", nil, "", false},
+		{"wildcard host", host, "http://a.tenant.io:443/", nil, "", true},
+		{"wildcard needs a subdomain", host, "http://tenant.io/", nil, "", false},
+		{"wildcard suffix only", host, "http://atenant.io/", nil, "", false},
+
+		{"header value", header, "/", http.Header{"X-Version": {"3"}}, "", true},
+		{"multi-valued header", header, "/", http.Header{"X-Version": {"1", "2"}}, "", true},
+		{"no header value matches", header, "/", http.Header{"X-Version": {"1", "4"}}, "", false},
+		{"header missing", header, "/", nil, "", false},
+		{"header present", present, "/", http.Header{"X-Beta": {""}}, "", true},
+		{"header absent", present, "/", nil, "", false},
+
+		{"query value", query, "/?v=1&v=2", nil, "", true},
+		{"other query value", query, "/?v=1", nil, "", false},
+
+		{"client in range", cidr, "/", nil, "10.1.2.3:5000", true},
+		{"client outside range", cidr, "/", nil, "11.1.2.3:5000", false},
+		{"ipv6 client", cidr, "/", nil, "[2001:db8::1]:5000", true},
+		{"client without port", cidr, "/", nil, "10.0.0.1", true},
+		{"unparsable client", cidr, "/", nil, "unix", false},
+
+		{"grpc service", grpc, "/pkg.Users/List", http.Header{"Content-Type": {"application/grpc"}}, "", true},
+		{"grpc method", grpc, "/pkg.Orders/Get", http.Header{"Content-Type": {"application/grpc+proto"}}, "", true},
+		{"other grpc method", grpc, "/pkg.Orders/Delete", http.Header{"Content-Type": {"application/grpc"}}, "", false},
+		{"not grpc", grpc, "/pkg.Users/List", http.Header{"Content-Type": {"application/json"}}, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			for k, v := range tt.header {
+				r.Header[k] = v
+			}
+			if tt.remote != "" {
+				r.RemoteAddr = tt.remote
+			}
+			if got := tt.pred.Match(r); got != tt.want {
+				t.Errorf("%s: Match() = %v, want %v", tt.pred, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewPredicateErrors(t *testing.T) {
+	tests := []struct {
+		typ    string
+		name   string
+		values []string
+	}{
+		{PredicateHost, "", nil},
+		{PredicateHost, "", []string{"a.*.example.com"}},
+		{PredicateHost, "", []string{"*.*.example.com"}},
+		{PredicateHeader, "", []string{"1"}},
+		{PredicateQuery, "", nil},
+		{PredicateClientIP, "", nil},
+		{PredicateClientIP, "", []string{"10.0.0.1"}},
+		{PredicateGRPC, "", []string{"pkg.Users/"}},
+		{PredicateGRPC, "", []string{"pkg.Users/Get/x"}},
+		{"cookie", "session", nil},
+	}
+	for _, tt := range tests {
+		if _, err := NewPredicate(tt.typ, tt.name, tt.values); err == nil {
+			t.Errorf("NewPredicate(%s, %q, %q) succeeded", tt.typ, tt.name, tt.values)
+		}
+	}
+}
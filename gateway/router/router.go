@@ -0,0 +1,195 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Table is an immutable-after-build route table backed by a radix tree.
+// Patterns may be exact ("/api/users"), prefix ("/api/users/*", which also
+// matches "/api/users") or contain named parameters ("/api/users/{id}").
+//
+// Precedence is decided segment by segment: a static segment beats a
+// {param}, which beats a trailing *. Among routes with the same pattern,
+// those with more predicates are tried first, then routes are tried in the
+// order they were added; the first whose method and predicates accept the
+// request wins.
+type Table struct {
+	root    node
+	entries []*entry
+}
+
+type entry struct {
+	pattern    string
+	shape      string
+	methods    map[string]bool
+	predicates []Predicate
+	params     []string // parameter names in pattern order, "*" for a wildcard
+	index      int
+}
+
+func (e *entry) allows(r *http.Request) bool {
+	if !e.methods[r.Method] {
+		return false
+	}
+	for _, p := range e.predicates {
+		if !p.Match(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// Param is a single matched path parameter
+type Param struct {
+	Key   string
+	Value string
+}
+
+// Params are the path parameters captured by a match, in pattern order
+type Params []Param
+
+// Get returns the value of the named parameter, or "" if absent. The
+// remainder matched by a trailing wildcard is available as "*".
+func (ps Params) Get(name string) string {
+	for _, p := range ps {
+		if p.Key == name {
+			return p.Value
+		}
+	}
+	return ""
+}
+
+// Match is the result of a successful lookup
+type Match struct {
+	Index   int    // index of the route as passed to Add
+	Pattern string // pattern of the matched route
+	Params  Params
+	Reasons []string // why the route matched: path, method, then predicates
+}
+
+// New creates an empty route table
+func New() *Table {
+	return &Table{}
+}
+
+// Add registers a route pattern for the given methods and predicates. index
+// identifies the route in Match results. Registering the same pattern twice
+// with overlapping methods and identical predicates is an error, since the
+// second route could never match.
+func (t *Table) Add(pattern string, methods []string, predicates []Predicate, index int) error {
+	segs, err := parsePattern(pattern)
+	if err != nil {
+		return err
+	}
+
+	e := &entry{
+		pattern:    pattern,
+		shape:      shape(segs),
+		methods:    make(map[string]bool, len(methods)),
+		predicates: predicates,
+		index:      index,
+	}
+	for _, m := range methods {
+		e.methods[m] = true
+	}
+	e.params, _ = ParamNames(pattern)
+
+	sig := signature(predicates)
+	for _, other := range t.entries {
+		if other.shape != e.shape || signature(other.predicates) != sig {
+			continue
+		}
+		for _, m := range methods {
+			if other.methods[m] {
+				return fmt.Errorf("pattern %q conflicts with route %d (%q) for method %s", pattern, other.index, other.pattern, m)
+			}
+		}
+	}
+
+	t.root.insert(segs, e)
+	t.entries = append(t.entries, e)
+	return nil
+}
+
+// Match finds the route for r, or returns nil
+func (t *Table) Match(r *http.Request) *Match {
+	e, values := t.root.lookup(r.URL.Path, r, nil)
+	if e == nil {
+		return nil
+	}
+
+	m := &Match{
+		Index:   e.index,
+		Pattern: e.pattern,
+		Reasons: make([]string, 0, 2+len(e.predicates)),
+	}
+	m.Reasons = append(m.Reasons, "path matches "+e.pattern, "method "+r.Method+" allowed")
+	for _, p := range e.predicates {
+		m.Reasons = append(m.Reasons, p.String())
+	}
+	if len(e.params) > 0 {
+		m.Params = make(Params, len(e.params))
+		for i, name := range e.params {
+			m.Params[i] = Param{Key: name, Value: values[i]}
+		}
+	}
+	return m
+}
+
+// Validate reports whether pattern is a well-formed route pattern
+func Validate(pattern string) error {
+	_, err := parsePattern(pattern)
+	return err
+}
+
+// ParamNames returns the parameter names of pattern in order; a trailing
+// wildcard is reported as "*"
+func ParamNames(pattern string) ([]string, error) {
+	segs, err := parsePattern(pattern)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, seg := range segs {
+		switch {
+		case seg.param != "":
+			names = append(names, seg.param)
+		case seg.wildcard:
+			names = append(names, "*")
+		}
+	}
+	return names, nil
+}
+
+// shape returns the pattern with parameter names erased, so "/u/{id}" and
+// "/u/{name}" compare equal
+func shape(segs []segment) string {
+	var b strings.Builder
+	for _, seg := range segs {
+		switch {
+		case seg.param != "":
+			b.WriteString("{}")
+		case seg.wildcard:
+			b.WriteByte('*')
+		default:
+			b.WriteString(seg.static)
+		}
+	}
+	return b.String()
+}
+
+type contextKey struct{}
+
+// WithMatch returns a copy of ctx carrying m
+func WithMatch(ctx context.Context, m *Match) context.Context {
+	return context.WithValue(ctx, contextKey{}, m)
+}
+
+// FromContext returns the match stored in ctx, or nil
+func FromContext(ctx context.Context) *Match {
+	m, _ := ctx.Value(contextKey{}).(*Match)
+	return m
+}
@@ -0,0 +1,146 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func mustPredicate(t *testing.T, typ, name string, values ...string) Predicate {
+	t.Helper()
+	p, err := NewPredicate(typ, name, values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestMatchPrecedence(t *testing.T) {
+	table := New()
+	add := func(pattern string, methods []string, preds ...Predicate) {
+		if err := table.Add(pattern, methods, preds, len(table.entries)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	get := []string{http.MethodGet}
+	add("/api/users/*", get)                                                  // 0
+	add("/api/users/{id}", get)                                               // 1
+	add("/api/users/me", get)                                                 // 2
+	add("/api/users/{id}/posts/{post}", get)                                  // 3
+	add("/api/users/{id}", []string{http.MethodDelete})                       // 4
+	add("/api/v2/*", get)                                                     // 5
+	add("/api/v2/*", get, mustPredicate(t, PredicateHeader, "X-Beta"))        // 6
+	add("/api/v2/*", get, mustPredicate(t, PredicateHost, "", "*.tenant.io")) // 7
+	add("/files/*", get)                                                      // 8
+	add("/files", get)                                                        // 9
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		header http.Header
+		index  int // -1 for no match
+		params Params
+	}{
+		{"static beats param", "GET", "/api/users/me", nil, 2, nil},
+		{"param beats wildcard", "GET", "/api/users/42", nil, 1, Params{{"id", "42"}}},
+		{"wildcard takes deeper paths", "GET", "/api/users/42/friends", nil, 0, Params{{"*", "42/friends"}}},
+		{"nested params", "GET", "/api/users/42/posts/7", nil, 3, Params{{"id", "42"}, {"post", "7"}}},
+		{"method picks the route", "DELETE", "/api/users/42", nil, 4, Params{{"id", "42"}}},
+		{"method falls back to wildcard", "DELETE", "/api/users/42/x", nil, -1, nil},
+		{"bare prefix path", "GET", "/api/users", nil, 0, Params{{"*", ""}}},
+		{"exact beats bare prefix", "GET", "/files", nil, 9, nil},
+		{"prefix with slash", "GET", "/files/", nil, 8, Params{{"*", ""}}},
+		{"no partial segment", "GET", "/api/usersx", nil, -1, nil},
+		{"empty param", "GET", "/api/users//posts/7", nil, 0, Params{{"*", "/posts/7"}}},
+		{"predicate route first", "GET", "/api/v2/x", http.Header{"X-Beta": {"1"}}, 6, Params{{"*", "x"}}},
+		{"host predicate", "GET", "http://a.tenant.io/api/v2/x", nil, 7, Params{{"*", "x"}}},
+		{"predicates fall through", "GET", "/api/v2/x", nil, 5, Params{{"*", "x"}}},
+		{"unknown path", "GET", "/nope", nil, -1, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.target, nil)
+			for k, v := range tt.header {
+				r.Header[k] = v
+			}
+			m := table.Match(r)
+			if tt.index < 0 {
+				if m != nil {
+					t.Fatalf("matched route %d (%s), want none", m.Index, m.Pattern)
+				}
+				return
+			}
+			if m == nil {
+				t.Fatalf("no match, want route %d", tt.index)
+			}
+			if m.Index != tt.index {
+				t.Fatalf("matched route %d (%s), want %d", m.Index, m.Pattern, tt.index)
+			}
+			if !reflect.DeepEqual(m.Params, tt.params) {
+				t.Errorf("params = %v, want %v", m.Params, tt.params)
+			}
+		})
+	}
+}
+
+func TestAddConflicts(t *testing.T) {
+	type route struct {
+		pattern string
+		methods []string
+		preds   []Predicate
+	}
+	beta := mustPredicate(t, PredicateHeader, "X-Beta")
+	tests := []struct {
+		name     string
+		first    route
+		second   route
+		conflict bool
+	}{
+		{"same pattern and method", route{"/a", []string{"GET"}, nil}, route{"/a", []string{"GET"}, nil}, true},
+		{"params differ only in name", route{"/u/{id}", []string{"GET"}, nil}, route{"/u/{name}", []string{"GET", "POST"}, nil}, true},
+		{"same predicates", route{"/a", []string{"GET"}, []Predicate{beta}}, route{"/a", []string{"GET"}, []Predicate{mustPredicate(t, PredicateHeader, "x-beta")}}, true},
+		{"predicate order does not matter", route{"/a", []string{"GET"}, []Predicate{beta, mustPredicate(t, PredicateQuery, "v")}},
+			route{"/a", []string{"GET"}, []Predicate{mustPredicate(t, PredicateQuery, "v"), beta}}, true},
+		{"disjoint methods", route{"/a", []string{"GET"}, nil}, route{"/a", []string{"POST"}, nil}, false},
+		{"different predicates", route{"/a", []string{"GET"}, nil}, route{"/a", []string{"GET"}, []Predicate{beta}}, false},
+		{"param and static", route{"/u/{id}", []string{"GET"}, nil}, route{"/u/me", []string{"GET"}, nil}, false},
+		{"prefix and exact", route{"/a/*", []string{"GET"}, nil}, route{"/a", []string{"GET"}, nil}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			table := New()
+			if err := table.Add(tt.first.pattern, tt.first.methods, tt.first.preds, 0); err != nil {
+				t.Fatal(err)
+			}
+			err := table.Add(tt.second.pattern, tt.second.methods, tt.second.preds, 1)
+			if (err != nil) != tt.conflict {
+				t.Errorf("Add() error = %v, want conflict %v", err, tt.conflict)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		pattern string
+		ok      bool
+	}{
+		{"/a/{id}/b", true},
+		{"/a/*", true},
+		{"a", false},
+		{"/a/x{id}", false},
+		{"/a/{id}x", false},
+		{"/a/{}", false},
+		{"/a/{id", false},
+		{"/a/}", false},
+		{"/a/*/b", false},
+		{"/a*", false},
+	}
+	for _, tt := range tests {
+		if err := Validate(tt.pattern); (err == nil) != tt.ok {
+			t.Errorf("Validate(%q) error = %v, want ok %v", tt.pattern, err, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package router
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// node is a radix tree node. Static path bytes are compressed into prefix;
+// parameter and wildcard segments hang off dedicated children so lookups
+// can try them in precedence order: static, then {param}, then *.
+type node struct {
+	prefix   string
+	children []*node // static children, first bytes are unique
+	param    *node   // matches one non-empty path segment
+	wildcard *node   // matches the rest of the path, possibly empty
+
+	// entries terminating exactly at this node
+	entries []*entry
+	// prefix entries ("/x/*") that also accept the bare "/x" path; they
+	// rank below exact entries at the same node
+	bare []*entry
+}
+
+// segment is one token of a parsed pattern
+type segment struct {
+	static   string
+	param    string
+	wildcard bool
+}
+
+// parsePattern splits a route pattern into static, {param} and trailing *
+// segments
+func parsePattern(pattern string) ([]segment, error) {
+	if !strings.HasPrefix(pattern, "/") {
+		return nil, fmt.Errorf("pattern must start with '/'")
+	}
+
+	var segs []segment
+	var static strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch c {
+		case '{':
+			if pattern[i-1] != '/' {
+				return nil, fmt.Errorf("parameter at offset %d must start a path segment", i)
+			}
+			end := strings.IndexByte(pattern[i:], '}')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated parameter at offset %d", i)
+			}
+			name := pattern[i+1 : i+end]
+			if name == "" || strings.ContainsAny(name, "/{}*") {
+				return nil, fmt.Errorf("invalid parameter name %q", name)
+			}
+			i += end
+			if i+1 < len(pattern) && pattern[i+1] != '/' {
+				return nil, fmt.Errorf("parameter {%s} must end a path segment", name)
+			}
+			segs = append(segs, segment{static: static.String()}, segment{param: name})
+			static.Reset()
+		case '}':
+			return nil, fmt.Errorf("unexpected '}' at offset %d", i)
+		case '*':
+			if pattern[i-1] != '/' || i != len(pattern)-1 {
+				return nil, fmt.Errorf("wildcard must be the final segment, as in /prefix/*")
+			}
+			segs = append(segs, segment{static: static.String()}, segment{wildcard: true})
+			static.Reset()
+		default:
+			static.WriteByte(c)
+		}
+	}
+	if static.Len() > 0 {
+		segs = append(segs, segment{static: static.String()})
+	}
+	return segs, nil
+}
+
+// insert adds e at the node reached by segs, creating nodes as needed
+func (n *node) insert(segs []segment, e *entry) {
+	cur := n
+	for i, seg := range segs {
+		switch {
+		case seg.param != "":
+			if cur.param == nil {
+				cur.param = &node{}
+			}
+			cur = cur.param
+		case seg.wildcard:
+			// "/x/*" also matches "/x": register at the node for the
+			// static path without its trailing slash. Creating that node
+			// may split the one cur points at, so walk to cur again.
+			if prev := segs[i-1].static; prev != "" {
+				parent := n.walkStatic(segs[:i-1], prev[:len(prev)-1])
+				parent.bare = addEntry(parent.bare, e)
+				cur = n.walkStatic(segs[:i], "")
+			}
+			if cur.wildcard == nil {
+				cur.wildcard = &node{}
+			}
+			cur = cur.wildcard
+		default:
+			cur = cur.insertStatic(seg.static)
+		}
+	}
+	cur.entries = addEntry(cur.entries, e)
+}
+
+// addEntry inserts e keeping entries with more predicates first, so the
+// most specific route for a pattern is tried first. Ties keep config order.
+func addEntry(entries []*entry, e *entry) []*entry {
+	i := len(entries)
+	for i > 0 && len(entries[i-1].predicates) < len(e.predicates) {
+		i--
+	}
+	entries = append(entries, nil)
+	copy(entries[i+1:], entries[i:])
+	entries[i] = e
+	return entries
+}
+
+// walkStatic re-walks the node for segs followed by the static string s,
+// creating static nodes as needed. segs must already have been inserted and
+// must not contain a wildcard.
+func (n *node) walkStatic(segs []segment, s string) *node {
+	cur := n
+	for _, seg := range segs {
+		if seg.param != "" {
+			cur = cur.param
+		} else {
+			cur = cur.insertStatic(seg.static)
+		}
+	}
+	if s == "" {
+		return cur
+	}
+	return cur.insertStatic(s)
+}
+
+// insertStatic descends through static children along s, splitting nodes
+// so that a node ends exactly where s ends, and returns that node
+func (n *node) insertStatic(s string) *node {
+	cur := n
+	for s != "" {
+		var child *node
+		for _, c := range cur.children {
+			if c.prefix[0] == s[0] {
+				child = c
+				break
+			}
+		}
+
+		if child == nil {
+			child = &node{prefix: s}
+			cur.children = append(cur.children, child)
+			return child
+		}
+
+		common := commonPrefix(child.prefix, s)
+		if common < len(child.prefix) {
+			// Split child at the common prefix
+			split := &node{
+				prefix:   child.prefix[common:],
+				children: child.children,
+				param:    child.param,
+				wildcard: child.wildcard,
+				entries:  child.entries,
+				bare:     child.bare,
+			}
+			*child = node{prefix: child.prefix[:common], children: []*node{split}}
+		}
+		cur = child
+		s = s[common:]
+	}
+	return cur
+}
+
+// lookup finds the highest-precedence entry matching path whose method and
+// predicates accept r. values collects parameter values in pattern order.
+func (n *node) lookup(path string, r *http.Request, values []string) (*entry, []string) {
+	if path == "" {
+		if e := pick(n.entries, r); e != nil {
+			return e, values
+		}
+	}
+
+	if path != "" {
+		for _, c := range n.children {
+			if c.prefix[0] == path[0] && strings.HasPrefix(path, c.prefix) {
+				if e, v := c.lookup(path[len(c.prefix):], r, values); e != nil {
+					return e, v
+				}
+				break
+			}
+		}
+
+		if n.param != nil {
+			end := strings.IndexByte(path, '/')
+			if end < 0 {
+				end = len(path)
+			}
+			if end > 0 {
+				if e, v := n.param.lookup(path[end:], r, append(values, path[:end])); e != nil {
+					return e, v
+				}
+			}
+		}
+	}
+
+	if n.wildcard != nil {
+		if e := pick(n.wildcard.entries, r); e != nil {
+			return e, append(values, path)
+		}
+	}
+
+	if path == "" {
+		if e := pick(n.bare, r); e != nil {
+			return e, append(values, "")
+		}
+	}
+
+	return nil, nil
+}
+
+func pick(entries []*entry, r *http.Request) *entry {
+	for _, e := range entries {
+		if e.allows(r) {
+			return e
+		}
+	}
+	return nil
+}
+
+func commonPrefix(a, b string) int {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	return i
+}